@@ -35,6 +35,7 @@ func main() {
 			infoCmd,
 			accountCmd,
 			taskCmd,
+			spaceCmd,
 			walletCmd,
 			collateralCmd,
 			ubiTaskCmd,
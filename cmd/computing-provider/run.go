@@ -75,13 +75,18 @@ func cpManager(router *gin.RouterGroup) {
 	router.POST("/lagrange/jobs", computing.ReceiveJob)
 	router.POST("/lagrange/jobs/redeploy", computing.RedeployJob)
 	router.DELETE("/lagrange/jobs", computing.CancelJob)
+	router.GET("/lagrange/jobs/:task_uuid", computing.GetJobDetail)
 	router.GET("/lagrange/cp", computing.StatisticalSources)
 	router.POST("/lagrange/jobs/renew", computing.ReNewJob)
+	router.POST("/lagrange/jobs/scale", computing.ScaleJob)
+	router.POST("/lagrange/jobs/pause", computing.PauseJob)
+	router.POST("/lagrange/jobs/resume", computing.ResumeJob)
 	router.GET("/lagrange/spaces/log", computing.GetSpaceLog)
 	router.POST("/lagrange/cp/proof", computing.DoProof)
 
 	router.GET("/cp", computing.StatisticalSources)
 	router.GET("/cp/info", computing.GetCpInfo)
+	router.GET("/cp/cache", computing.GetClusterCacheStatus)
 	router.POST("/cp/ubi", computing.DoUbiTaskForK8s)
 	router.POST("/cp/receive/ubi", computing.ReceiveUbiProofForK8s)
 
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/swanchain/go-computing-provider/internal/yaml"
+	"github.com/urfave/cli/v2"
+)
+
+var spaceCmd = &cli.Command{
+	Name:  "space",
+	Usage: "Manage space files",
+	Subcommands: []*cli.Command{
+		spaceValidate,
+	},
+}
+
+var spaceValidate = &cli.Command{
+	Name:      "validate",
+	Usage:     "Validate a deploy.yaml or a docker compose file without deploying it",
+	ArgsUsage: "[deploy.yaml]",
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("incorrect number of arguments, got %d, missing args: deploy.yaml", cctx.NArg())
+		}
+
+		yamlPath := cctx.Args().First()
+		err := yaml.ValidateFile(yamlPath)
+		if err == nil {
+			fmt.Printf("%s is valid\n", yamlPath)
+			return nil
+		}
+
+		var validationErrs yaml.ValidationErrors
+		if !errors.As(err, &validationErrs) {
+			return err
+		}
+		for _, validationErr := range validationErrs {
+			if validationErr.Line == 0 {
+				fmt.Printf("%s: %s\n", yamlPath, validationErr.Message)
+				continue
+			}
+			fmt.Printf("%s:%d:%d: %s\n", yamlPath, validationErr.Line, validationErr.Column, validationErr.Message)
+		}
+		return fmt.Errorf("%s is not valid", yamlPath)
+	},
+}
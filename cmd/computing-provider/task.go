@@ -23,6 +23,8 @@ var taskCmd = &cli.Command{
 		taskList,
 		taskDetail,
 		taskDelete,
+		taskPause,
+		taskResume,
 	},
 }
 
@@ -67,6 +69,9 @@ var taskList = &cli.Command{
 			if err != nil {
 				return fmt.Errorf("failed get job status: %s, error: %+v", jobDetail.JobUuid, err)
 			}
+			if jobDetail.PausedAt > 0 {
+				status = "Paused"
+			}
 
 			var fullSpaceUuid string
 			if len(jobDetail.DeployName) > 0 {
@@ -97,7 +102,7 @@ var taskList = &cli.Command{
 			}
 
 			var rowColor []tablewriter.Colors
-			if status == "Pending" {
+			if status == "Pending" || status == "Paused" {
 				rowColor = []tablewriter.Colors{{tablewriter.Bold, tablewriter.FgYellowColor}}
 			} else if status == "Running" {
 				rowColor = []tablewriter.Colors{{tablewriter.Bold, tablewriter.FgGreenColor}}
@@ -151,6 +156,9 @@ var taskDetail = &cli.Command{
 		if err != nil {
 			return fmt.Errorf("failed get job status: %s, error: %+v", jobDetail.JobUuid, err)
 		}
+		if jobDetail.PausedAt > 0 {
+			status = "Paused"
+		}
 
 		var taskData [][]string
 		taskData = append(taskData, []string{"TASK TYPE:", jobDetail.TaskType})
@@ -161,7 +169,7 @@ var taskDetail = &cli.Command{
 		taskData = append(taskData, []string{"STATUS:", status})
 
 		var rowColor []tablewriter.Colors
-		if status == "Pending" {
+		if status == "Pending" || status == "Paused" {
 			rowColor = []tablewriter.Colors{{tablewriter.Bold, tablewriter.FgYellowColor}, {tablewriter.Bold, tablewriter.FgWhiteColor}}
 		} else if status == "Running" {
 			rowColor = []tablewriter.Colors{{tablewriter.Bold, tablewriter.FgGreenColor}, {tablewriter.Bold, tablewriter.FgWhiteColor}}
@@ -207,7 +215,7 @@ var taskDelete = &cli.Command{
 		}
 
 		deployName := constants.K8S_DEPLOY_NAME_PREFIX + spaceUuid
-		namespace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(jobDetail.WalletAddress)
+		namespace := computing.SpaceNamespace(jobDetail.WalletAddress)
 		k8sService := computing.NewK8sService()
 		if err := k8sService.DeleteDeployment(context.TODO(), namespace, deployName); err != nil && !errors.IsNotFound(err) {
 			return err
@@ -224,3 +232,57 @@ var taskDelete = &cli.Command{
 		return nil
 	},
 }
+
+var taskPause = &cli.Command{
+	Name:      "pause",
+	Usage:     "Pause a task, the space keeps its service and ingress",
+	ArgsUsage: "[space_uuid]",
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("incorrect number of arguments, got %d, missing args: space_uuid", cctx.NArg())
+		}
+
+		cpRepoPath, ok := os.LookupEnv("CP_PATH")
+		if !ok {
+			return fmt.Errorf("missing CP_PATH env, please set export CP_PATH=<YOUR CP_PATH>")
+		}
+		if err := conf.InitConfig(cpRepoPath, false); err != nil {
+			return fmt.Errorf("load config file failed, error: %+v", err)
+		}
+		computing.GetRedisClient()
+
+		spaceUuid := strings.ToLower(cctx.Args().First())
+		if err := computing.PauseSpace(spaceUuid); err != nil {
+			return fmt.Errorf("failed pause task: %s, error: %+v", spaceUuid, err)
+		}
+		fmt.Printf("space %s paused\n", spaceUuid)
+		return nil
+	},
+}
+
+var taskResume = &cli.Command{
+	Name:      "resume",
+	Usage:     "Resume a paused task",
+	ArgsUsage: "[space_uuid]",
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("incorrect number of arguments, got %d, missing args: space_uuid", cctx.NArg())
+		}
+
+		cpRepoPath, ok := os.LookupEnv("CP_PATH")
+		if !ok {
+			return fmt.Errorf("missing CP_PATH env, please set export CP_PATH=<YOUR CP_PATH>")
+		}
+		if err := conf.InitConfig(cpRepoPath, false); err != nil {
+			return fmt.Errorf("load config file failed, error: %+v", err)
+		}
+		computing.GetRedisClient()
+
+		spaceUuid := strings.ToLower(cctx.Args().First())
+		if err := computing.ResumeSpace(spaceUuid); err != nil {
+			return fmt.Errorf("failed resume task: %s, error: %+v", spaceUuid, err)
+		}
+		fmt.Printf("space %s resumed\n", spaceUuid)
+		return nil
+	},
+}
@@ -19,14 +19,18 @@ const (
 
 // ComputeNode is a compute node config
 type ComputeNode struct {
-	API      API
-	UBI      UBI
-	LOG      LOG
-	HUB      HUB
-	MCS      MCS
-	Registry Registry
-	RPC      RPC
-	CONTRACT CONTRACT
+	API           API
+	UBI           UBI
+	LOG           LOG
+	HUB           HUB
+	MCS           MCS
+	Registry      Registry
+	RPC           RPC
+	CONTRACT      CONTRACT
+	SPACE         SPACE
+	K8S           K8S
+	NetworkPolicy NetworkPolicy
+	Quota         Quota
 }
 
 type API struct {
@@ -82,6 +86,68 @@ type CONTRACT struct {
 	Collateral string `toml:"SWAN_COLLATERAL_CONTRACT"`
 }
 
+type SPACE struct {
+	ExpireNotice           int64  // seconds before expiry that the space is notified
+	TerminationGracePeriod int64  // seconds given to the space pods to shut down
+	PausedExpirePolicy     string // "frozen" or "counting", whether the lease keeps running while paused
+	RolloutTimeout         int64  // seconds a redeployed space may take to become ready before it is rolled back
+	StorageClass           string // storage class of the persistent volume claims, empty for the cluster default
+	MaxCustomDomains       int    // the number of custom domains a space may bring
+	CustomDomainIssuer     string // cert-manager ClusterIssuer of the custom domain certificates, empty if the secrets are provided
+	NodePortRange          string // "min-max", the node ports given to the tcp and udp ports of spaces, empty for the cluster to allocate them
+	ModelCacheDir          string // directory on the nodes the models of spaces are cached in, empty to cache per pod
+	ModelCacheSize         string // size the models in ModelCacheDir are kept below, the least recently used are deleted, default 100Gi
+	ModelDownloadRetries   int    // attempts to download a model before the space fails
+	MaxConfigSize          int64  // bytes of config files a space may mount
+}
+
+type K8S struct {
+	KubeConfig         string            // path of the kubeconfig, empty for the in-cluster config or ~/.kube/config
+	Context            string            // context of the kubeconfig, empty for its current context
+	QPS                float32           // requests per second to the API server
+	Burst              int               // requests allowed above QPS in a burst
+	Timeout            int64             // seconds a request to the API server may take, 0 for no limit
+	IngressProvider    string            // routing of the spaces: nginx, ingress or gateway
+	IngressClass       string            // ingress class of the space ingresses
+	IngressAnnotations map[string]string // annotations added to the space ingresses
+	IngressTLSSecret   string            // secret with the certificate of the space hosts, empty if tls is terminated before the ingress
+	Gateway            string            // namespace/name of the Gateway the space HTTPRoutes attach to
+	GatewayListener    string            // listener of the Gateway, empty for all of them
+	NamespacePrefix    string            // prefix of the namespaces of the wallets
+	SystemNamespace    string            // namespace resource-exporter runs in
+}
+
+type NetworkPolicy struct {
+	Enable            bool     // isolate the wallet and ubi task namespaces with NetworkPolicies
+	IngressNamespaces []string // namespaces of the ingress controller or gateway that may reach the spaces
+	Egress            string   // egress of the pods: public, all or none
+	ClusterCIDRs      []string // cidrs of the nodes, pods and services, blocked with the metadata ip when Egress is public
+	AllowedCIDRs      []string // cidrs the pods may always reach
+}
+
+type Quota struct {
+	Enable      bool                 // limit the resources of the wallet namespaces with a ResourceQuota and a LimitRange
+	DefaultTier string               // tier of the wallets that are not in Wallets
+	Wallets     map[string]string    // tier of a wallet address
+	Tiers       map[string]QuotaTier // tiers by name
+}
+
+// QuotaTier is the resources the spaces of a wallet may request together, an
+// empty value is not limited.
+type QuotaTier struct {
+	Cpu                            string // cpu requested by all pods
+	Memory                         string // memory requested by all pods
+	EphemeralStorage               string // ephemeral storage requested by all pods
+	Gpu                            string // nvidia.com/gpu requested by all pods
+	Pods                           string // number of pods
+	DefaultCpu                     string // cpu limit of containers without one
+	DefaultMemory                  string // memory limit of containers without one
+	DefaultEphemeralStorage        string // ephemeral storage limit of containers without one
+	DefaultRequestCpu              string // cpu request of containers without one
+	DefaultRequestMemory           string // memory request of containers without one
+	DefaultRequestEphemeralStorage string // ephemeral storage request of containers without one
+}
+
 func GetRpcByName(rpcName string) (string, error) {
 	var rpc string
 	switch rpcName {
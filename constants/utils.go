@@ -18,8 +18,18 @@ const K8S_CONTAINER_NAME_PREFIX = "pod-"
 const K8S_INGRESS_NAME_PREFIX = "ing-"
 const K8S_SERVICE_NAME_PREFIX = "svc-"
 const K8S_DEPLOY_NAME_PREFIX = "deploy-"
+const K8S_NOTICE_NAME_PREFIX = "notice-"
+const K8S_PVC_NAME_PREFIX = "pvc-"
+const K8S_TLS_SECRET_NAME_PREFIX = "tls-"
+const K8S_SECRET_NAME_PREFIX = "secret-"
+const K8S_CONFIG_NAME_PREFIX = "config-"
+const K8S_NODEPORT_SERVICE_SUFFIX = "-nodeport"
+const K8S_DOMAINS_INGRESS_SUFFIX = "-domains"
+const K8S_UBI_NAMESPACE_PREFIX = "ubi-task-"
 
 const REDIS_SPACE_PREFIX = "FULL:"
+const REDIS_JOB_PREFIX = "JOB:"
+const REDIS_ACTIVE_JOBS = "JOBS:ACTIVE"
 const REDIS_UBI_C2_PERFIX = "UBI-C2:"
 const REDIS_REGION_PERFIX = "REGION:IP"
 const UBI_TASK_RECEIVED_STATUS = "received"
@@ -6,6 +6,7 @@ import (
 	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
 	"github.com/swanchain/go-computing-provider/conf"
 	"github.com/swanchain/go-computing-provider/internal/models"
+	"github.com/swanchain/go-computing-provider/internal/yaml"
 	"io"
 	"io/fs"
 	"log"
@@ -35,6 +36,7 @@ func BuildSpaceTaskImage(spaceUuid string, files []models.SpaceFile) (bool, stri
 		imagePath := filepath.Join(buildFolder, getDownloadPath(files[0].Name))
 		var containsYaml bool
 		var yamlPath string
+		var composePath string
 		var modelsSetting string
 
 		err = filepath.WalkDir(imagePath, func(path string, d fs.DirEntry, err error) error {
@@ -42,6 +44,9 @@ func BuildSpaceTaskImage(spaceUuid string, files []models.SpaceFile) (bool, stri
 				containsYaml = true
 				yamlPath = path
 			}
+			if yaml.IsComposeFile(d.Name()) && composePath == "" {
+				composePath = path
+			}
 			if strings.EqualFold(d.Name(), "model-setting.json") {
 				modelsSetting = path
 			}
@@ -50,6 +55,11 @@ func BuildSpaceTaskImage(spaceUuid string, files []models.SpaceFile) (bool, stri
 		if err != nil {
 			return containsYaml, yamlPath, imagePath, modelsSetting, "", err
 		}
+		// a deploy.yaml wins over a compose file shipped next to it
+		if !containsYaml && composePath != "" {
+			containsYaml = true
+			yamlPath = composePath
+		}
 		return containsYaml, yamlPath, imagePath, modelsSetting, "", nil
 	} else {
 		logs.GetLogger().Warnf("Space %s is not found.", spaceUuid)
@@ -62,7 +72,7 @@ func getDownloadPath(fileName string) string {
 	return filepath.Join(splits[0], splits[1], splits[2])
 }
 
-func BuildImagesByDockerfile(jobUuid, spaceUuid, spaceName, imagePath string) (string, string) {
+func BuildImagesByDockerfile(jobUuid, spaceUuid, spaceName, imagePath string) (string, string, error) {
 	updateJobStatus(jobUuid, models.JobBuildImage)
 	spaceFlag := spaceName + spaceUuid[strings.LastIndex(spaceUuid, "-"):]
 	imageName := fmt.Sprintf("lagrange/%s:%d", spaceFlag, time.Now().Unix())
@@ -79,18 +89,16 @@ func BuildImagesByDockerfile(jobUuid, spaceUuid, spaceName, imagePath string) (s
 
 	dockerService := NewDockerService()
 	if err := dockerService.BuildImage(imagePath, imageName); err != nil {
-		logs.GetLogger().Errorf("Error building Docker image: %v", err)
-		return "", ""
+		return "", "", models.NewJobFailure(models.FailureImageBuild, "image build failed: %v", err)
 	}
 
 	if conf.GetConfig().Registry.ServerAddress != "" {
 		updateJobStatus(jobUuid, models.JobPushImage)
 		if err := dockerService.PushImage(imageName); err != nil {
-			logs.GetLogger().Errorf("Error Docker push image: %v", err)
-			return "", ""
+			return "", "", models.NewJobFailure(models.FailureImagePush, "image push failed: %v", err)
 		}
 	}
-	return imageName, dockerfilePath
+	return imageName, dockerfilePath, nil
 }
 
 func downloadFile(filepath string, url string) error {
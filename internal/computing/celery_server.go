@@ -50,6 +50,30 @@ func GetRedisClient() redis.Conn {
 	return redisPool.Get()
 }
 
+// scanKeys returns the keys matching the pattern, it iterates with SCAN so
+// that redis is not blocked like by KEYS.
+func scanKeys(conn redis.Conn, pattern string) ([]string, error) {
+	var keys []string
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 1000))
+		if err != nil {
+			return nil, err
+		}
+		if cursor, err = redis.Int(values[0], nil); err != nil {
+			return nil, err
+		}
+		batch, err := redis.Strings(values[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, batch...)
+		if cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
 func NewCeleryService() *CeleryService {
 	celeryOnce.Do(
 		func() {
@@ -17,6 +17,7 @@ import (
 	"github.com/swanchain/go-computing-provider/conf"
 	"github.com/swanchain/go-computing-provider/constants"
 	"github.com/swanchain/go-computing-provider/internal/models"
+	"github.com/swanchain/go-computing-provider/internal/yaml"
 	"github.com/swanchain/go-computing-provider/util"
 	"io"
 	batchv1 "k8s.io/api/batch/v1"
@@ -29,12 +30,15 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 )
 
+const jobEventLimit = 20
+
 func GetCpInfo(c *gin.Context) {
 	var info struct {
 		NodeId       string `json:"node_id"`
@@ -56,6 +60,12 @@ func GetCpInfo(c *gin.Context) {
 	c.JSON(http.StatusOK, util.CreateSuccessResponse(info))
 }
 
+// GetClusterCacheStatus reports the freshness of the cluster cache the
+// resource accounting is answered from.
+func GetClusterCacheStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, util.CreateSuccessResponse(NewK8sService().ClusterCacheStatus()))
+}
+
 func GetServiceProviderInfo(c *gin.Context) {
 	info := new(models.HostInfo)
 	info.SwanProviderVersion = build.UserVersion()
@@ -101,14 +111,36 @@ func ReceiveJob(c *gin.Context) {
 		}
 	}
 
+	if !jobData.Force {
+		if receivedJob, err := retrieveJobData(jobData.UUID); err == nil && strings.EqualFold(receivedJob.TaskUUID, jobData.TaskUUID) {
+			logs.GetLogger().Infof("job_uuid: %s has already been received, return the original job data", jobData.UUID)
+			c.JSON(http.StatusOK, receivedJob)
+			return
+		}
+	}
+
+	customDomains, err := validateCustomDomains(jobData.CustomDomains)
+	if err != nil {
+		logs.GetLogger().Warnf("task_uuid: %s, %v", jobData.TaskUUID, err)
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.CustomDomainError, err.Error()))
+		return
+	}
+	jobData.CustomDomains = customDomains
+
 	spaceDetail, err := getSpaceDetail(jobData.JobSourceURI)
 	if err != nil {
 		logs.GetLogger().Errorln(err)
 		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.CheckResourcesError, "Failed to retrieve resource configuration"))
 		return
 	}
+	if err = checkCustomDomainOwners(strings.ToLower(spaceDetail.Data.Space.Uuid), jobData.CustomDomains); err != nil {
+		logs.GetLogger().Warnf("task_uuid: %s, %v", jobData.TaskUUID, err)
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.CustomDomainError, err.Error()))
+		return
+	}
 
-	available, gpuProductName, err := checkResourceAvailableForSpace(spaceDetail.Data.Space.ActiveOrder.Config.Description)
+	// admit the job only if the replicas paid by the order fit
+	available, gpuProductName, err := checkResourceAvailableForSpace(spaceDetail.Data.Space.ActiveOrder.Config.Description, spaceDetail.OrderReplicas(), strings.ToLower(spaceDetail.Data.Space.Uuid))
 	if err != nil {
 		logs.GetLogger().Errorf("check job resource failed, error: %+v", err)
 		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.CheckResourcesError))
@@ -121,20 +153,9 @@ func ReceiveJob(c *gin.Context) {
 		return
 	}
 
-	var hostName string
-	var logHost string
-	prefixStr := generateString(10)
-	if strings.HasPrefix(conf.GetConfig().API.Domain, ".") {
-		hostName = prefixStr + conf.GetConfig().API.Domain
-		logHost = "log" + conf.GetConfig().API.Domain
-	} else {
-		hostName = strings.Join([]string{prefixStr, conf.GetConfig().API.Domain}, ".")
-		logHost = "log." + conf.GetConfig().API.Domain
-	}
-
-	if _, err = celeryService.DelayTask(constants.TASK_DEPLOY, jobData.JobSourceURI, hostName, jobData.Duration, jobData.UUID, jobData.TaskUUID, gpuProductName); err != nil {
-		logs.GetLogger().Errorf("Failed sync delpoy task, error: %v", err)
-		return
+	hostName, logHost := generateHostName()
+	if receivedJob, err := retrieveJobData(jobData.UUID); err == nil && receivedJob.JobRealUri != "" {
+		hostName = strings.TrimPrefix(receivedJob.JobRealUri, "https://")
 	}
 
 	jobData.JobResultURI = fmt.Sprintf("https://%s", hostName)
@@ -146,13 +167,43 @@ func ReceiveJob(c *gin.Context) {
 	jobData.ContainerLog = wsUrl + "&type=container"
 	jobData.JobRealUri = jobData.JobResultURI
 
+	if err = saveJobReceived(models.Job{
+		Uuid:          jobData.UUID,
+		TaskUuid:      jobData.TaskUUID,
+		SpaceUuid:     strings.ToLower(spaceDetail.Data.Space.Uuid),
+		WalletAddress: spaceDetail.Data.Owner.PublicAddress,
+	}, jobData); err != nil {
+		logs.GetLogger().Errorf("Failed save job status, error: %v", err)
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.JobStatusError, err.Error()))
+		return
+	}
+
+	if _, err = celeryService.DelayTask(constants.TASK_DEPLOY, jobData.JobSourceURI, hostName, jobData.Duration, jobData.UUID, jobData.TaskUUID, gpuProductName); err != nil {
+		logs.GetLogger().Errorf("Failed sync delpoy task, error: %v", err)
+		updateJobStatus(jobData.UUID, models.JobFailed)
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.ServerError, "failed to queue the deploy task"))
+		return
+	}
+
 	if err = submitJob(&jobData); err != nil {
 		jobData.JobResultURI = ""
 	}
+	if err = saveJobData(jobData); err != nil {
+		logs.GetLogger().Errorf("Failed save job data, job_uuid: %s, error: %v", jobData.UUID, err)
+	}
 	logs.GetLogger().Infof("submit job detail: %+v", jobData)
 	c.JSON(http.StatusOK, jobData)
 }
 
+// generateHostName returns a random host for a space and the host serving its logs.
+func generateHostName() (string, string) {
+	prefixStr := generateString(10)
+	if strings.HasPrefix(conf.GetConfig().API.Domain, ".") {
+		return prefixStr + conf.GetConfig().API.Domain, "log" + conf.GetConfig().API.Domain
+	}
+	return strings.Join([]string{prefixStr, conf.GetConfig().API.Domain}, "."), "log." + conf.GetConfig().API.Domain
+}
+
 func submitJob(jobData *models.JobData) error {
 	logs.GetLogger().Printf("submitting job...")
 	oldMask := syscall.Umask(0)
@@ -202,14 +253,43 @@ func RedeployJob(c *gin.Context) {
 	}
 	logs.GetLogger().Infof("redeploy Job received: %+v", jobData)
 
+	if !jobData.Force {
+		if receivedJob, err := retrieveJobData(jobData.UUID); err == nil {
+			if strings.EqualFold(receivedJob.TaskUUID, jobData.TaskUUID) {
+				logs.GetLogger().Infof("job_uuid: %s has already been redeployed, return the original job data", jobData.UUID)
+				c.JSON(http.StatusOK, receivedJob)
+				return
+			}
+			if job, err := RetrieveJob(jobData.UUID); err == nil && !job.Status.CanTransitionTo(models.JobReceived) {
+				logs.GetLogger().Infof("job_uuid: %s is being redeployed, current status: %s, return the original job data", jobData.UUID, job.Status)
+				c.JSON(http.StatusOK, receivedJob)
+				return
+			}
+		}
+	}
+
+	customDomains, err := validateCustomDomains(jobData.CustomDomains)
+	if err != nil {
+		logs.GetLogger().Warnf("task_uuid: %s, %v", jobData.TaskUUID, err)
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.CustomDomainError, err.Error()))
+		return
+	}
+	jobData.CustomDomains = customDomains
+
 	spaceDetail, err := getSpaceDetail(jobData.JobSourceURI)
 	if err != nil {
 		logs.GetLogger().Errorln(err)
 		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.CheckResourcesError, "Failed to retrieve resource configuration"))
 		return
 	}
+	if err = checkCustomDomainOwners(strings.ToLower(spaceDetail.Data.Space.Uuid), jobData.CustomDomains); err != nil {
+		logs.GetLogger().Warnf("task_uuid: %s, %v", jobData.TaskUUID, err)
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.CustomDomainError, err.Error()))
+		return
+	}
 
-	available, gpuProductName, err := checkResourceAvailableForSpace(spaceDetail.Data.Space.ActiveOrder.Config.Description)
+	// admit the job only if the replicas paid by the order fit
+	available, gpuProductName, err := checkResourceAvailableForSpace(spaceDetail.Data.Space.ActiveOrder.Config.Description, spaceDetail.OrderReplicas(), strings.ToLower(spaceDetail.Data.Space.Uuid))
 	if err != nil {
 		logs.GetLogger().Errorf("check job resource failed, error: %+v", err)
 		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.CheckResourcesError))
@@ -251,13 +331,32 @@ func RedeployJob(c *gin.Context) {
 			return
 		}
 		hostName = strings.ReplaceAll(hostInfo.JobResultUri, "https://", "")
+	} else if spaceMetadata, err := RetrieveJobMetadata(constants.REDIS_SPACE_PREFIX + strings.ToLower(spaceDetail.Data.Space.Uuid)); err == nil && spaceMetadata.Url != "" {
+		// keep serving the space under the same hostname
+		hostName = strings.TrimPrefix(spaceMetadata.Url, "https://")
 	} else {
-		hostName = generateString(10) + conf.GetConfig().API.Domain
+		hostName, _ = generateHostName()
 	}
 
-	delayTask, err := celeryService.DelayTask(constants.TASK_DEPLOY, jobData.JobResultURI, hostName, jobData.Duration, jobData.UUID, jobData.TaskUUID, gpuProductName)
+	var jobResultURI = jobData.JobResultURI
+	jobData.JobResultURI = fmt.Sprintf("https://%s", hostName)
+	jobData.JobRealUri = jobData.JobResultURI
+	if err = saveJobReceived(models.Job{
+		Uuid:          jobData.UUID,
+		TaskUuid:      jobData.TaskUUID,
+		SpaceUuid:     strings.ToLower(spaceDetail.Data.Space.Uuid),
+		WalletAddress: spaceDetail.Data.Owner.PublicAddress,
+	}, jobData); err != nil {
+		logs.GetLogger().Errorf("Failed save job status, error: %v", err)
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.JobStatusError, err.Error()))
+		return
+	}
+
+	delayTask, err := celeryService.DelayTask(constants.TASK_DEPLOY, jobResultURI, hostName, jobData.Duration, jobData.UUID, jobData.TaskUUID, gpuProductName)
 	if err != nil {
 		logs.GetLogger().Errorf("Failed sync delpoy task, error: %v", err)
+		updateJobStatus(jobData.UUID, models.JobFailed)
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.ServerError, "failed to queue the deploy task"))
 		return
 	}
 	logs.GetLogger().Infof("delayTask detail info: %+v", delayTask)
@@ -268,13 +367,15 @@ func RedeployJob(c *gin.Context) {
 			logs.GetLogger().Errorf("Failed get sync task result, error: %v", err)
 			return
 		}
-		logs.GetLogger().Infof("Job: %s, service running successfully, job_result_url: %s", jobData.JobResultURI, result.(string))
+		logs.GetLogger().Infof("Job: %s, service running successfully, job_result_url: %s", jobResultURI, result.(string))
 	}()
 
-	jobData.JobResultURI = fmt.Sprintf("https://%s", hostName)
 	if err = submitJob(&jobData); err != nil {
 		jobData.JobResultURI = ""
 	}
+	if err = saveJobData(jobData); err != nil {
+		logs.GetLogger().Errorf("Failed save job data, job_uuid: %s, error: %v", jobData.UUID, err)
+	}
 	c.JSON(http.StatusOK, jobData)
 }
 
@@ -300,28 +401,13 @@ func ReNewJob(c *gin.Context) {
 		return
 	}
 
-	conn := redisPool.Get()
-	prefix := constants.REDIS_SPACE_PREFIX + "*"
-	keys, err := redis.Strings(conn.Do("KEYS", prefix))
-	if err != nil {
-		logs.GetLogger().Errorf("Failed get redis %s prefix, error: %+v", prefix, err)
+	spaceDetail, err := findJobMetadataByTaskUuid(jobData.TaskUuid)
+	if err != nil && err != NotFoundRedisKey {
+		logs.GetLogger().Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query data failed"})
 		return
 	}
 
-	var spaceDetail models.CacheSpaceDetail
-	for _, key := range keys {
-		jobMetadata, err := RetrieveJobMetadata(key)
-		if err != nil {
-			logs.GetLogger().Errorf("Failed get redis key data, key: %s, error: %+v", key, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "query data failed"})
-			return
-		}
-		if strings.EqualFold(jobMetadata.TaskUuid, jobData.TaskUuid) {
-			spaceDetail = jobMetadata
-			break
-		}
-	}
-
 	redisKey := constants.REDIS_SPACE_PREFIX + spaceDetail.SpaceUuid
 	leftTime := spaceDetail.ExpireTime - time.Now().Unix()
 	if leftTime < 0 {
@@ -354,10 +440,141 @@ func ReNewJob(c *gin.Context) {
 
 		redisConn.Do("HSET", fullArgs...)
 		redisConn.Do("SET", spaceDetail.SpaceUuid, "wait-delete", "EX", int(leftTime)+jobData.Duration)
+
+		k8sNameSpace := SpaceNamespace(spaceDetail.WalletAddress)
+		if err = createSpaceNotice(k8sNameSpace, spaceDetail.SpaceUuid, time.Now().Unix()+leftTime+int64(jobData.Duration)); err != nil {
+			logs.GetLogger().Errorf("Failed reset notice config map, space_uuid: %s, error: %+v", spaceDetail.SpaceUuid, err)
+		}
 	}
 	c.JSON(http.StatusOK, util.CreateSuccessResponse("success"))
 }
 
+// ScaleJob changes the replica count of a running space. The space can be
+// scaled between one replica and the replica count paid by its order.
+func ScaleJob(c *gin.Context) {
+	var scaleReq struct {
+		TaskUuid string `json:"task_uuid"`
+		Replicas int    `json:"replicas"`
+	}
+
+	if err := c.ShouldBindJSON(&scaleReq); err != nil {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.JsonError))
+		return
+	}
+	logs.GetLogger().Infof("scale Job received: %+v", scaleReq)
+
+	if strings.TrimSpace(scaleReq.TaskUuid) == "" {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.BadParamError, "missing required field: task_uuid"))
+		return
+	}
+
+	spaceDetail, err := findJobMetadataByTaskUuid(scaleReq.TaskUuid)
+	if err != nil {
+		if err == NotFoundRedisKey {
+			c.JSON(http.StatusNotFound, util.CreateErrorResponse(util.NotFoundJobError))
+			return
+		}
+		logs.GetLogger().Error(err)
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.ServerError, "query data failed"))
+		return
+	}
+
+	if spaceDetail.PausedAt > 0 {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.JobStatusError, "the space is paused"))
+		return
+	}
+
+	if err = models.CheckReplicas(scaleReq.Replicas, spaceDetail.MaxReplicas); err != nil {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.BadParamError, err.Error()))
+		return
+	}
+
+	if scaleReq.Replicas > spaceDetail.Replicas {
+		available, _, err := checkResourceAvailableForSpace(spaceDetail.Hardware, scaleReq.Replicas-spaceDetail.Replicas, "")
+		if err != nil {
+			logs.GetLogger().Errorf("check job resource failed, error: %+v", err)
+			c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.CheckResourcesError))
+			return
+		}
+		if !available {
+			logs.GetLogger().Warnf("task_uuid: %s, not found a resources available for %d replicas", scaleReq.TaskUuid, scaleReq.Replicas)
+			c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.CheckAvailableResources))
+			return
+		}
+	}
+
+	k8sNameSpace := SpaceNamespace(spaceDetail.WalletAddress)
+	deployName := constants.K8S_DEPLOY_NAME_PREFIX + spaceDetail.SpaceUuid
+	if err = NewK8sService().ScaleDeployment(context.TODO(), k8sNameSpace, deployName, int32(scaleReq.Replicas)); err != nil {
+		logs.GetLogger().Errorf("task_uuid: %s, scale deployment failed, error: %+v", scaleReq.TaskUuid, err)
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.ScaleJobError, err.Error()))
+		return
+	}
+
+	redisConn := redisPool.Get()
+	defer redisConn.Close()
+	redisConn.Do("HSET", constants.REDIS_SPACE_PREFIX+spaceDetail.SpaceUuid, "replicas", scaleReq.Replicas)
+
+	c.JSON(http.StatusOK, util.CreateSuccessResponse("success"))
+}
+
+func PauseJob(c *gin.Context) {
+	changeJobPauseState(c, true)
+}
+
+func ResumeJob(c *gin.Context) {
+	changeJobPauseState(c, false)
+}
+
+func changeJobPauseState(c *gin.Context, pause bool) {
+	var jobReq struct {
+		TaskUuid string `json:"task_uuid"`
+	}
+
+	if err := c.ShouldBindJSON(&jobReq); err != nil {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.JsonError))
+		return
+	}
+	logs.GetLogger().Infof("pause Job received: %+v, pause: %v", jobReq, pause)
+
+	if strings.TrimSpace(jobReq.TaskUuid) == "" {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.BadParamError, "missing required field: task_uuid"))
+		return
+	}
+
+	spaceDetail, err := findJobMetadataByTaskUuid(jobReq.TaskUuid)
+	if err != nil {
+		if err == NotFoundRedisKey {
+			c.JSON(http.StatusNotFound, util.CreateErrorResponse(util.NotFoundJobError))
+			return
+		}
+		logs.GetLogger().Error(err)
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.ServerError, "query data failed"))
+		return
+	}
+
+	if pause {
+		err = PauseSpace(spaceDetail.SpaceUuid)
+	} else {
+		err = ResumeSpace(spaceDetail.SpaceUuid)
+	}
+	if err != nil {
+		logs.GetLogger().Errorf("task_uuid: %s, change pause state failed, error: %+v", jobReq.TaskUuid, err)
+		if err == ErrNoResourcesAvailable {
+			c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.CheckAvailableResources))
+			return
+		}
+		if err == ErrJobNotRunning {
+			c.JSON(http.StatusConflict, util.CreateErrorResponse(util.JobStatusError, err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.JobStatusError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, util.CreateSuccessResponse("success"))
+}
+
 func CancelJob(c *gin.Context) {
 	taskUuid := c.Query("task_uuid")
 	if taskUuid == "" {
@@ -390,28 +607,13 @@ func CancelJob(c *gin.Context) {
 		}
 	}
 
-	conn := redisPool.Get()
-	prefix := constants.REDIS_SPACE_PREFIX + "*"
-	keys, err := redis.Strings(conn.Do("KEYS", prefix))
-	if err != nil {
-		logs.GetLogger().Errorf("Failed get redis %s prefix, error: %+v", prefix, err)
+	jobDetail, err := findJobMetadataByTaskUuid(taskUuid)
+	if err != nil && err != NotFoundRedisKey {
+		logs.GetLogger().Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query data failed"})
 		return
 	}
 
-	var jobDetail models.CacheSpaceDetail
-	for _, key := range keys {
-		jobMetadata, err := RetrieveJobMetadata(key)
-		if err != nil {
-			logs.GetLogger().Errorf("Failed get redis key data for , key: %s, error: %+v", key, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "query data failed"})
-			return
-		}
-		if strings.EqualFold(jobMetadata.TaskUuid, taskUuid) {
-			jobDetail = jobMetadata
-			break
-		}
-	}
-
 	if jobDetail.WalletAddress == "" {
 		c.JSON(http.StatusOK, util.CreateSuccessResponse("deleted success"))
 		return
@@ -423,13 +625,121 @@ func CancelJob(c *gin.Context) {
 				return
 			}
 		}()
-		k8sNameSpace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(jobDetail.WalletAddress)
-		deleteJob(k8sNameSpace, jobDetail.SpaceUuid)
+		k8sNameSpace := SpaceNamespace(jobDetail.WalletAddress)
+		saveFinalContainerLog(k8sNameSpace, jobDetail)
+		if err := deleteJob(k8sNameSpace, jobDetail.SpaceUuid); err == nil {
+			deleteSpaceVolumes(k8sNameSpace, jobDetail.SpaceUuid)
+			updateJobStatus(jobDetail.JobUuid, models.JobCancelled)
+		}
 	}()
 
 	c.JSON(http.StatusOK, util.CreateSuccessResponse("deleted success"))
 }
 
+func GetJobDetail(c *gin.Context) {
+	taskUuid := c.Param("task_uuid")
+	if strings.TrimSpace(taskUuid) == "" {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.BadParamError, "missing required field: task_uuid"))
+		return
+	}
+
+	spaceDetail, err := findJobMetadataByTaskUuid(taskUuid)
+	if err != nil {
+		if err == NotFoundRedisKey {
+			c.JSON(http.StatusNotFound, util.CreateErrorResponse(util.NotFoundJobError))
+			return
+		}
+		logs.GetLogger().Error(err)
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.ServerError, "query data failed"))
+		return
+	}
+
+	jobDetail := models.JobDetail{
+		Space:  spaceDetail,
+		Pods:   []models.PodDetail{},
+		Events: []models.JobEvent{},
+	}
+	if spaceDetail.JobUuid != "" {
+		if job, err := RetrieveJob(spaceDetail.JobUuid); err == nil {
+			jobDetail.Job = job
+		}
+	}
+	if leftTime := spaceDetail.ExpireTime - time.Now().Unix(); leftTime > 0 {
+		jobDetail.ExpiresIn = leftTime
+	}
+	if len(spaceDetail.CustomDomains) > 0 {
+		jobDetail.CustomDomainToken = CustomDomainToken(spaceDetail.SpaceUuid)
+	}
+
+	k8sService := NewK8sService()
+	k8sNameSpace := SpaceNamespace(spaceDetail.WalletAddress)
+	jobDetail.DeploymentStatus, err = k8sService.GetDeploymentStatus(spaceDetail.WalletAddress, spaceDetail.SpaceUuid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.ServerError, err.Error()))
+		return
+	}
+
+	pods, err := k8sService.k8sClient.CoreV1().Pods(k8sNameSpace).List(context.TODO(), metaV1.ListOptions{
+		LabelSelector: fmt.Sprintf("lad_app=%s", spaceDetail.SpaceUuid),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.ServerError, err.Error()))
+		return
+	}
+	for _, pod := range pods.Items {
+		podDetail := models.PodDetail{
+			Name:  pod.Name,
+			Phase: string(pod.Status.Phase),
+			Ready: true,
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			podDetail.RestartCount += status.RestartCount
+			podDetail.Ready = podDetail.Ready && status.Ready
+		}
+		podDetail.Ready = podDetail.Ready && len(pod.Status.ContainerStatuses) > 0
+		jobDetail.Pods = append(jobDetail.Pods, podDetail)
+	}
+
+	events, err := k8sService.k8sClient.CoreV1().Events(k8sNameSpace).List(context.TODO(), metaV1.ListOptions{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.ServerError, err.Error()))
+		return
+	}
+	for _, event := range events.Items {
+		if !strings.Contains(event.InvolvedObject.Name, spaceDetail.SpaceUuid) {
+			continue
+		}
+		eventTime := event.LastTimestamp.Time
+		if eventTime.IsZero() {
+			eventTime = event.EventTime.Time
+		}
+		jobDetail.Events = append(jobDetail.Events, models.JobEvent{
+			Type:      event.Type,
+			Reason:    event.Reason,
+			Object:    event.InvolvedObject.Kind + "/" + event.InvolvedObject.Name,
+			Message:   event.Message,
+			Timestamp: eventTime.Unix(),
+		})
+	}
+	sort.Slice(jobDetail.Events, func(i, j int) bool {
+		return jobDetail.Events[i].Timestamp > jobDetail.Events[j].Timestamp
+	})
+	if len(jobDetail.Events) > jobEventLimit {
+		jobDetail.Events = jobDetail.Events[:jobEventLimit]
+	}
+
+	route, err := getSpaceRoute(k8sService, k8sNameSpace, spaceDetail.SpaceUuid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.ServerError, err.Error()))
+		return
+	}
+	if route != nil && len(route.Hosts) > 0 {
+		jobDetail.IngressHost = route.Hosts[0]
+	}
+
+	c.JSON(http.StatusOK, util.CreateSuccessResponse(jobDetail))
+}
+
 func StatisticalSources(c *gin.Context) {
 	location, err := getLocation()
 	if err != nil {
@@ -605,7 +915,7 @@ func DoProof(c *gin.Context) {
 	}
 
 	podName := podList.Items[0].Name
-	podLog, err := k8sService.k8sClient.CoreV1().Pods(metaV1.NamespaceDefault).GetLogs(podName, &v1.PodLogOptions{}).Stream(context.Background())
+	podLog, err := k8sService.streamClient.CoreV1().Pods(metaV1.NamespaceDefault).GetLogs(podName, &v1.PodLogOptions{}).Stream(context.Background())
 	if err != nil {
 		logs.GetLogger().Errorf("Failed gettingPod logs: %v", err)
 		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.ProofReadLogError))
@@ -625,7 +935,7 @@ func DoProof(c *gin.Context) {
 func handlePodEvent(conn *websocket.Conn, spaceUuid string, walletAddress string) {
 	client := NewWsClient(conn)
 
-	k8sNameSpace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(walletAddress)
+	k8sNameSpace := SpaceNamespace(walletAddress)
 	k8sService := NewK8sService()
 	events, err := k8sService.k8sClient.CoreV1().Events(k8sNameSpace).List(context.TODO(), metaV1.ListOptions{})
 	if err != nil {
@@ -657,7 +967,7 @@ func handleConnection(conn *websocket.Conn, spaceDetail models.CacheSpaceDetail,
 			client.HandleLogs(logFile)
 		}
 	} else if logType == "container" {
-		k8sNameSpace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(spaceDetail.WalletAddress)
+		k8sNameSpace := SpaceNamespace(spaceDetail.WalletAddress)
 
 		k8sService := NewK8sService()
 		pods, err := k8sService.k8sClient.CoreV1().Pods(k8sNameSpace).List(context.TODO(), metaV1.ListOptions{
@@ -668,11 +978,19 @@ func handleConnection(conn *websocket.Conn, spaceDetail models.CacheSpaceDetail,
 			return
 		}
 
-		if len(pods.Items) > 0 {
+		if len(pods.Items) == 0 {
+			logFile, err := os.Open(containerLogPath(spaceDetail.WalletAddress, spaceDetail.SpaceName))
+			if err != nil {
+				client.HandleLogs(strings.NewReader("The space is not running."))
+				return
+			}
+			defer logFile.Close()
+			client.HandleLogs(logFile)
+		} else {
 			line := int64(1000)
 			containerStatuses := pods.Items[0].Status.ContainerStatuses
 			lastIndex := len(containerStatuses) - 1
-			req := k8sService.k8sClient.CoreV1().Pods(k8sNameSpace).GetLogs(pods.Items[0].Name, &v1.PodLogOptions{
+			req := k8sService.streamClient.CoreV1().Pods(k8sNameSpace).GetLogs(pods.Items[0].Name, &v1.PodLogOptions{
 				Container:  containerStatuses[lastIndex].Name,
 				Follow:     true,
 				Timestamps: true,
@@ -692,26 +1010,35 @@ func handleConnection(conn *websocket.Conn, spaceDetail models.CacheSpaceDetail,
 }
 
 func DeploySpaceTask(jobSourceURI, hostName string, duration int, jobUuid string, taskUuid string, gpuProductName string) string {
-	updateJobStatus(jobUuid, models.JobUploadResult)
-
 	var success bool
+	var deployErr error
 	var spaceUuid string
 	var walletAddress string
+	var rollingUpdate bool
 	defer func() {
-		if !success {
-			k8sNameSpace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(walletAddress)
-			deleteJob(k8sNameSpace, spaceUuid)
-		}
-
 		if err := recover(); err != nil {
 			logs.GetLogger().Errorf("deploy space task painc, error: %+v", err)
-			return
+			deployErr = fmt.Errorf("deploy space task panic: %v", err)
+		}
+
+		if !success {
+			k8sNameSpace := SpaceNamespace(walletAddress)
+			if rollingUpdate {
+				// keep the running version of the space
+				err := NewK8sService().RollbackDeployment(context.TODO(), k8sNameSpace, constants.K8S_DEPLOY_NAME_PREFIX+spaceUuid)
+				if err != nil && err != ErrNoPreviousRevision {
+					logs.GetLogger().Errorf("Failed rollback deployment, space_uuid: %s, error: %+v", spaceUuid, err)
+				}
+			} else {
+				deleteJob(k8sNameSpace, spaceUuid)
+			}
+			failJob(jobUuid, deployErr)
 		}
 	}()
 
 	spaceDetail, err := getSpaceDetail(jobSourceURI)
 	if err != nil {
-		logs.GetLogger().Errorln(err)
+		deployErr = models.NewJobFailure(models.FailureSourceUnavailable, "%v", err)
 		return ""
 	}
 
@@ -720,6 +1047,14 @@ func DeploySpaceTask(jobSourceURI, hostName string, duration int, jobUuid string
 	spaceUuid = strings.ToLower(spaceDetail.Data.Space.Uuid)
 	spaceHardware := spaceDetail.Data.Space.ActiveOrder.Config
 
+	k8sNameSpace := SpaceNamespace(walletAddress)
+	if _, err = NewK8sService().k8sClient.AppsV1().Deployments(k8sNameSpace).Get(context.TODO(), constants.K8S_DEPLOY_NAME_PREFIX+spaceUuid, metaV1.GetOptions{}); err == nil {
+		rollingUpdate = true
+		if err = markJobRollingUpdate(jobUuid); err != nil {
+			logs.GetLogger().Errorf("Failed mark job rolling update, job_uuid: %s, error: %+v", jobUuid, err)
+		}
+	}
+
 	conn := redisPool.Get()
 	fullArgs := []interface{}{constants.REDIS_SPACE_PREFIX + spaceUuid}
 	fields := map[string]string{
@@ -737,37 +1072,56 @@ func DeploySpaceTask(jobSourceURI, hostName string, duration int, jobUuid string
 
 	logs.GetLogger().Infof("uuid: %s, spaceName: %s, hardwareName: %s", spaceUuid, spaceName, spaceHardware.Description)
 	if len(spaceHardware.Description) == 0 {
+		deployErr = models.NewJobFailure(models.FailureInvalidSpec, "the order of space %s has no hardware config", spaceUuid)
 		return ""
 	}
 
 	deploy := NewDeploy(jobUuid, hostName, walletAddress, spaceHardware.Description, int64(duration), taskUuid, constants.SPACE_TYPE_PUBLIC)
 	deploy.WithSpaceInfo(spaceUuid, spaceName)
 	deploy.WithGpuProductName(gpuProductName)
+	deploy.WithMaxReplicas(spaceDetail.OrderReplicas())
+	if jobData, err := retrieveJobData(jobUuid); err == nil {
+		deploy.WithCustomDomains(jobData.CustomDomains)
+	}
 
 	spacePath := filepath.Join("build", walletAddress, "spaces", spaceName)
 	os.RemoveAll(spacePath)
 	updateJobStatus(jobUuid, models.JobDownloadSource)
 	containsYaml, yamlPath, imagePath, modelsSettingFile, _, err := BuildSpaceTaskImage(spaceUuid, spaceDetail.Data.Files)
 	if err != nil {
-		logs.GetLogger().Error(err)
+		deployErr = models.NewJobFailure(models.FailureSourceUnavailable, "download space files failed: %v", err)
 		return ""
 	}
 
+	if containsYaml {
+		// reject a broken deploy.yaml or compose file before anything is created in k8s
+		if err = yaml.ValidateFile(yamlPath); err != nil {
+			deployErr = models.NewJobFailure(models.FailureInvalidSpec, "invalid %s:\n%v", filepath.Base(yamlPath), err)
+			return ""
+		}
+	}
+
 	deploy.WithSpacePath(imagePath)
 	if len(modelsSettingFile) > 0 {
-		err := deploy.WithModelSettingFile(modelsSettingFile).ModelInferenceToK8s()
-		if err != nil {
-			logs.GetLogger().Error(err)
+		if deployErr = deploy.WithModelSettingFile(modelsSettingFile).ModelInferenceToK8s(); deployErr != nil {
 			return ""
 		}
+		success = true
 		return hostName
 	}
 
 	if containsYaml {
-		deploy.WithYamlInfo(yamlPath).YamlToK8s()
+		deployErr = deploy.WithYamlInfo(yamlPath).YamlToK8s()
 	} else {
-		imageName, dockerfilePath := BuildImagesByDockerfile(jobUuid, spaceUuid, spaceName, imagePath)
-		deploy.WithDockerfile(imageName, dockerfilePath).DockerfileToK8s()
+		imageName, dockerfilePath, err := BuildImagesByDockerfile(jobUuid, spaceUuid, spaceName, imagePath)
+		if err != nil {
+			deployErr = err
+			return ""
+		}
+		deployErr = deploy.WithDockerfile(imageName, dockerfilePath).DockerfileToK8s()
+	}
+	if deployErr != nil {
+		return ""
 	}
 	success = true
 
@@ -777,12 +1131,11 @@ func DeploySpaceTask(jobSourceURI, hostName string, duration int, jobUuid string
 func deleteJob(namespace, spaceUuid string) error {
 	deployName := constants.K8S_DEPLOY_NAME_PREFIX + spaceUuid
 	serviceName := constants.K8S_SERVICE_NAME_PREFIX + spaceUuid
-	ingressName := constants.K8S_INGRESS_NAME_PREFIX + spaceUuid
 
 	logs.GetLogger().Infof("Start deleting space service, space_uuid: %s", spaceUuid)
 	k8sService := NewK8sService()
-	if err := k8sService.DeleteIngress(context.TODO(), namespace, ingressName); err != nil && !errors.IsNotFound(err) {
-		logs.GetLogger().Errorf("Failed delete ingress, ingressName: %s, error: %+v", deployName, err)
+	if err := deleteSpaceRoutes(k8sService, namespace, spaceUuid); err != nil {
+		logs.GetLogger().Errorf("Failed delete space route, space_uuid: %s, error: %+v", spaceUuid, err)
 		return err
 	}
 
@@ -790,6 +1143,14 @@ func deleteJob(namespace, spaceUuid string) error {
 		logs.GetLogger().Errorf("Failed delete service, serviceName: %s, error: %+v", serviceName, err)
 		return err
 	}
+	if err := k8sService.DeleteService(context.TODO(), namespace, serviceName+constants.K8S_NODEPORT_SERVICE_SUFFIX); err != nil && !errors.IsNotFound(err) {
+		logs.GetLogger().Errorf("Failed delete service, serviceName: %s, error: %+v", serviceName+constants.K8S_NODEPORT_SERVICE_SUFFIX, err)
+		return err
+	}
+	if err := k8sService.DeleteNetworkPolicy(context.TODO(), namespace, serviceName+constants.K8S_NODEPORT_SERVICE_SUFFIX); err != nil && !errors.IsNotFound(err) {
+		logs.GetLogger().Errorf("Failed delete network policy, name: %s, error: %+v", serviceName+constants.K8S_NODEPORT_SERVICE_SUFFIX, err)
+		return err
+	}
 
 	dockerService := NewDockerService()
 	deployImageIds, err := k8sService.GetDeploymentImages(context.TODO(), namespace, deployName)
@@ -805,71 +1166,68 @@ func deleteJob(namespace, spaceUuid string) error {
 		logs.GetLogger().Errorf("Failed delete deployment, deployName: %s, error: %+v", deployName, err)
 		return err
 	}
-	time.Sleep(6 * time.Second)
+	if err := k8sService.DeleteSpaceDependencies(context.TODO(), namespace, spaceUuid, nil); err != nil {
+		logs.GetLogger().Errorf("Failed delete space dependencies, spaceUuid: %s, error: %+v", spaceUuid, err)
+		return err
+	}
+	if err := k8sService.DeleteConfigMaps(context.TODO(), namespace, spaceUuid, nil); err != nil {
+		logs.GetLogger().Errorf("Failed delete config maps, spaceUuid: %s, error: %+v", spaceUuid, err)
+		return err
+	}
+
+	// the pods get the termination grace period to shut down, the left ones are deleted forcibly
+	if !waitForPodsDeleted(k8sService, namespace, spaceUuid, time.Duration(terminationGracePeriod()+5)*time.Second) {
+		if err := k8sService.DeleteDeployRs(context.TODO(), namespace, spaceUuid); err != nil && !errors.IsNotFound(err) {
+			logs.GetLogger().Errorf("Failed delete ReplicaSetsController, spaceUuid: %s, error: %+v", spaceUuid, err)
+			return err
+		}
+
+		if err := k8sService.DeletePod(context.TODO(), namespace, spaceUuid); err != nil && !errors.IsNotFound(err) {
+			logs.GetLogger().Errorf("Failed delete pods, spaceUuid: %s, error: %+v", spaceUuid, err)
+			return err
+		}
+		waitForPodsDeleted(k8sService, namespace, spaceUuid, time.Minute)
+	}
 
-	if err := k8sService.DeleteDeployRs(context.TODO(), namespace, spaceUuid); err != nil && !errors.IsNotFound(err) {
-		logs.GetLogger().Errorf("Failed delete ReplicaSetsController, spaceUuid: %s, error: %+v", spaceUuid, err)
+	if err := k8sService.k8sClient.CoreV1().ConfigMaps(namespace).Delete(context.TODO(), constants.K8S_NOTICE_NAME_PREFIX+spaceUuid, metaV1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		logs.GetLogger().Errorf("Failed delete notice config map, spaceUuid: %s, error: %+v", spaceUuid, err)
 		return err
 	}
 
-	if err := k8sService.DeletePod(context.TODO(), namespace, spaceUuid); err != nil && !errors.IsNotFound(err) {
-		logs.GetLogger().Errorf("Failed delete pods, spaceUuid: %s, error: %+v", spaceUuid, err)
+	if err := k8sService.DeleteSecrets(context.TODO(), namespace, spaceUuid); err != nil && !errors.IsNotFound(err) {
+		logs.GetLogger().Errorf("Failed delete secrets, spaceUuid: %s, error: %+v", spaceUuid, err)
 		return err
 	}
 
+	logs.GetLogger().Infof("Deleted space service finished, space_uuid: %s", spaceUuid)
+	return nil
+}
+
+// deleteSpaceVolumes deletes the persistent volume claims of the space. Unlike
+// the other resources they outlive redeploys and are only removed when the
+// lease ends or the job is cancelled.
+func deleteSpaceVolumes(namespace, spaceUuid string) {
+	if err := NewK8sService().DeletePersistentVolumeClaims(context.TODO(), namespace, spaceUuid); err != nil && !errors.IsNotFound(err) {
+		logs.GetLogger().Errorf("Failed delete persistent volume claims, spaceUuid: %s, error: %+v", spaceUuid, err)
+	}
+}
+
+func waitForPodsDeleted(k8sService *K8sService, namespace, spaceUuid string, timeout time.Duration) bool {
 	ticker := time.NewTicker(3 * time.Second)
 	defer ticker.Stop()
-	var count = 0
-	for {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
 		<-ticker.C
-		count++
-		if count >= 20 {
-			break
-		}
 		getPods, err := k8sService.GetPods(namespace, spaceUuid)
 		if err != nil && !errors.IsNotFound(err) {
 			logs.GetLogger().Errorf("Failed get pods form namespace, namepace: %s, error: %+v", namespace, err)
 			continue
 		}
 		if !getPods {
-			break
+			return true
 		}
 	}
-
-	logs.GetLogger().Infof("Deleted space service finished, space_uuid: %s", spaceUuid)
-	return nil
-}
-
-func downloadModelUrl(namespace, spaceUuid, serviceIp string, podCmd []string) {
-	k8sService := NewK8sService()
-	podName, err := k8sService.WaitForPodRunningByHttp(namespace, spaceUuid, serviceIp)
-	if err != nil {
-		logs.GetLogger().Error(err)
-		return
-	}
-
-	if err = k8sService.PodDoCommand(namespace, podName, "", podCmd); err != nil {
-		logs.GetLogger().Error(err)
-		return
-	}
-}
-
-func updateJobStatus(jobUuid string, jobStatus models.JobStatus, url ...string) {
-	go func() {
-		if len(url) > 0 {
-			deployingChan <- models.Job{
-				Uuid:   jobUuid,
-				Status: jobStatus,
-				Url:    url[0],
-			}
-		} else {
-			deployingChan <- models.Job{
-				Uuid:   jobUuid,
-				Status: jobStatus,
-				Url:    "",
-			}
-		}
-	}()
+	return false
 }
 
 func getSpaceDetail(jobSourceURI string) (models.SpaceJSON, error) {
@@ -889,16 +1247,39 @@ func getSpaceDetail(jobSourceURI string) (models.SpaceJSON, error) {
 	return spaceJson, nil
 }
 
-func checkResourceAvailableForSpace(configDescription string) (bool, string, error) {
+// checkResourceAvailableForSpace checks whether the given number of replicas of
+// the hardware config can be scheduled. Pods of ignoredSpaceUuid are not counted
+// as used, since they are replaced by the new deployment.
+func checkResourceAvailableForSpace(configDescription string, replicas int, ignoredSpaceUuid string) (bool, string, error) {
+	if len(strings.Split(configDescription, "·")) < 3 {
+		return false, "", fmt.Errorf("invalid hardware config: %s", configDescription)
+	}
 	taskType, hardwareDetail := getHardwareDetail(configDescription)
 	k8sService := NewK8sService()
 
-	activePods, err := k8sService.GetAllActivePod(context.TODO())
+	allPods, err := k8sService.GetAllActivePod(context.TODO())
+	if err != nil {
+		return false, "", err
+	}
+	var activePods []v1.Pod
+	for _, pod := range allPods {
+		if ignoredSpaceUuid == "" || pod.Labels["lad_app"] != ignoredSpaceUuid {
+			activePods = append(activePods, pod)
+		}
+	}
+	allClaims, err := k8sService.ListSpaceClaims(context.TODO(), "")
 	if err != nil {
 		return false, "", err
 	}
+	// the claims of the space are part of the storage it needs
+	var claims []v1.PersistentVolumeClaim
+	for _, claim := range allClaims {
+		if ignoredSpaceUuid == "" || claim.Labels["lad_app"] != ignoredSpaceUuid {
+			claims = append(claims, claim)
+		}
+	}
 
-	nodes, err := k8sService.k8sClient.CoreV1().Nodes().List(context.TODO(), metaV1.ListOptions{})
+	nodes, err := k8sService.ListNodes(context.TODO())
 	if err != nil {
 		return false, "", err
 	}
@@ -909,42 +1290,65 @@ func checkResourceAvailableForSpace(configDescription string) (bool, string, err
 		return false, "", err
 	}
 
-	for _, node := range nodes.Items {
-		nodeGpu, remainderResource, _ := GetNodeResource(activePods, &node)
+	needCpu := hardwareDetail.Cpu.Quantity
+	needMemory := float64(hardwareDetail.Memory.Quantity)
+	needStorage := float64(hardwareDetail.Storage.Quantity)
+	logs.GetLogger().Infof("checkResourceAvailableForSpace: replicas: %d, needCpu: %d, needMemory: %.2f, needStorage: %.2f", replicas, needCpu, needMemory, needStorage)
+
+	// number of replicas that fit on the nodes, keyed by gpu product name
+	var schedulable = make(map[string]int64)
+	for _, node := range nodes {
+		nodeGpu, remainderResource, _ := GetNodeResource(activePods, claims, &node)
 		remainderCpu := remainderResource[ResourceCpu]
 		remainderMemory := float64(remainderResource[ResourceMem] / 1024 / 1024 / 1024)
 		remainderStorage := float64(remainderResource[ResourceStorage] / 1024 / 1024 / 1024)
+		logs.GetLogger().Infof("checkResourceAvailableForSpace: node: %s, remainingCpu: %d, remainingMemory: %.2f, remainingStorage: %.2f", node.Name, remainderCpu, remainderMemory, remainderStorage)
 
-		needCpu := hardwareDetail.Cpu.Quantity
-		needMemory := float64(hardwareDetail.Memory.Quantity)
-		needStorage := float64(hardwareDetail.Storage.Quantity)
-		logs.GetLogger().Infof("checkResourceAvailableForSpace: needCpu: %d, needMemory: %.2f, needStorage: %.2f", needCpu, needMemory, needStorage)
-		logs.GetLogger().Infof("checkResourceAvailableForSpace: remainingCpu: %d, remainingMemory: %.2f, remainingStorage: %.2f", remainderCpu, remainderMemory, remainderStorage)
-		if needCpu <= remainderCpu && needMemory <= remainderMemory && needStorage <= remainderStorage {
-			if taskType == "CPU" {
+		fit := int64(replicas)
+		if needCpu > 0 {
+			fit = min(fit, remainderCpu/needCpu)
+		}
+		if needMemory > 0 {
+			fit = min(fit, int64(remainderMemory/needMemory))
+		}
+		if needStorage > 0 {
+			fit = min(fit, int64(remainderStorage/needStorage))
+		}
+		if fit <= 0 {
+			continue
+		}
+
+		if taskType == "CPU" {
+			schedulable[""] += fit
+			if schedulable[""] >= int64(replicas) {
 				return true, "", nil
-			} else if taskType == "GPU" {
-				var usedCount int64 = 0
-				gpuName := strings.ToUpper(strings.ReplaceAll(hardwareDetail.Gpu.Unit, " ", "-"))
-				logs.GetLogger().Infof("gpuName: %s, nodeGpu: %+v, nodeGpuSummary: %+v", gpuName, nodeGpu, nodeGpuSummary)
-				var gpuProductName = ""
-				for name, count := range nodeGpu {
-					if strings.Contains(strings.ToUpper(name), gpuName) {
-						usedCount = count
-						gpuProductName = strings.ReplaceAll(strings.ToUpper(name), " ", "-")
-						break
-					}
+			}
+		} else if taskType == "GPU" {
+			var usedCount int64 = 0
+			gpuName := strings.ToUpper(strings.ReplaceAll(hardwareDetail.Gpu.Unit, " ", "-"))
+			logs.GetLogger().Infof("gpuName: %s, nodeGpu: %+v, nodeGpuSummary: %+v", gpuName, nodeGpu, nodeGpuSummary)
+			for name, count := range nodeGpu {
+				if strings.Contains(strings.ToUpper(name), gpuName) {
+					usedCount = count
+					break
 				}
+			}
 
-				for gName, gCount := range nodeGpuSummary[node.Name] {
-					if strings.Contains(strings.ToUpper(gName), gpuName) {
-						gpuProductName = strings.ReplaceAll(strings.ToUpper(gName), " ", "-")
-						if usedCount+hardwareDetail.Gpu.Quantity <= gCount {
-							return true, gpuProductName, nil
-						}
+			for gName, gCount := range nodeGpuSummary[node.Name] {
+				if strings.Contains(strings.ToUpper(gName), gpuName) {
+					gpuProductName := strings.ReplaceAll(strings.ToUpper(gName), " ", "-")
+					gpuFit := fit
+					if hardwareDetail.Gpu.Quantity > 0 {
+						gpuFit = min(fit, (gCount-usedCount)/hardwareDetail.Gpu.Quantity)
+					}
+					if gpuFit <= 0 {
+						continue
+					}
+					schedulable[gpuProductName] += gpuFit
+					if schedulable[gpuProductName] >= int64(replicas) {
+						return true, gpuProductName, nil
 					}
 				}
-				continue
 			}
 		}
 	}
@@ -957,8 +1361,12 @@ func checkResourceAvailableForUbi(taskType int, gpuName string, resource *models
 	if err != nil {
 		return "", "", 0, 0, 0, err
 	}
+	claims, err := k8sService.ListSpaceClaims(context.TODO(), "")
+	if err != nil {
+		return "", "", 0, 0, 0, err
+	}
 
-	nodes, err := k8sService.k8sClient.CoreV1().Nodes().List(context.TODO(), metaV1.ListOptions{})
+	nodes, err := k8sService.ListNodes(context.TODO())
 	if err != nil {
 		return "", "", 0, 0, 0, err
 	}
@@ -980,7 +1388,7 @@ func checkResourceAvailableForUbi(taskType int, gpuName string, resource *models
 	}
 
 	var nodeName, architecture string
-	for _, node := range nodes.Items {
+	for _, node := range nodes {
 		if _, ok := node.Labels[constants.CPU_INTEL]; ok {
 			architecture = constants.CPU_INTEL
 		}
@@ -988,7 +1396,7 @@ func checkResourceAvailableForUbi(taskType int, gpuName string, resource *models
 			architecture = constants.CPU_AMD
 		}
 
-		nodeGpu, remainderResource, _ := GetNodeResource(activePods, &node)
+		nodeGpu, remainderResource, _ := GetNodeResource(activePods, claims, &node)
 		remainderCpu := remainderResource[ResourceCpu]
 		remainderMemory := float64(remainderResource[ResourceMem] / 1024 / 1024 / 1024)
 		remainderStorage := float64(remainderResource[ResourceStorage] / 1024 / 1024 / 1024)
@@ -1147,7 +1555,7 @@ func RetrieveJobMetadata(key string) (models.CacheSpaceDetail, error) {
 	}
 
 	args := append([]interface{}{key}, "wallet_address", "space_name", "expire_time", "space_uuid", "job_uuid",
-		"task_type", "deploy_name", "hardware", "url", "task_uuid", "space_type")
+		"task_type", "deploy_name", "hardware", "url", "task_uuid", "space_type", "replicas", "max_replicas", "paused_at", "custom_domains")
 	valuesStr, err := redis.Strings(redisConn.Do("HMGET", args...))
 	if err != nil {
 		logs.GetLogger().Errorf("Failed get redis key data, key: %s, error: %+v", key, err)
@@ -1166,6 +1574,10 @@ func RetrieveJobMetadata(key string) (models.CacheSpaceDetail, error) {
 		url           string
 		taskUuid      string
 		spaceType     string
+		replicas      = 1
+		maxReplicas   = 1
+		pausedAt      int64
+		customDomains []string
 	)
 
 	if len(valuesStr) >= 3 {
@@ -1180,6 +1592,16 @@ func RetrieveJobMetadata(key string) (models.CacheSpaceDetail, error) {
 		url = valuesStr[8]
 		taskUuid = valuesStr[9]
 		spaceType = valuesStr[10]
+		if count, err := strconv.Atoi(valuesStr[11]); err == nil {
+			replicas = count
+		}
+		if count, err := strconv.Atoi(valuesStr[12]); err == nil {
+			maxReplicas = count
+		}
+		pausedAt, _ = strconv.ParseInt(valuesStr[13], 10, 64)
+		if valuesStr[14] != "" {
+			customDomains = strings.Split(valuesStr[14], ",")
+		}
 		expireTime, err = strconv.ParseInt(strings.TrimSpace(expireTimeStr), 10, 64)
 		if err != nil {
 			logs.GetLogger().Errorf("Failed convert time str: [%s], error: %+v", expireTimeStr, err)
@@ -1199,9 +1621,37 @@ func RetrieveJobMetadata(key string) (models.CacheSpaceDetail, error) {
 		Url:           url,
 		TaskUuid:      taskUuid,
 		SpaceType:     spaceType,
+		Replicas:      replicas,
+		MaxReplicas:   maxReplicas,
+		PausedAt:      pausedAt,
+		CustomDomains: customDomains,
 	}, nil
 }
 
+// findJobMetadataByTaskUuid scans the space metadata for the given task_uuid,
+// it returns NotFoundRedisKey if no space belongs to the task.
+func findJobMetadataByTaskUuid(taskUuid string) (models.CacheSpaceDetail, error) {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	prefix := constants.REDIS_SPACE_PREFIX + "*"
+	keys, err := scanKeys(conn, prefix)
+	if err != nil {
+		return models.CacheSpaceDetail{}, fmt.Errorf("failed get redis %s prefix, error: %w", prefix, err)
+	}
+
+	for _, key := range keys {
+		jobMetadata, err := RetrieveJobMetadata(key)
+		if err != nil {
+			return models.CacheSpaceDetail{}, fmt.Errorf("failed get redis key data, key: %s, error: %w", key, err)
+		}
+		if strings.EqualFold(jobMetadata.TaskUuid, taskUuid) {
+			return jobMetadata, nil
+		}
+	}
+	return models.CacheSpaceDetail{}, NotFoundRedisKey
+}
+
 func SaveUbiTaskMetadata(ubiTask *models.CacheUbiTaskDetail) {
 	redisConn := GetRedisClient()
 	defer redisConn.Close()
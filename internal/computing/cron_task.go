@@ -100,14 +100,14 @@ func (task *CronTask) cleanAbnormalDeployment() {
 		}
 
 		for _, namespace := range namespaces {
-			if strings.HasPrefix(namespace, constants.K8S_NAMESPACE_NAME_PREFIX) {
-				deployments, err := k8sService.k8sClient.AppsV1().Deployments(namespace).List(context.TODO(), metav1.ListOptions{})
+			if isSpaceNamespace(namespace) {
+				deployments, err := k8sService.ListDeployments(context.TODO(), namespace)
 				if err != nil {
 					logs.GetLogger().Errorf("Error getting deployments in namespace %s: %v\n", namespace, err)
 					continue
 				}
 
-				for _, deployment := range deployments.Items {
+				for _, deployment := range deployments {
 					creationTimestamp := deployment.ObjectMeta.CreationTimestamp.Time
 					currentTime := time.Now()
 					age := currentTime.Sub(creationTimestamp)
@@ -155,7 +155,7 @@ func (task *CronTask) setFailedUbiTaskStatus() {
 			}
 
 			JobName := strings.ToLower(ubiTask.ZkType) + "-" + ubiTask.TaskId
-			k8sNameSpace := "ubi-task-" + ubiTask.TaskId
+			k8sNameSpace := constants.K8S_UBI_NAMESPACE_PREFIX + ubiTask.TaskId
 
 			service := NewK8sService()
 			if _, err = service.k8sClient.BatchV1().Jobs(k8sNameSpace).Get(context.TODO(), JobName, metav1.GetOptions{}); err != nil && errors.IsNotFound(err) {
@@ -0,0 +1,218 @@
+package computing
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/swanchain/go-computing-provider/conf"
+	"github.com/swanchain/go-computing-provider/constants"
+	"github.com/swanchain/go-computing-provider/internal/models"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	customDomainCheckInterval = time.Minute
+	customDomainLookupTimeout = 10 * time.Second
+)
+
+// DomainResolver looks up the DNS records of a custom domain, *net.Resolver
+// implements it.
+type DomainResolver interface {
+	LookupCNAME(ctx context.Context, host string) (string, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+var domainResolver DomainResolver = net.DefaultResolver
+
+// customDomainTXTPrefix is the name of the TXT record that proves the
+// ownership of a domain that can not have a CNAME record, e.g. an apex domain.
+const customDomainTXTPrefix = "_lad-verify."
+
+// CustomDomainToken is the value of the "_lad-verify.<domain>" TXT record
+// that routes the domain to the space.
+func CustomDomainToken(spaceUuid string) string {
+	sum := sha256.Sum256([]byte("lad-custom-domain:" + spaceUuid))
+	return "lad-verify=" + hex.EncodeToString(sum[:16])
+}
+
+// VerifyCustomDomain checks that the custom domain belongs to the space, by a
+// CNAME record to the space host or by a TXT record with the token of the
+// space. Matching A/AAAA records are not enough, all spaces share the address
+// of the ingress.
+func VerifyCustomDomain(ctx context.Context, resolver DomainResolver, domain, spaceUuid, spaceHost string) error {
+	cname, err := resolver.LookupCNAME(ctx, domain)
+	if err == nil && strings.EqualFold(strings.TrimSuffix(cname, "."), spaceHost) {
+		return nil
+	}
+
+	token := CustomDomainToken(spaceUuid)
+	records, err := resolver.LookupTXT(ctx, customDomainTXTPrefix+domain)
+	if err == nil {
+		for _, record := range records {
+			if strings.TrimSpace(record) == token {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%s does not point to %s, add a CNAME record to %s or a TXT record %s%s with %q", domain, spaceHost, spaceHost, customDomainTXTPrefix, domain, token)
+}
+
+// checkCustomDomainOwners rejects the custom domains another space already has.
+func checkCustomDomainOwners(spaceUuid string, domains []string) error {
+	if len(domains) == 0 {
+		return nil
+	}
+	conn := redisPool.Get()
+	defer conn.Close()
+	keys, err := scanKeys(conn, constants.REDIS_SPACE_PREFIX+"*")
+	if err != nil {
+		return err
+	}
+	claimed := make(map[string]bool)
+	for _, domain := range domains {
+		claimed[domain] = true
+	}
+	for _, key := range keys {
+		jobMetadata, err := RetrieveJobMetadata(key)
+		if err != nil || strings.EqualFold(jobMetadata.SpaceUuid, spaceUuid) {
+			continue
+		}
+		for _, domain := range jobMetadata.CustomDomains {
+			if claimed[domain] {
+				return fmt.Errorf("custom domain %s is used by another space", domain)
+			}
+		}
+	}
+	return nil
+}
+
+// validateCustomDomains normalizes the custom domains of a job and rejects
+// the ones that can not be routed to a space.
+func validateCustomDomains(domains []string) ([]string, error) {
+	if len(domains) == 0 {
+		return nil, nil
+	}
+	maxDomains := conf.GetConfig().SPACE.MaxCustomDomains
+	if maxDomains <= 0 {
+		return nil, fmt.Errorf("custom domains are not enabled on this cp")
+	}
+	if len(domains) > maxDomains {
+		return nil, fmt.Errorf("a space can have at most %d custom domains", maxDomains)
+	}
+
+	cpDomain := strings.ToLower(strings.Trim(conf.GetConfig().API.Domain, "."))
+	var result []string
+	seen := make(map[string]bool)
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+		if errs := validation.IsDNS1123Subdomain(domain); len(errs) > 0 || !strings.Contains(domain, ".") {
+			return nil, fmt.Errorf("invalid custom domain %q", domain)
+		}
+		if cpDomain != "" && (domain == cpDomain || strings.HasSuffix(domain, "."+cpDomain)) {
+			return nil, fmt.Errorf("custom domain %s belongs to the cp domain", domain)
+		}
+		if seen[domain] {
+			continue
+		}
+		seen[domain] = true
+		result = append(result, domain)
+	}
+	return result, nil
+}
+
+// verifiedCustomDomains returns the custom domains that belong to the space,
+// the others are retried by watchCustomDomains.
+func verifiedCustomDomains(spaceUuid, spaceHost string, domains []string) []string {
+	var verified []string
+	for _, domain := range domains {
+		ctx, cancel := context.WithTimeout(context.Background(), customDomainLookupTimeout)
+		err := VerifyCustomDomain(ctx, domainResolver, domain, spaceUuid, spaceHost)
+		cancel()
+		if err == nil {
+			err = checkCustomDomainOwners(spaceUuid, []string{domain})
+		}
+		if err != nil {
+			logs.GetLogger().Warnf("Custom domain is not routed yet, space_uuid: %s, error: %v", spaceUuid, err)
+			continue
+		}
+		verified = append(verified, domain)
+	}
+	return verified
+}
+
+// watchCustomDomains adds the custom domains to the ingress of the space once
+// their DNS records point to the cp.
+func watchCustomDomains() {
+	ticker := time.NewTicker(customDomainCheckInterval)
+	go func() {
+		for range ticker.C {
+			func() {
+				defer func() {
+					if err := recover(); err != nil {
+						logs.GetLogger().Errorf("watchCustomDomains catch panic error: %+v", err)
+					}
+				}()
+				conn := redisPool.Get()
+				defer conn.Close()
+				prefix := constants.REDIS_SPACE_PREFIX + "*"
+				keys, err := scanKeys(conn, prefix)
+				if err != nil {
+					logs.GetLogger().Errorf("Failed get redis %s prefix, error: %+v", prefix, err)
+					return
+				}
+				for _, key := range keys {
+					jobMetadata, err := RetrieveJobMetadata(key)
+					if err != nil || len(jobMetadata.CustomDomains) == 0 {
+						continue
+					}
+					routeCustomDomains(jobMetadata)
+				}
+			}()
+		}
+	}()
+}
+
+func routeCustomDomains(jobMetadata models.CacheSpaceDetail) {
+	namespace := SpaceNamespace(jobMetadata.WalletAddress)
+	k8sService := NewK8sService()
+	route, err := getSpaceRoute(k8sService, namespace, jobMetadata.SpaceUuid)
+	if err != nil {
+		logs.GetLogger().Errorf("Failed get space route, space_uuid: %s, error: %+v", jobMetadata.SpaceUuid, err)
+		return
+	}
+	if route == nil || len(route.Hosts) == 0 || route.Port == 0 {
+		return
+	}
+
+	spaceHost := route.Hosts[0]
+	routed := make(map[string]bool)
+	for _, host := range route.Hosts[1:] {
+		routed[host] = true
+	}
+	var customHosts, pending []string
+	for _, domain := range jobMetadata.CustomDomains {
+		if routed[domain] {
+			customHosts = append(customHosts, domain)
+		} else {
+			pending = append(pending, domain)
+		}
+	}
+	verified := verifiedCustomDomains(jobMetadata.SpaceUuid, spaceHost, pending)
+	if len(verified) == 0 {
+		return
+	}
+
+	customHosts = append(customHosts, verified...)
+	route.Hosts = append([]string{spaceHost}, customHosts...)
+	if err = applySpaceRoute(k8sService, namespace, jobMetadata.SpaceUuid, *route); err != nil {
+		logs.GetLogger().Errorf("Failed route custom domains, space_uuid: %s, error: %+v", jobMetadata.SpaceUuid, err)
+		return
+	}
+	logs.GetLogger().Infof("Routed custom domains, space_uuid: %s, domains: %v", jobMetadata.SpaceUuid, verified)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
 	"github.com/gomodule/redigo/redis"
+	"github.com/swanchain/go-computing-provider/conf"
 	"github.com/swanchain/go-computing-provider/constants"
 	"github.com/swanchain/go-computing-provider/internal/models"
 	"github.com/swanchain/go-computing-provider/internal/yaml"
@@ -15,6 +16,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -42,6 +44,10 @@ type Deploy struct {
 	hardwareDesc      string
 	taskUuid          string
 	gpuProductName    string
+	replicas          int32
+	maxReplicas       int32
+	customDomains     []string
+	placeholders      map[string]string
 
 	spaceType string
 }
@@ -60,10 +66,12 @@ func NewDeploy(jobUuid, hostName, walletAddress, hardwareDesc string, duration i
 		duration:         duration,
 		hardwareResource: hardwareDetail,
 		TaskType:         taskType,
-		k8sNameSpace:     constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(walletAddress),
+		k8sNameSpace:     SpaceNamespace(walletAddress),
 		hardwareDesc:     hardwareDesc,
 		taskUuid:         taskUuid,
 		spaceType:        spaceType,
+		replicas:         1,
+		maxReplicas:      1,
 	}
 }
 
@@ -90,6 +98,13 @@ func (d *Deploy) WithGpuProductName(gpuProductName string) *Deploy {
 	return d
 }
 
+// WithMaxReplicas sets the replica count paid by the order, the space is
+// deployed and scaled up to it.
+func (d *Deploy) WithMaxReplicas(maxReplicas int) *Deploy {
+	d.maxReplicas = int32(maxReplicas)
+	return d
+}
+
 func (d *Deploy) WithYamlInfo(yamlPath string) *Deploy {
 	d.yamlPath = yamlPath
 	return d
@@ -111,26 +126,25 @@ func (d *Deploy) WithModelSettingFile(modelsSettingFile string) *Deploy {
 	return d
 }
 
-func (d *Deploy) DockerfileToK8s() {
+func (d *Deploy) WithCustomDomains(customDomains []string) *Deploy {
+	d.customDomains = customDomains
+	return d
+}
+
+func (d *Deploy) DockerfileToK8s() error {
 	exposedPort, err := ExtractExposedPort(d.dockerfilePath)
 	if err != nil {
-		logs.GetLogger().Infof("Failed to extract exposed port: %v", err)
-		return
+		return models.NewJobFailure(models.FailureInvalidSpec, "failed to extract exposed port: %v", err)
 	}
 	containerPort, err := strconv.ParseInt(exposedPort, 10, 64)
 	if err != nil {
-		logs.GetLogger().Errorf("Failed to convert exposed port: %v", err)
-		return
+		return models.NewJobFailure(models.FailureInvalidSpec, "failed to convert exposed port: %v", err)
 	}
 
-	deleteJob(d.k8sNameSpace, d.spaceUuid)
-
 	if err := d.deployNamespace(); err != nil {
-		logs.GetLogger().Error(err)
-		return
+		return models.NewJobFailure(models.FailureK8sDeploy, "%v", err)
 	}
 
-	k8sService := NewK8sService()
 	deployment := &appV1.Deployment{
 		TypeMeta: metaV1.TypeMeta{
 			Kind:       "Deployment",
@@ -149,6 +163,8 @@ func (d *Deploy) DockerfileToK8s() {
 				ObjectMeta: metaV1.ObjectMeta{
 					Labels:    map[string]string{"lad_app": d.spaceUuid},
 					Namespace: d.k8sNameSpace,
+					// the space has no standalone dependencies
+					Annotations: map[string]string{dependenciesAnnotation: ""},
 				},
 
 				Spec: coreV1.PodSpec{
@@ -166,101 +182,165 @@ func (d *Deploy) DockerfileToK8s() {
 				},
 			},
 		}}
-	createDeployment, err := k8sService.CreateDeployment(context.TODO(), d.k8sNameSpace, deployment)
+	if err = d.withSpaceLifecycle(&deployment.Spec.Template.Spec); err != nil {
+		return models.NewJobFailure(models.FailureK8sDeploy, "%v", err)
+	}
+	createDeployment, err := d.applyDeployment(deployment)
 	if err != nil {
-		logs.GetLogger().Error(err)
-		return
+		return models.NewJobFailure(models.FailureK8sDeploy, "failed apply deployment: %v", err)
 	}
 	d.DeployName = createDeployment.GetName()
 	updateJobStatus(d.jobUuid, models.JobPullImage)
-	logs.GetLogger().Infof("Created deployment: %s", createDeployment.GetName())
+	logs.GetLogger().Infof("Applied deployment: %s", createDeployment.GetName())
 
-	if _, err := d.deployK8sResource(int32(containerPort)); err != nil {
-		logs.GetLogger().Error(err)
-		return
+	if _, err := d.deployK8sResource(httpExpose(int32(containerPort))); err != nil {
+		return models.NewJobFailure(models.FailureK8sDeploy, "%v", err)
 	}
 	updateJobStatus(d.jobUuid, models.JobDeployToK8s, "https://"+d.hostName)
 
 	d.watchContainerRunningTime()
-	return
+	return nil
 }
 
-func (d *Deploy) YamlToK8s() {
+func (d *Deploy) YamlToK8s() error {
 	containerResources, err := yaml.HandlerYaml(d.yamlPath)
 	if err != nil {
-		logs.GetLogger().Error(err)
-		return
+		return models.NewJobFailure(models.FailureInvalidSpec, "%v", err)
 	}
 
-	deleteJob(d.k8sNameSpace, d.spaceUuid)
+	// the compute profiles and the replica counts are checked before anything is created
+	mainResources := make([]coreV1.ResourceRequirements, len(containerResources))
+	dependResources := make([][]coreV1.ResourceRequirements, len(containerResources))
+	spaceUsed := make(coreV1.ResourceList)
+	for i, cr := range containerResources {
+		if cr.Count > int(d.maxReplicas) {
+			return models.NewJobFailure(models.FailureInvalidSpec, "service %s asks for %d replicas, the order pays for %d", cr.Name, cr.Count, d.maxReplicas)
+		}
+		var used coreV1.ResourceList
+		if mainResources[i], dependResources[i], used, err = d.podResources(cr); err != nil {
+			return models.NewJobFailure(models.FailureInvalidSpec, "%v", err)
+		}
+		addResourceList(spaceUsed, used)
+		var exposes []yaml.ExposePort
+		for _, service := range podServices(cr) {
+			exposes = append(exposes, service.Exposes...)
+		}
+		if err = checkExposeConflicts(exposes); err != nil {
+			return models.NewJobFailure(models.FailureInvalidSpec, "%v", err)
+		}
+		for _, depend := range cr.Depends {
+			if !depend.Standalone {
+				continue
+			}
+			if err = d.checkStandaloneDependency(depend); err != nil {
+				return models.NewJobFailure(models.FailureInvalidSpec, "%v", err)
+			}
+		}
+	}
 
-	if err := d.deployNamespace(); err != nil {
-		logs.GetLogger().Error(err)
-		return
+	if err = d.checkSpaceResources(spaceUsed); err != nil {
+		return models.NewJobFailure(models.FailureInvalidSpec, "%v", err)
 	}
 
-	k8sService := NewK8sService()
-	for _, cr := range containerResources {
-		for i, envVar := range cr.Env {
-			if strings.Contains(envVar.Name, "NEXTAUTH_URL") {
-				cr.Env[i].Value = "https://" + d.hostName
-				break
-			}
-		}
+	if err := d.deployNamespace(); err != nil {
+		return models.NewJobFailure(models.FailureK8sDeploy, "%v", err)
+	}
 
-		var volumeMount []coreV1.VolumeMount
-		var volumes []coreV1.Volume
-		if cr.VolumeMounts.Path != "" {
-			fileNameWithoutExt := filepath.Base(cr.VolumeMounts.Name[:len(cr.VolumeMounts.Name)-len(filepath.Ext(cr.VolumeMounts.Name))])
-			configMap, err := k8sService.CreateConfigMap(context.TODO(), d.k8sNameSpace, d.spaceUuid, filepath.Dir(d.yamlPath), cr.VolumeMounts.Name)
+	configs := &spaceConfigs{}
+	for crIndex, cr := range containerResources {
+		// every service has its own replica count, one if it does not ask for more
+		d.replicas = 1
+		if cr.Count > 1 {
+			available, gpuProductName, err := checkResourceAvailableForSpace(d.hardwareDesc, cr.Count, d.spaceUuid)
 			if err != nil {
-				logs.GetLogger().Error(err)
-				return
+				return models.NewJobFailure(models.FailureK8sDeploy, "check resources for %d replicas failed: %v", cr.Count, err)
 			}
-			configName := configMap.GetName()
-			volumes = []coreV1.Volume{
-				{
-					Name: d.spaceUuid + "-" + fileNameWithoutExt,
-					VolumeSource: coreV1.VolumeSource{
-						ConfigMap: &coreV1.ConfigMapVolumeSource{
-							LocalObjectReference: coreV1.LocalObjectReference{
-								Name: configName,
-							},
-						},
-					},
-				},
+			if !available {
+				return models.NewJobFailure(models.FailureResourceUnavailable, "no resources available for %d replicas of %s", cr.Count, d.hardwareDesc)
 			}
-			volumeMount = []coreV1.VolumeMount{
-				{
-					Name:      d.spaceUuid + "-" + fileNameWithoutExt,
-					MountPath: cr.VolumeMounts.Path,
-				},
+			if gpuProductName != "" {
+				d.gpuProductName = gpuProductName
 			}
+			d.replicas = int32(cr.Count)
+		}
+
+		cr.Env = d.legacyNextAuthUrl(cr.Env)
+		d.placeholders = d.spacePlaceholders(cr)
+		cr = expandPlaceholders(cr, d.placeholders)
+
+		volumes, volumeMount, err := d.configMounts(cr, configs)
+		if err != nil {
+			return models.NewJobFailure(models.FailureK8sDeploy, "%v", err)
+		}
+
+		persistentVolumes, persistentStorage, err := d.createPersistentVolumes(cr)
+		if err != nil {
+			return models.NewJobFailure(models.FailureK8sDeploy, "failed create persistent volume claim: %v", err)
 		}
+		var claimSize int64
+		if len(persistentVolumes) > 0 {
+			claimSize = persistentStorage / int64(len(persistentVolumes))
+		}
+		mainVolumes := podVolumes(persistentVolumes, podServices(cr))
+		volumes = append(volumes, mainVolumes...)
+		persistentStorage = claimSize * int64(len(mainVolumes))
 
 		var containers []coreV1.Container
-		for _, depend := range cr.Depends {
-			var handler = new(coreV1.ExecAction)
-			handler.Command = depend.ReadyCmd
-			containers = append(containers, coreV1.Container{
+		var dependencyEnv []coreV1.EnvVar
+		var waitContainers []coreV1.Container
+		standalone := make(map[string]bool)
+		for dependIndex, depend := range cr.Depends {
+			if depend.Standalone {
+				env, wait, err := d.deployStandaloneDependency(depend, dependResources[crIndex][dependIndex], persistentVolumes, claimSize, configs)
+				if err != nil {
+					return models.NewJobFailure(models.FailureK8sDeploy, "%v", err)
+				}
+				dependencyEnv = append(dependencyEnv, env...)
+				waitContainers = append(waitContainers, wait)
+				standalone[depend.Name] = true
+				continue
+			}
+			secretEnv, err := d.createSecretEnv(depend.Name, depend.Secrets)
+			if err != nil {
+				return models.NewJobFailure(models.FailureK8sDeploy, "failed create secret: %v", err)
+			}
+			dependConfigVolumes, dependConfigMounts, err := d.configMounts(depend, configs)
+			if err != nil {
+				return models.NewJobFailure(models.FailureK8sDeploy, "%v", err)
+			}
+			volumes = append(volumes, dependConfigVolumes...)
+			readinessProbe := depend.ReadinessProbe
+			if readinessProbe == nil && len(depend.ReadyCmd) > 0 {
+				readinessProbe = &coreV1.Probe{
+					ProbeHandler: coreV1.ProbeHandler{
+						Exec: &coreV1.ExecAction{Command: depend.ReadyCmd},
+					},
+					InitialDelaySeconds: 5,
+					PeriodSeconds:       5,
+				}
+			}
+			dependContainer := coreV1.Container{
 				Name:            d.spaceUuid + "-" + depend.Name,
 				Image:           depend.ImageName,
 				Command:         depend.Command,
 				Args:            depend.Args,
-				Env:             depend.Env,
+				Env:             append(depend.Env, secretEnv...),
 				Ports:           depend.Ports,
 				ImagePullPolicy: coreV1.PullIfNotPresent,
-				Resources:       coreV1.ResourceRequirements{},
-				ReadinessProbe: &coreV1.Probe{
-					ProbeHandler: coreV1.ProbeHandler{
-						Exec: handler,
-					},
-					InitialDelaySeconds: 5,
-					PeriodSeconds:       5,
-				},
-			})
+				VolumeMounts:    append(dependConfigMounts, persistentVolumeMounts(depend.Persistent)...),
+				Resources:       dependResources[crIndex][dependIndex],
+			}
+			withContainerSettings(&dependContainer, depend)
+			dependContainer.ReadinessProbe = readinessProbe
+			containers = append(containers, dependContainer)
 		}
 
+		secretEnv, err := d.createSecretEnv(cr.Name, cr.Secrets)
+		if err != nil {
+			return models.NewJobFailure(models.FailureK8sDeploy, "failed create secret: %v", err)
+		}
+		cr.Env = append(cr.Env, secretEnv...)
+		cr.Env = append(cr.Env, dependencyEnv...)
 		cr.Env = append(cr.Env, []coreV1.EnvVar{
 			{
 				Name:  "wallet_address",
@@ -280,7 +360,26 @@ func (d *Deploy) YamlToK8s() {
 			},
 		}...)
 
-		containers = append(containers, coreV1.Container{
+		if len(cr.Models) > 0 {
+			modelContainer, modelMounts, err := d.modelInitContainer(cr.Models)
+			if err != nil {
+				return models.NewJobFailure(models.FailureK8sDeploy, "%v", err)
+			}
+			waitContainers = append(waitContainers, modelContainer)
+			volumeMount = append(volumeMount, modelMounts...)
+			volumes = append(volumes, modelCacheVolume(mainResources[crIndex].Limits[coreV1.ResourceEphemeralStorage]))
+		}
+
+		serviceResources := map[string]coreV1.ResourceRequirements{cr.Name: mainResources[crIndex]}
+		for dependIndex, depend := range cr.Depends {
+			serviceResources[depend.Name] = dependResources[crIndex][dependIndex]
+		}
+		initContainers, err := d.initContainers(cr, serviceResources)
+		if err != nil {
+			return models.NewJobFailure(models.FailureInvalidSpec, "%v", err)
+		}
+
+		mainContainer := coreV1.Container{
 			Name:            d.spaceUuid + "-" + cr.Name,
 			Image:           cr.ImageName,
 			Command:         cr.Command,
@@ -288,9 +387,11 @@ func (d *Deploy) YamlToK8s() {
 			Env:             cr.Env,
 			Ports:           cr.Ports,
 			ImagePullPolicy: coreV1.PullIfNotPresent,
-			Resources:       d.createResources(),
-			VolumeMounts:    volumeMount,
-		})
+			Resources:       mainResources[crIndex],
+			VolumeMounts:    append(volumeMount, persistentVolumeMounts(cr.Persistent)...),
+		}
+		withContainerSettings(&mainContainer, cr)
+		containers = append(containers, mainContainer)
 
 		deployment := &appV1.Deployment{
 			TypeMeta: metaV1.TypeMeta{
@@ -303,6 +404,7 @@ func (d *Deploy) YamlToK8s() {
 			},
 
 			Spec: appV1.DeploymentSpec{
+				Replicas: &d.replicas,
 				Selector: &metaV1.LabelSelector{
 					MatchLabels: map[string]string{"lad_app": d.spaceUuid},
 				},
@@ -310,51 +412,58 @@ func (d *Deploy) YamlToK8s() {
 					ObjectMeta: metaV1.ObjectMeta{
 						Labels:    map[string]string{"lad_app": d.spaceUuid},
 						Namespace: d.k8sNameSpace,
+						Annotations: map[string]string{
+							PersistentStorageAnnotation: strconv.FormatInt(persistentStorage, 10),
+							dependenciesAnnotation:      dependencyNames(standalone),
+						},
 					},
 					Spec: coreV1.PodSpec{
-						NodeSelector: generateLabel(d.gpuProductName),
-						Containers:   containers,
-						Volumes:      volumes,
+						NodeSelector:   generateLabel(d.gpuProductName),
+						InitContainers: append(waitContainers, initContainers...),
+						Containers:     containers,
+						Volumes:        volumes,
 					},
 				},
 			}}
 
-		createDeployment, err := k8sService.CreateDeployment(context.TODO(), d.k8sNameSpace, deployment)
+		if err = d.withSpaceLifecycle(&deployment.Spec.Template.Spec); err != nil {
+			return models.NewJobFailure(models.FailureK8sDeploy, "%v", err)
+		}
+		withTerminationGracePeriod(&deployment.Spec.Template.Spec, cr)
+		createDeployment, err := d.applyDeployment(deployment)
 		if err != nil {
-			logs.GetLogger().Error(err)
-			return
+			return models.NewJobFailure(models.FailureK8sDeploy, "failed apply deployment: %v", err)
 		}
 		d.DeployName = createDeployment.GetName()
 		updateJobStatus(d.jobUuid, models.JobPullImage)
 
-		serviceHost, err := d.deployK8sResource(cr.Ports[0].ContainerPort)
-		if err != nil {
-			logs.GetLogger().Error(err)
-			return
+		var exposes []yaml.ExposePort
+		for _, service := range podServices(cr) {
+			exposes = append(exposes, service.Exposes...)
+		}
+		if len(exposes) == 0 {
+			return models.NewJobFailure(models.FailureInvalidSpec, "service %s does not expose any port", cr.Name)
+		}
+		if _, err = d.deployK8sResource(exposes); err != nil {
+			return models.NewJobFailure(models.FailureK8sDeploy, "%v", err)
 		}
 
 		updateJobStatus(d.jobUuid, models.JobDeployToK8s, "https://"+d.hostName)
-
-		if len(cr.Models) > 0 {
-			for _, res := range cr.Models {
-				go func(res yaml.ModelResource) {
-					downloadModelUrl(d.k8sNameSpace, d.spaceUuid, serviceHost, []string{"wget", res.Url, "-O", filepath.Join(res.Dir, res.Name)})
-				}(res)
-			}
-		}
 		d.watchContainerRunningTime()
 	}
+	return nil
 }
 
 func (d *Deploy) ModelInferenceToK8s() error {
 	var modelSetting struct {
 		ModelId string `json:"model_id"`
 	}
-	modelData, _ := os.ReadFile(d.modelsSettingFile)
-	err := json.Unmarshal(modelData, &modelSetting)
+	modelData, err := os.ReadFile(d.modelsSettingFile)
 	if err != nil {
-		logs.GetLogger().Errorf("convert model_id out to json failed, error: %+v", err)
-		return err
+		return models.NewJobFailure(models.FailureInvalidSpec, "failed read the model setting: %v", err)
+	}
+	if err = json.Unmarshal(modelData, &modelSetting); err != nil {
+		return models.NewJobFailure(models.FailureInvalidSpec, "convert model_id out to json failed: %v", err)
 	}
 
 	cpPath, _ := os.LookupEnv("CP_PATH")
@@ -362,8 +471,7 @@ func (d *Deploy) ModelInferenceToK8s() error {
 
 	modelInfoOut, err := util.RunPythonScript(filepath.Join(basePath, "/scripts/hf_client.py"), "model_info", modelSetting.ModelId)
 	if err != nil {
-		logs.GetLogger().Errorf("exec model_info cmd failed, error: %+v", err)
-		return err
+		return models.NewJobFailure(models.FailureSourceUnavailable, "exec model_info cmd failed: %v", err)
 	}
 
 	var modelInfo struct {
@@ -371,18 +479,15 @@ func (d *Deploy) ModelInferenceToK8s() error {
 		Task      string `json:"task"`
 		Framework string `json:"framework"`
 	}
-	err = json.Unmarshal([]byte(modelInfoOut), &modelInfo)
-	if err != nil {
-		logs.GetLogger().Errorf("convert model_info out to json failed, error: %+v", err)
-		return err
+	if err = json.Unmarshal([]byte(modelInfoOut), &modelInfo); err != nil {
+		return models.NewJobFailure(models.FailureSourceUnavailable, "convert model_info out to json failed: %v", err)
 	}
 
-	deleteJob(d.k8sNameSpace, d.spaceUuid)
 	imageName := "lagrange/" + modelInfo.Framework + ":v1.0"
 
 	logFile := filepath.Join(d.SpacePath, BuildFileName)
 	if _, err = os.Create(logFile); err != nil {
-		return err
+		return models.NewJobFailure(models.FailureImageBuild, "failed create the build log: %v", err)
 	}
 
 	var wg sync.WaitGroup
@@ -404,11 +509,9 @@ func (d *Deploy) ModelInferenceToK8s() error {
 	d.image = imageName
 
 	if err := d.deployNamespace(); err != nil {
-		logs.GetLogger().Error(err)
-		return err
+		return models.NewJobFailure(models.FailureK8sDeploy, "%v", err)
 	}
 
-	k8sService := NewK8sService()
 	deployment := &appV1.Deployment{
 		TypeMeta: metaV1.TypeMeta{
 			Kind:       "Deployment",
@@ -427,6 +530,8 @@ func (d *Deploy) ModelInferenceToK8s() error {
 				ObjectMeta: metaV1.ObjectMeta{
 					Labels:    map[string]string{"lad_app": d.spaceUuid},
 					Namespace: d.k8sNameSpace,
+					// the space has no standalone dependencies
+					Annotations: map[string]string{dependenciesAnnotation: ""},
 				},
 
 				Spec: coreV1.PodSpec{
@@ -444,18 +549,19 @@ func (d *Deploy) ModelInferenceToK8s() error {
 				},
 			},
 		}}
-	createDeployment, err := k8sService.CreateDeployment(context.TODO(), d.k8sNameSpace, deployment)
+	if err = d.withSpaceLifecycle(&deployment.Spec.Template.Spec); err != nil {
+		return models.NewJobFailure(models.FailureK8sDeploy, "%v", err)
+	}
+	createDeployment, err := d.applyDeployment(deployment)
 	if err != nil {
-		logs.GetLogger().Error(err)
-		return err
+		return models.NewJobFailure(models.FailureK8sDeploy, "failed apply deployment: %v", err)
 	}
 	d.DeployName = createDeployment.GetName()
 	updateJobStatus(d.jobUuid, models.JobPullImage)
-	logs.GetLogger().Infof("Created deployment: %s", createDeployment.GetObjectMeta().GetName())
+	logs.GetLogger().Infof("Applied deployment: %s", createDeployment.GetObjectMeta().GetName())
 
-	if _, err := d.deployK8sResource(int32(80)); err != nil {
-		logs.GetLogger().Error(err)
-		return err
+	if _, err := d.deployK8sResource(httpExpose(80)); err != nil {
+		return models.NewJobFailure(models.FailureK8sDeploy, "%v", err)
 	}
 	updateJobStatus(d.jobUuid, models.JobDeployToK8s)
 	d.watchContainerRunningTime()
@@ -478,17 +584,14 @@ func (d *Deploy) deployNamespace() error {
 			if err != nil {
 				return fmt.Errorf("failed create namespace, error: %w", err)
 			}
-
-			//networkPolicy, err := k8sService.CreateNetworkPolicy(context.TODO(), k8sNameSpace)
-			//if err != nil {
-			//	return fmt.Errorf("failed create networkPolicy, error: %w", err)
-			//}
-			//logs.GetLogger().Infof("create networkPolicy successfully, networkPolicyName: %s", networkPolicy.Name)
 		} else {
 			return err
 		}
 	}
-	return nil
+	if err := ensureNetworkPolicies(k8sService, d.k8sNameSpace); err != nil {
+		return err
+	}
+	return ensureNamespaceQuota(k8sService, d.k8sNameSpace, d.walletAddress)
 }
 
 func (d *Deploy) createEnv(envs ...coreV1.EnvVar) []coreV1.EnvVar {
@@ -515,17 +618,219 @@ func (d *Deploy) createEnv(envs ...coreV1.EnvVar) []coreV1.EnvVar {
 	return defaultEnv
 }
 
+// applyDeployment creates the deployment of the space or rolls the existing one
+// to the new spec. The old pods keep serving until the new ones are available,
+// unless there is no room for an extra replica next to them.
+func (d *Deploy) applyDeployment(deployment *appV1.Deployment) (*appV1.Deployment, error) {
+	k8sService := NewK8sService()
+	_, err := k8sService.k8sClient.AppsV1().Deployments(d.k8sNameSpace).Get(context.TODO(), deployment.Name, metaV1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	exists := err == nil
+
+	maxSurge := intstr.FromInt32(1)
+	maxUnavailable := intstr.FromInt32(0)
+	persistentStorage, _ := strconv.ParseInt(deployment.Spec.Template.Annotations[PersistentStorageAnnotation], 10, 64)
+	switch {
+	case !exists:
+		// a new deployment has no pods to replace, there is no surge to check
+	case persistentStorage > 0:
+		// a ReadWriteOnce claim can not be mounted by the old and the new pod on different nodes
+		maxSurge = intstr.FromInt32(0)
+		maxUnavailable = intstr.FromInt32(1)
+	case !surgeAvailable(d.hardwareDesc):
+		logs.GetLogger().Warnf("space_uuid: %s, no resources for an extra replica, the pods are replaced one by one", d.spaceUuid)
+		maxSurge = intstr.FromInt32(0)
+		maxUnavailable = intstr.FromInt32(1)
+	case !quotaAllowsSurge(k8sService, d.k8sNameSpace, deployment.Spec.Template.Spec):
+		logs.GetLogger().Warnf("space_uuid: %s, the quota of the namespace has no room for an extra replica, the pods are replaced one by one", d.spaceUuid)
+		maxSurge = intstr.FromInt32(0)
+		maxUnavailable = intstr.FromInt32(1)
+	}
+	deployment.Spec.Strategy = appV1.DeploymentStrategy{
+		Type: appV1.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: &appV1.RollingUpdateDeployment{
+			MaxSurge:       &maxSurge,
+			MaxUnavailable: &maxUnavailable,
+		},
+	}
+
+	result, updated, err := k8sService.ApplyDeployment(context.TODO(), d.k8sNameSpace, deployment)
+	if err == nil && updated {
+		logs.GetLogger().Infof("space_uuid: %s, rolling update of deployment %s started", d.spaceUuid, deployment.Name)
+	}
+	return result, err
+}
+
+// surgeAvailable tells whether the nodes have room for an extra replica of
+// the hardware, a failed check does not prevent the surge.
+func surgeAvailable(hardwareDesc string) bool {
+	available, _, err := checkResourceAvailableForSpace(hardwareDesc, 1, "")
+	return err != nil || available
+}
+
+// createPersistentVolumes creates a claim for every persistent mount of the
+// service and its dependencies. It returns the pod volumes and the claimed bytes.
+func (d *Deploy) createPersistentVolumes(cr yaml.ContainerResource) ([]coreV1.Volume, int64, error) {
+	names, size := d.persistentClaims(cr)
+	if len(names) == 0 {
+		return nil, 0, nil
+	}
+
+	k8sService := NewK8sService()
+	storageClass := conf.GetConfig().SPACE.StorageClass
+	accessMode := coreV1.ReadWriteOnce
+	if d.replicas > 1 {
+		if err := checkReadWriteMany(k8sService, storageClass); err != nil {
+			return nil, 0, err
+		}
+		accessMode = coreV1.ReadWriteMany
+	}
+
+	var volumes []coreV1.Volume
+	for _, name := range names {
+		claimName := constants.K8S_PVC_NAME_PREFIX + d.spaceUuid + "-" + name
+		if _, err := k8sService.CreatePersistentVolumeClaim(context.TODO(), d.k8sNameSpace, d.spaceUuid, claimName, size, accessMode, storageClass); err != nil {
+			return nil, 0, err
+		}
+		volumes = append(volumes, coreV1.Volume{
+			Name: constants.K8S_PVC_NAME_PREFIX + name,
+			VolumeSource: coreV1.VolumeSource{
+				PersistentVolumeClaim: &coreV1.PersistentVolumeClaimVolumeSource{
+					ClaimName: claimName,
+				},
+			},
+		})
+	}
+	return volumes, size.Value() * int64(len(names)), nil
+}
+
+// persistentClaims returns the names of the persistent mounts of the service
+// and its dependencies and the size of their claims. The storage of the
+// hardware config is split evenly between the claims and the ephemeral
+// storage of the pod.
+func (d *Deploy) persistentClaims(cr yaml.ContainerResource) ([]string, resource.Quantity) {
+	var names []string
+	var seen = make(map[string]bool)
+	mounts := cr.Persistent
+	for _, depend := range cr.Depends {
+		mounts = append(mounts, depend.Persistent...)
+	}
+	for _, mount := range mounts {
+		if !seen[mount.Name] {
+			seen[mount.Name] = true
+			names = append(names, mount.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, resource.Quantity{}
+	}
+
+	sizeInGi := d.hardwareResource.Storage.Quantity / int64(len(names)+1)
+	if sizeInGi < 1 {
+		sizeInGi = 1
+	}
+	return names, resource.MustParse(fmt.Sprintf("%dGi", sizeInGi))
+}
+
+// spaceResources returns the hardware the space paid for, the ephemeral
+// storage is what its persistent volume claims leave of the storage.
+func (d *Deploy) spaceResources(cr yaml.ContainerResource) (coreV1.ResourceRequirements, error) {
+	resources := d.createResources()
+	names, size := d.persistentClaims(cr)
+	if len(names) == 0 {
+		return resources, nil
+	}
+	claimed := *resource.NewQuantity(size.Value()*int64(len(names)), resource.BinarySI)
+	for _, list := range []coreV1.ResourceList{resources.Limits, resources.Requests} {
+		storage, ok := list[coreV1.ResourceEphemeralStorage]
+		if !ok {
+			continue
+		}
+		storage.Sub(claimed)
+		if storage.Sign() <= 0 {
+			return coreV1.ResourceRequirements{}, fmt.Errorf("the persistent storage of service %s uses all the storage of the space", cr.Name)
+		}
+		list[coreV1.ResourceEphemeralStorage] = storage
+	}
+	return resources, nil
+}
+
+// readWriteOnceProvisioners provision volumes that only the pods of one node
+// can mount, the replicas of a space on other nodes could not share them.
+var readWriteOnceProvisioners = map[string]bool{
+	"rancher.io/local-path":        true,
+	"kubernetes.io/no-provisioner": true,
+	"openebs.io/local":             true,
+	"topolvm.io":                   true,
+	"kubernetes.io/aws-ebs":        true,
+	"ebs.csi.aws.com":              true,
+	"kubernetes.io/gce-pd":         true,
+	"pd.csi.storage.gke.io":        true,
+	"kubernetes.io/azure-disk":     true,
+	"disk.csi.azure.com":           true,
+	"kubernetes.io/cinder":         true,
+	"cinder.csi.openstack.org":     true,
+}
+
+// checkReadWriteMany fails if the storage class, or the default one if it is
+// empty, can not provision ReadWriteMany volumes.
+func checkReadWriteMany(k8sService *K8sService, storageClass string) error {
+	class, err := k8sService.GetStorageClass(context.TODO(), storageClass)
+	if err != nil {
+		return fmt.Errorf("failed get storage class, error: %w", err)
+	}
+	if class == nil {
+		return fmt.Errorf("no default storage class for the ReadWriteMany claims of a space with more than one replica")
+	}
+	if readWriteOnceProvisioners[class.Provisioner] {
+		return fmt.Errorf("storage class %s (%s) does not support the ReadWriteMany claims of a space with more than one replica", class.Name, class.Provisioner)
+	}
+	return nil
+}
+
+func persistentVolumeMounts(mounts []yaml.PersistentMount) []coreV1.VolumeMount {
+	var volumeMounts []coreV1.VolumeMount
+	for _, mount := range mounts {
+		volumeMounts = append(volumeMounts, coreV1.VolumeMount{
+			Name:      constants.K8S_PVC_NAME_PREFIX + mount.Name,
+			MountPath: mount.Path,
+		})
+	}
+	return volumeMounts
+}
+
+// withSpaceLifecycle mounts the notice volume into the space containers and
+// sets the termination grace period of the pod.
+func (d *Deploy) withSpaceLifecycle(podSpec *coreV1.PodSpec) error {
+	if err := createSpaceNotice(d.k8sNameSpace, d.spaceUuid, time.Now().Unix()+d.duration); err != nil {
+		return fmt.Errorf("failed create notice config map, error: %w", err)
+	}
+
+	gracePeriod := terminationGracePeriod()
+	podSpec.TerminationGracePeriodSeconds = &gracePeriod
+
+	volume, volumeMount, envs := spaceNoticeVolume(d.spaceUuid)
+	podSpec.Volumes = append(podSpec.Volumes, volume)
+	for i := range podSpec.Containers {
+		podSpec.Containers[i].VolumeMounts = append(podSpec.Containers[i].VolumeMounts, volumeMount)
+		podSpec.Containers[i].Env = append(podSpec.Containers[i].Env, envs...)
+	}
+	return nil
+}
+
 func (d *Deploy) createResources() coreV1.ResourceRequirements {
 
 	memQuantity, err := resource.ParseQuantity(fmt.Sprintf("%d%s", d.hardwareResource.Memory.Quantity, d.hardwareResource.Memory.Unit))
 	if err != nil {
-		logs.GetLogger().Error("get memory failed, error: %+v", err)
+		logs.GetLogger().Errorf("get memory failed, error: %+v", err)
 		return coreV1.ResourceRequirements{}
 	}
 
 	storageQuantity, err := resource.ParseQuantity(fmt.Sprintf("%d%s", d.hardwareResource.Storage.Quantity, d.hardwareResource.Storage.Unit))
 	if err != nil {
-		logs.GetLogger().Error("get storage failed, error: %+v", err)
+		logs.GetLogger().Errorf("get storage failed, error: %+v", err)
 		return coreV1.ResourceRequirements{}
 	}
 
@@ -545,19 +850,339 @@ func (d *Deploy) createResources() coreV1.ResourceRequirements {
 	}
 }
 
-func (d *Deploy) deployK8sResource(containerPort int32) (string, error) {
+// withContainerSettings applies the process, probe and termination settings of
+// a deploy.yaml service to its container.
+func withContainerSettings(container *coreV1.Container, cr yaml.ContainerResource) {
+	container.WorkingDir = cr.WorkingDir
+	if cr.RunAsUser != nil || cr.RunAsGroup != nil {
+		container.SecurityContext = &coreV1.SecurityContext{
+			RunAsUser:  cr.RunAsUser,
+			RunAsGroup: cr.RunAsGroup,
+		}
+	}
+	container.LivenessProbe = cr.LivenessProbe
+	container.ReadinessProbe = cr.ReadinessProbe
+	container.StartupProbe = cr.StartupProbe
+	if len(cr.PreStop) > 0 {
+		container.Lifecycle = &coreV1.Lifecycle{
+			PreStop: &coreV1.LifecycleHandler{
+				Exec: &coreV1.ExecAction{Command: cr.PreStop},
+			},
+		}
+	}
+}
+
+// initContainers returns the init containers of the services in the pod, in
+// the order they are declared. An init container with a compute profile gets
+// it as requests and limits, one without runs with the resources of its
+// service before the service starts. resources maps the services to the
+// resources of their containers.
+func (d *Deploy) initContainers(cr yaml.ContainerResource, resources map[string]coreV1.ResourceRequirements) ([]coreV1.Container, error) {
+	var containers []coreV1.Container
+	for _, service := range podServices(cr) {
+		for _, init := range service.InitContainers {
+			container := coreV1.Container{
+				Name:            d.spaceUuid + "-" + init.Name,
+				Image:           init.ImageName,
+				Command:         init.Command,
+				Args:            init.Args,
+				Env:             init.Env,
+				WorkingDir:      init.WorkingDir,
+				ImagePullPolicy: coreV1.PullIfNotPresent,
+				VolumeMounts:    persistentVolumeMounts(service.Persistent),
+				Resources:       resources[service.Name],
+			}
+			if init.Profile != nil {
+				initResources, err := d.profileResources(init.Name, init.Profile)
+				if err != nil {
+					return nil, err
+				}
+				container.Resources = coreV1.ResourceRequirements{Limits: initResources, Requests: initResources}
+			}
+			if init.Sidecar {
+				restartPolicy := coreV1.ContainerRestartPolicyAlways
+				container.RestartPolicy = &restartPolicy
+			}
+			containers = append(containers, container)
+		}
+	}
+	return containers, nil
+}
+
+// withTerminationGracePeriod shortens the grace period of the pod to the
+// longest one the services ask for, the cp setting stays the upper bound.
+func withTerminationGracePeriod(podSpec *coreV1.PodSpec, cr yaml.ContainerResource) {
+	var gracePeriod *int64
+	for _, service := range podServices(cr) {
+		if service.TerminationGracePeriod != nil && (gracePeriod == nil || *service.TerminationGracePeriod > *gracePeriod) {
+			gracePeriod = service.TerminationGracePeriod
+		}
+	}
+	if gracePeriod == nil {
+		return
+	}
+	if podSpec.TerminationGracePeriodSeconds == nil || *gracePeriod < *podSpec.TerminationGracePeriodSeconds {
+		podSpec.TerminationGracePeriodSeconds = gracePeriod
+	}
+}
+
+// createSecretEnv stores the secrets of a service in a k8s Secret and returns
+// the env that references them, so the values stay out of the Deployment.
+func (d *Deploy) createSecretEnv(service string, secrets []coreV1.EnvVar) ([]coreV1.EnvVar, error) {
+	if len(secrets) == 0 {
+		return nil, nil
+	}
+	secretName := constants.K8S_SECRET_NAME_PREFIX + d.spaceUuid + "-" + service
+	data := make(map[string]string, len(secrets))
+	for _, secret := range secrets {
+		data[secret.Name] = secret.Value
+	}
+	if _, err := NewK8sService().CreateSecret(context.TODO(), d.k8sNameSpace, d.spaceUuid, secretName, data); err != nil {
+		return nil, err
+	}
+
+	var envs []coreV1.EnvVar
+	for _, secret := range secrets {
+		envs = append(envs, coreV1.EnvVar{
+			Name: secret.Name,
+			ValueFrom: &coreV1.EnvVarSource{
+				SecretKeyRef: &coreV1.SecretKeySelector{
+					LocalObjectReference: coreV1.LocalObjectReference{Name: secretName},
+					Key:                  secret.Name,
+				},
+			},
+		})
+	}
+	return envs, nil
+}
+
+// podResources returns the resources of the main container and of the
+// dependency containers, and what the service uses of the space with all its
+// replicas and standalone dependencies. Services with a compute profile get it
+// as requests and limits, the main container without one gets what is left of
+// the hardware the space paid for. Spaces without profiles keep the whole
+// hardware for the main container.
+func (d *Deploy) podResources(cr yaml.ContainerResource) (coreV1.ResourceRequirements, []coreV1.ResourceRequirements, coreV1.ResourceList, error) {
+	dependResources := make([]coreV1.ResourceRequirements, len(cr.Depends))
+	hasProfile := cr.Profile != nil
+	for _, depend := range cr.Depends {
+		if depend.Standalone && depend.Profile == nil {
+			return coreV1.ResourceRequirements{}, nil, nil, fmt.Errorf("standalone dependency %s needs a compute profile", depend.Name)
+		}
+		hasProfile = hasProfile || depend.Profile != nil
+	}
+	for _, service := range append([]yaml.ContainerResource{cr}, cr.Depends...) {
+		for _, init := range service.InitContainers {
+			if init.Sidecar && init.Profile == nil {
+				return coreV1.ResourceRequirements{}, nil, nil, fmt.Errorf("sidecar %s of service %s needs a compute profile", init.Name, service.Name)
+			}
+			hasProfile = hasProfile || init.Profile != nil
+		}
+	}
+	spaceResources, err := d.spaceResources(cr)
+	if err != nil {
+		return coreV1.ResourceRequirements{}, nil, nil, err
+	}
+	if !hasProfile {
+		return spaceResources, dependResources, replicaResources(spaceResources.Limits, cr.Count), nil
+	}
+
+	paid := spaceResources.Limits
+	// podUsed is used by every replica of the service, standaloneUsed once by
+	// the Deployments of its standalone dependencies
+	podUsed := make(coreV1.ResourceList)
+	standaloneUsed := make(coreV1.ResourceList)
+	for _, service := range append([]yaml.ContainerResource{cr}, cr.Depends...) {
+		for _, init := range service.InitContainers {
+			if init.Profile == nil {
+				continue
+			}
+			resources, err := d.profileResources(init.Name, init.Profile)
+			if err != nil {
+				return coreV1.ResourceRequirements{}, nil, nil, err
+			}
+			if !init.Sidecar {
+				// an init container runs alone before the services of the pod start
+				for name, quantity := range resources {
+					if paidQuantity := paid[name]; quantity.Cmp(paidQuantity) > 0 {
+						return coreV1.ResourceRequirements{}, nil, nil, fmt.Errorf("init container %s requests %s %s, but the space only has %s", init.Name, quantity.String(), name, paidQuantity.String())
+					}
+				}
+				continue
+			}
+			// a sidecar runs next to every replica of its service
+			if service.Standalone {
+				addResourceList(standaloneUsed, replicaResources(resources, service.Count))
+			} else {
+				addResourceList(podUsed, resources)
+			}
+		}
+	}
+	for i, depend := range cr.Depends {
+		if depend.Profile == nil {
+			continue
+		}
+		resources, err := d.profileResources(depend.Name, depend.Profile)
+		if err != nil {
+			return coreV1.ResourceRequirements{}, nil, nil, err
+		}
+		// every replica of a standalone dependency is paid by the space
+		if depend.Standalone {
+			addResourceList(standaloneUsed, replicaResources(resources, depend.Count))
+		} else {
+			addResourceList(podUsed, resources)
+		}
+		dependResources[i] = coreV1.ResourceRequirements{Limits: resources, Requests: resources}
+	}
+
+	var mainResources coreV1.ResourceList
+	if cr.Profile != nil {
+		resources, err := d.profileResources(cr.Name, cr.Profile)
+		if err != nil {
+			return coreV1.ResourceRequirements{}, nil, nil, err
+		}
+		mainResources = resources
+	} else {
+		mainResources = make(coreV1.ResourceList)
+		for name, quantity := range paid {
+			left := quantity.DeepCopy()
+			for _, used := range []coreV1.ResourceList{podUsed, standaloneUsed} {
+				if usedQuantity, ok := used[name]; ok {
+					left.Sub(usedQuantity)
+				}
+			}
+			if left.Sign() <= 0 && name != yaml.ResourceGpu {
+				return coreV1.ResourceRequirements{}, nil, nil, fmt.Errorf("the dependencies of service %s use all the %s of the space", cr.Name, name)
+			}
+			if left.Sign() > 0 {
+				mainResources[name] = left
+			}
+		}
+	}
+	addResourceList(podUsed, mainResources)
+
+	for name, quantity := range podUsed {
+		paidQuantity := paid[name]
+		if quantity.Cmp(paidQuantity) > 0 {
+			return coreV1.ResourceRequirements{}, nil, nil, fmt.Errorf("the services of a replica of %s request %s %s, but a replica of the space only has %s", cr.Name, quantity.String(), name, paidQuantity.String())
+		}
+	}
+	spaceUsed := replicaResources(podUsed, cr.Count)
+	addResourceList(spaceUsed, standaloneUsed)
+	return coreV1.ResourceRequirements{Limits: mainResources, Requests: mainResources}, dependResources, spaceUsed, nil
+}
+
+// checkSpaceResources fails if the services of the space use more than the
+// hardware of all the replicas paid by the order.
+func (d *Deploy) checkSpaceResources(used coreV1.ResourceList) error {
+	paid := replicaResources(d.createResources().Limits, int(d.maxReplicas))
+	for name, quantity := range used {
+		paidQuantity := paid[name]
+		if quantity.Cmp(paidQuantity) > 0 {
+			return fmt.Errorf("the services request %s %s, but the %d replicas of the space only have %s", quantity.String(), name, d.maxReplicas, paidQuantity.String())
+		}
+	}
+	return nil
+}
+
+// replicaResources returns the resources of the given number of replicas, at least one.
+func replicaResources(resources coreV1.ResourceList, replicas int) coreV1.ResourceList {
+	total := make(coreV1.ResourceList)
+	addResourceList(total, resources)
+	for replica := 1; replica < replicas; replica++ {
+		addResourceList(total, resources)
+	}
+	return total
+}
+
+func (d *Deploy) profileResources(service string, profile *yaml.Compute) (coreV1.ResourceList, error) {
+	resources, err := profile.ResourceList()
+	if err != nil {
+		return nil, fmt.Errorf("compute profile of service %s has %v", service, err)
+	}
+	gpuModel := strings.TrimSpace(profile.Resources.Gpu.Model)
+	if gpuModel != "" && !strings.Contains(strings.ToUpper(d.hardwareResource.Gpu.Unit), strings.ToUpper(gpuModel)) {
+		return nil, fmt.Errorf("service %s requires gpu %s, but the space runs on %q", service, gpuModel, d.hardwareResource.Gpu.Unit)
+	}
+	return resources, nil
+}
+
+func addResourceList(total, resources coreV1.ResourceList) {
+	for name, quantity := range resources {
+		sum := total[name]
+		sum.Add(quantity)
+		total[name] = sum
+	}
+}
+
+// deployK8sResource creates the service with every exposed port of the space.
+// The first global http port is published through the ingress, the other
+// global ports through a NodePort service. It returns the address of the
+// first port inside the cluster.
+func (d *Deploy) deployK8sResource(exposes []yaml.ExposePort) (string, error) {
+	if len(exposes) == 0 {
+		return "", fmt.Errorf("the space does not expose any port")
+	}
 	k8sService := NewK8sService()
 
-	createService, err := k8sService.CreateService(context.TODO(), d.k8sNameSpace, d.spaceUuid, containerPort)
+	var servicePorts []coreV1.ServicePort
+	var ingressPort *yaml.ExposePort
+	var nodePorts []yaml.ExposePort
+	for i, expose := range exposes {
+		servicePorts = append(servicePorts, spaceServicePort(expose))
+		if !expose.Global {
+			continue
+		}
+		if expose.Protocol == yaml.ExposeHttp && ingressPort == nil {
+			ingressPort = &exposes[i]
+			continue
+		}
+		nodePorts = append(nodePorts, expose)
+	}
+
+	createService, err := k8sService.CreateService(context.TODO(), d.k8sNameSpace, d.spaceUuid, servicePorts)
 	if err != nil {
 		return "", fmt.Errorf("failed creata service, error: %w", err)
 	}
-
 	serviceHost := fmt.Sprintf("http://%s:%d", createService.Spec.ClusterIP, createService.Spec.Ports[0].Port)
 
-	_, err = k8sService.CreateIngress(context.TODO(), d.k8sNameSpace, d.spaceUuid, d.hostName, containerPort)
+	var endpoints []models.JobEndpoint
+	for _, expose := range exposes {
+		if !expose.Global {
+			endpoints = append(endpoints, models.JobEndpoint{
+				Port:     expose.Port,
+				Protocol: expose.Protocol,
+				Url:      fmt.Sprintf("%s.%s:%d", createService.Name, d.k8sNameSpace, expose.As),
+			})
+		}
+	}
+
+	if ingressPort != nil {
+		customHosts := verifiedCustomDomains(d.spaceUuid, d.hostName, d.customDomains)
+		route := SpaceRoute{Hosts: append([]string{d.hostName}, customHosts...), Port: ingressPort.As}
+		if err = applySpaceRoute(k8sService, d.k8sNameSpace, d.spaceUuid, route); err != nil {
+			return "", fmt.Errorf("failed route space, error: %w", err)
+		}
+		endpoints = append(endpoints, models.JobEndpoint{
+			Port:     ingressPort.Port,
+			Protocol: ingressPort.Protocol,
+			Global:   true,
+			Url:      "https://" + d.hostName,
+		})
+	} else {
+		if err = deleteSpaceRoutes(k8sService, d.k8sNameSpace, d.spaceUuid); err != nil {
+			return "", fmt.Errorf("failed delete space route, error: %w", err)
+		}
+	}
+
+	nodePortEndpoints, err := d.publishNodePorts(nodePorts)
 	if err != nil {
-		return "", fmt.Errorf("failed creata ingress, error: %w", err)
+		return "", fmt.Errorf("failed publish node ports, error: %w", err)
+	}
+	endpoints = append(endpoints, nodePortEndpoints...)
+
+	if err = saveJobEndpoints(d.jobUuid, endpoints); err != nil {
+		logs.GetLogger().Errorf("Failed save job endpoints, job_uuid: %s, error: %+v", d.jobUuid, err)
 	}
 	return serviceHost, nil
 }
@@ -586,6 +1211,9 @@ func (d *Deploy) watchContainerRunningTime() {
 		"url":            fmt.Sprintf("https://%s", d.hostName),
 		"task_uuid":      d.taskUuid,
 		"space_type":     d.spaceType,
+		"replicas":       strconv.Itoa(int(d.replicas)),
+		"max_replicas":   strconv.Itoa(int(d.maxReplicas)),
+		"custom_domains": strings.Join(d.customDomains, ","),
 	}
 
 	for key, val := range fields {
@@ -0,0 +1,123 @@
+package computing
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/swanchain/go-computing-provider/internal/yaml"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func computeProfile(cpu, memory string) *yaml.Compute {
+	var profile yaml.Compute
+	profile.Resources.Cpu.Units = cpu
+	profile.Resources.Memory.Size = memory
+	return &profile
+}
+
+func testDeploy(maxReplicas int) *Deploy {
+	d := &Deploy{replicas: 1}
+	return d.WithHardware(4, 8, 20, "", 0).WithMaxReplicas(maxReplicas)
+}
+
+func TestPodResources(t *testing.T) {
+	d := testDeploy(2)
+
+	// without profiles the main container gets the whole hardware of a replica
+	main, depends, used, err := d.podResources(yaml.ContainerResource{Name: "web", Count: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpu := main.Limits[coreV1.ResourceCPU]; cpu.Cmp(resource.MustParse("4")) != 0 || len(depends) != 0 {
+		t.Fatalf("unexpected resources: %v, %v", main, depends)
+	}
+	if cpu := used[coreV1.ResourceCPU]; cpu.Cmp(resource.MustParse("8")) != 0 {
+		t.Fatalf("2 replicas use %s cpu, want 8", cpu.String())
+	}
+
+	// the main container without a profile gets what its dependencies leave
+	cr := yaml.ContainerResource{
+		Name:  "web",
+		Count: 2,
+		Depends: []yaml.ContainerResource{
+			{Name: "cache", Profile: computeProfile("1", "1Gi")},
+			{Name: "db", Standalone: true, Count: 2, Profile: computeProfile("1", "2Gi")},
+		},
+		InitContainers: []yaml.InitResource{
+			{Name: "proxy", Sidecar: true, Profile: computeProfile("500m", "256Mi")},
+			{Name: "migrate", Profile: computeProfile("4", "8Gi")},
+		},
+	}
+	main, depends, used, err = d.podResources(cr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpu := main.Limits[coreV1.ResourceCPU]; cpu.Cmp(resource.MustParse("500m")) != 0 {
+		t.Errorf("main container gets %s cpu, want 500m", cpu.String())
+	}
+	if cpu := depends[1].Limits[coreV1.ResourceCPU]; cpu.Cmp(resource.MustParse("1")) != 0 {
+		t.Errorf("db gets %s cpu, want 1", cpu.String())
+	}
+	// two replicas of web, cache and proxy, and two replicas of db
+	if cpu := used[coreV1.ResourceCPU]; cpu.Cmp(resource.MustParse("6")) != 0 {
+		t.Errorf("the space uses %s cpu, want 6", cpu.String())
+	}
+	if err = d.checkSpaceResources(used); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// a standalone dependency is paid once, not by every replica of the pod
+	cr = yaml.ContainerResource{
+		Name:    "web",
+		Count:   1,
+		Profile: computeProfile("2", "2Gi"),
+		Depends: []yaml.ContainerResource{
+			{Name: "db", Standalone: true, Count: 3, Profile: computeProfile("2", "2Gi")},
+		},
+	}
+	if _, _, used, err = d.podResources(cr); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.checkSpaceResources(used); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	cr.Depends[0].Count = 4
+	if _, _, used, err = d.podResources(cr); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.checkSpaceResources(used); err == nil || !strings.Contains(err.Error(), "cpu") {
+		t.Errorf("expected the cpu of the space to be exceeded, got: %v", err)
+	}
+
+	for _, invalid := range []yaml.ContainerResource{
+		{Name: "web", Profile: computeProfile("5", "1Gi")},
+		{Name: "web", Depends: []yaml.ContainerResource{{Name: "db", Standalone: true}}},
+		{Name: "web", InitContainers: []yaml.InitResource{{Name: "proxy", Sidecar: true}}},
+		{Name: "web", InitContainers: []yaml.InitResource{{Name: "migrate", Profile: computeProfile("8", "1Gi")}}},
+		{Name: "web", Depends: []yaml.ContainerResource{{Name: "db", Profile: computeProfile("4", "1Gi")}}},
+	} {
+		if _, _, _, err = d.podResources(invalid); err == nil {
+			t.Errorf("expected an error for %+v", invalid)
+		}
+	}
+}
+
+func TestCheckSpaceResources(t *testing.T) {
+	d := testDeploy(1)
+	// two services without profiles each take the hardware of the space
+	var used = make(coreV1.ResourceList)
+	for _, name := range []string{"web", "worker"} {
+		_, _, serviceUsed, err := d.podResources(yaml.ContainerResource{Name: name, Count: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		addResourceList(used, serviceUsed)
+	}
+	if err := d.checkSpaceResources(used); err == nil {
+		t.Fatal("expected the hardware of one replica to be exceeded")
+	}
+	if err := testDeploy(2).checkSpaceResources(used); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
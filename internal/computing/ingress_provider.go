@@ -0,0 +1,373 @@
+package computing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/swanchain/go-computing-provider/conf"
+	"github.com/swanchain/go-computing-provider/constants"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	IngressProviderNginx   = "nginx"
+	IngressProviderIngress = "ingress"
+	IngressProviderGateway = "gateway"
+)
+
+var httpRouteResource = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "httproutes"}
+
+// SpaceRoute is the http routing of a space, the first host is the space host
+// and the others are its custom domains.
+type SpaceRoute struct {
+	Hosts []string
+	Port  int32
+}
+
+// IngressProvider routes the http hosts of a space to the port of its service.
+type IngressProvider interface {
+	// ApplyRoute creates or updates the routing of the space.
+	ApplyRoute(ctx context.Context, namespace, spaceUuid string, route SpaceRoute) error
+	// GetRoute returns the routing of the space, nil if it is not routed.
+	GetRoute(ctx context.Context, namespace, spaceUuid string) (*SpaceRoute, error)
+	// DeleteRoute deletes the routing of the space and the certificates issued for it.
+	DeleteRoute(ctx context.Context, namespace, spaceUuid string) error
+}
+
+// NewIngressProvider returns the provider K8S.IngressProvider selects.
+func NewIngressProvider(k8sService *K8sService) (IngressProvider, error) {
+	k8s := k8sConfig()
+	switch k8s.IngressProvider {
+	case "", IngressProviderNginx:
+		annotations := map[string]string{"nginx.ingress.kubernetes.io/use-regex": "true"}
+		for key, value := range k8s.IngressAnnotations {
+			annotations[key] = value
+		}
+		return &ingressProvider{
+			k8sService:  k8sService,
+			class:       ingressClassName(),
+			annotations: annotations,
+			path:        "/*",
+		}, nil
+	case IngressProviderIngress:
+		return &ingressProvider{
+			k8sService:  k8sService,
+			class:       k8s.IngressClass,
+			annotations: k8s.IngressAnnotations,
+			path:        "/",
+		}, nil
+	case IngressProviderGateway:
+		gatewayNamespace, gatewayName, ok := strings.Cut(k8s.Gateway, "/")
+		if !ok || gatewayNamespace == "" || gatewayName == "" {
+			return nil, fmt.Errorf("invalid K8S.Gateway %q, expected namespace/name", k8s.Gateway)
+		}
+		return &gatewayProvider{
+			k8sService: k8sService,
+			namespace:  gatewayNamespace,
+			name:       gatewayName,
+			listener:   k8s.GatewayListener,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown K8S.IngressProvider %q, expected nginx, ingress or gateway", k8s.IngressProvider)
+}
+
+// applySpaceRoute routes the space with the configured provider and removes
+// the routing a previously configured provider of another kind created.
+func applySpaceRoute(k8sService *K8sService, namespace, spaceUuid string, route SpaceRoute) error {
+	provider, err := NewIngressProvider(k8sService)
+	if err != nil {
+		return err
+	}
+	if err = provider.ApplyRoute(context.TODO(), namespace, spaceUuid, route); err != nil {
+		return err
+	}
+	var stale IngressProvider = &ingressProvider{k8sService: k8sService}
+	if _, ok := provider.(*ingressProvider); ok {
+		stale = &gatewayProvider{k8sService: k8sService}
+	}
+	return stale.DeleteRoute(context.TODO(), namespace, spaceUuid)
+}
+
+// getSpaceRoute returns the routing of the space by the configured provider.
+func getSpaceRoute(k8sService *K8sService, namespace, spaceUuid string) (*SpaceRoute, error) {
+	provider, err := NewIngressProvider(k8sService)
+	if err != nil {
+		return nil, err
+	}
+	return provider.GetRoute(context.TODO(), namespace, spaceUuid)
+}
+
+// deleteSpaceRoutes deletes the routing of the space by every kind of
+// provider, so nothing is left when the provider was changed since it was deployed.
+func deleteSpaceRoutes(k8sService *K8sService, namespace, spaceUuid string) error {
+	providers := []IngressProvider{
+		&ingressProvider{k8sService: k8sService},
+		&gatewayProvider{k8sService: k8sService},
+	}
+	for _, provider := range providers {
+		if err := provider.DeleteRoute(context.TODO(), namespace, spaceUuid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ingressProvider routes the space with an Ingress of the class. The space
+// host is served with K8S.IngressTLSSecret, the custom domains by a second
+// "-domains" Ingress, every custom host with the certificate in its own
+// "tls-<host>" secret, which is issued by SPACE.CustomDomainIssuer if it is
+// set. The Ingress of the space host never carries the issuer annotation, so
+// cert-manager does not take over the shared secret.
+type ingressProvider struct {
+	k8sService  *K8sService
+	class       string
+	annotations map[string]string
+	path        string
+}
+
+func (p *ingressProvider) ApplyRoute(ctx context.Context, namespace, spaceUuid string, route SpaceRoute) error {
+	if len(route.Hosts) == 0 {
+		return fmt.Errorf("space %s has no host to route", spaceUuid)
+	}
+	ingress := p.ingress(constants.K8S_INGRESS_NAME_PREFIX+spaceUuid, spaceUuid, route.Hosts[:1], route.Port)
+	if secret := k8sConfig().IngressTLSSecret; secret != "" {
+		ingress.Spec.TLS = append(ingress.Spec.TLS, networkingv1.IngressTLS{
+			Hosts:      route.Hosts[:1],
+			SecretName: secret,
+		})
+	}
+	if _, err := p.k8sService.ApplyIngress(ctx, namespace, ingress); err != nil {
+		return err
+	}
+
+	domainsName := constants.K8S_INGRESS_NAME_PREFIX + spaceUuid + constants.K8S_DOMAINS_INGRESS_SUFFIX
+	if len(route.Hosts) == 1 {
+		return p.deleteIngress(ctx, namespace, domainsName)
+	}
+	domains := p.ingress(domainsName, spaceUuid, route.Hosts[1:], route.Port)
+	for _, host := range route.Hosts[1:] {
+		domains.Spec.TLS = append(domains.Spec.TLS, networkingv1.IngressTLS{
+			Hosts:      []string{host},
+			SecretName: constants.K8S_TLS_SECRET_NAME_PREFIX + host,
+		})
+	}
+	if certIssuer := conf.GetConfig().SPACE.CustomDomainIssuer; certIssuer != "" {
+		domains.Annotations["cert-manager.io/cluster-issuer"] = certIssuer
+	}
+	_, err := p.k8sService.ApplyIngress(ctx, namespace, domains)
+	return err
+}
+
+// ingress returns an Ingress of the class routing the hosts to the port of the space.
+func (p *ingressProvider) ingress(name, spaceUuid string, hosts []string, port int32) *networkingv1.Ingress {
+	annotations := make(map[string]string)
+	for key, value := range p.annotations {
+		annotations[key] = value
+	}
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{"lad_app": spaceUuid},
+			Annotations: annotations,
+		},
+	}
+	if p.class != "" {
+		class := p.class
+		ingress.Spec.IngressClassName = &class
+	}
+	for _, host := range hosts {
+		ingress.Spec.Rules = append(ingress.Spec.Rules, spaceIngressRule(spaceUuid, host, p.path, port))
+	}
+	return ingress
+}
+
+func (p *ingressProvider) GetRoute(ctx context.Context, namespace, spaceUuid string) (*SpaceRoute, error) {
+	var route *SpaceRoute
+	names := []string{
+		constants.K8S_INGRESS_NAME_PREFIX + spaceUuid,
+		constants.K8S_INGRESS_NAME_PREFIX + spaceUuid + constants.K8S_DOMAINS_INGRESS_SUFFIX,
+	}
+	for _, name := range names {
+		ingress, err := p.k8sService.k8sClient.NetworkingV1().Ingresses(namespace).Get(ctx, name, metaV1.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if route == nil {
+			route = new(SpaceRoute)
+		}
+		for _, rule := range ingress.Spec.Rules {
+			route.Hosts = append(route.Hosts, rule.Host)
+			if route.Port == 0 && rule.HTTP != nil && len(rule.HTTP.Paths) > 0 && rule.HTTP.Paths[0].Backend.Service != nil {
+				route.Port = rule.HTTP.Paths[0].Backend.Service.Port.Number
+			}
+		}
+	}
+	return route, nil
+}
+
+func (p *ingressProvider) DeleteRoute(ctx context.Context, namespace, spaceUuid string) error {
+	if err := p.deleteIngress(ctx, namespace, constants.K8S_INGRESS_NAME_PREFIX+spaceUuid+constants.K8S_DOMAINS_INGRESS_SUFFIX); err != nil {
+		return err
+	}
+	return p.deleteIngress(ctx, namespace, constants.K8S_INGRESS_NAME_PREFIX+spaceUuid)
+}
+
+// deleteIngress deletes the Ingress and the certificates the issuer created
+// for its custom domains, the ones provided by hand are kept.
+func (p *ingressProvider) deleteIngress(ctx context.Context, namespace, ingressName string) error {
+	ingress, err := p.k8sService.k8sClient.NetworkingV1().Ingresses(namespace).Get(ctx, ingressName, metaV1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if ingress.Annotations["cert-manager.io/cluster-issuer"] != "" {
+		for _, tls := range ingress.Spec.TLS {
+			if !strings.HasPrefix(tls.SecretName, constants.K8S_TLS_SECRET_NAME_PREFIX) || tls.SecretName == k8sConfig().IngressTLSSecret {
+				continue
+			}
+			if err = p.k8sService.k8sClient.CoreV1().Secrets(namespace).Delete(ctx, tls.SecretName, metaV1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	if err = p.k8sService.DeleteIngress(ctx, namespace, ingressName); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// gatewayProvider routes the space with a Gateway API HTTPRoute attached to a
+// Gateway, the Gateway terminates tls for the hosts of the spaces.
+type gatewayProvider struct {
+	k8sService *K8sService
+	namespace  string
+	name       string
+	listener   string
+}
+
+func (p *gatewayProvider) ApplyRoute(ctx context.Context, namespace, spaceUuid string, route SpaceRoute) error {
+	if len(route.Hosts) == 0 {
+		return fmt.Errorf("space %s has no host to route", spaceUuid)
+	}
+	parentRef := map[string]interface{}{
+		"name":      p.name,
+		"namespace": p.namespace,
+	}
+	if p.listener != "" {
+		parentRef["sectionName"] = p.listener
+	}
+	hostnames := make([]interface{}, 0, len(route.Hosts))
+	for _, host := range route.Hosts {
+		hostnames = append(hostnames, host)
+	}
+	spec := map[string]interface{}{
+		"parentRefs": []interface{}{parentRef},
+		"hostnames":  hostnames,
+		"rules": []interface{}{
+			map[string]interface{}{
+				"matches": []interface{}{
+					map[string]interface{}{
+						"path": map[string]interface{}{"type": "PathPrefix", "value": "/"},
+					},
+				},
+				"backendRefs": []interface{}{
+					map[string]interface{}{
+						"name": constants.K8S_SERVICE_NAME_PREFIX + spaceUuid,
+						"port": int64(route.Port),
+					},
+				},
+			},
+		},
+	}
+
+	httpRoutes := p.k8sService.dynamicClient.Resource(httpRouteResource).Namespace(namespace)
+	httpRoute := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": httpRouteResource.GroupVersion().String(),
+		"kind":       "HTTPRoute",
+		"metadata": map[string]interface{}{
+			"name":   constants.K8S_INGRESS_NAME_PREFIX + spaceUuid,
+			"labels": map[string]interface{}{"lad_app": spaceUuid},
+		},
+		"spec": spec,
+	}}
+	_, err := httpRoutes.Create(ctx, httpRoute, metaV1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		existing, err := httpRoutes.Get(ctx, httpRoute.GetName(), metaV1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		existing.Object["spec"] = spec
+		_, err = httpRoutes.Update(ctx, existing, metaV1.UpdateOptions{})
+		return err
+	}
+	return err
+}
+
+func (p *gatewayProvider) GetRoute(ctx context.Context, namespace, spaceUuid string) (*SpaceRoute, error) {
+	httpRoute, err := p.k8sService.dynamicClient.Resource(httpRouteResource).Namespace(namespace).Get(ctx, constants.K8S_INGRESS_NAME_PREFIX+spaceUuid, metaV1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	route := new(SpaceRoute)
+	route.Hosts, _, _ = unstructured.NestedStringSlice(httpRoute.Object, "spec", "hostnames")
+	rules, _, _ := unstructured.NestedSlice(httpRoute.Object, "spec", "rules")
+	if len(rules) > 0 {
+		if rule, ok := rules[0].(map[string]interface{}); ok {
+			backendRefs, _, _ := unstructured.NestedSlice(rule, "backendRefs")
+			if len(backendRefs) > 0 {
+				if backendRef, ok := backendRefs[0].(map[string]interface{}); ok {
+					port, _, _ := unstructured.NestedInt64(backendRef, "port")
+					route.Port = int32(port)
+				}
+			}
+		}
+	}
+	return route, nil
+}
+
+func (p *gatewayProvider) DeleteRoute(ctx context.Context, namespace, spaceUuid string) error {
+	err := p.k8sService.dynamicClient.Resource(httpRouteResource).Namespace(namespace).Delete(ctx, constants.K8S_INGRESS_NAME_PREFIX+spaceUuid, metaV1.DeleteOptions{})
+	// NotFound also when the cluster has no Gateway API
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func spaceIngressRule(spaceUuid, host, path string, port int32) networkingv1.IngressRule {
+	pathType := networkingv1.PathTypePrefix
+	return networkingv1.IngressRule{
+		Host: host,
+		IngressRuleValue: networkingv1.IngressRuleValue{
+			HTTP: &networkingv1.HTTPIngressRuleValue{
+				Paths: []networkingv1.HTTPIngressPath{
+					{
+						Path:     path,
+						PathType: &pathType,
+						Backend: networkingv1.IngressBackend{
+							Service: &networkingv1.IngressServiceBackend{
+								Name: constants.K8S_SERVICE_NAME_PREFIX + spaceUuid,
+								Port: networkingv1.ServiceBackendPort{
+									Number: port,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
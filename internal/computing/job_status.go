@@ -0,0 +1,355 @@
+package computing
+
+import (
+	"encoding/json"
+	stErrors "errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/gomodule/redigo/redis"
+	"github.com/swanchain/go-computing-provider/constants"
+	"github.com/swanchain/go-computing-provider/internal/models"
+)
+
+const (
+	jobStageTimePrefix     = "time:"
+	jobTerminalRetainInSec = 7 * 24 * 3600
+)
+
+var jobStatusLock sync.Mutex
+
+// saveJobReceived stores the job in the received state together with the
+// JobData returned to the hub. An existing job is reset to received only if it
+// is allowed to be redeployed, or if the job data forces it.
+func saveJobReceived(job models.Job, jobData models.JobData) error {
+	jobDataBytes, err := json.Marshal(jobData)
+	if err != nil {
+		return err
+	}
+
+	jobStatusLock.Lock()
+	defer jobStatusLock.Unlock()
+
+	redisConn := redisPool.Get()
+	defer redisConn.Close()
+
+	key := constants.REDIS_JOB_PREFIX + job.Uuid
+	var current models.JobStatus
+	if stored, err := retrieveJob(redisConn, job.Uuid); err == nil {
+		current = stored.Status
+	} else if err != NotFoundRedisKey {
+		return err
+	}
+	if !current.CanTransitionTo(models.JobReceived) {
+		// a forced job is received again whatever its status, e.g. when it is stuck
+		if !jobData.Force {
+			return fmt.Errorf("job_uuid: %s, illegal status transition from %s to %s", job.Uuid, current, models.JobReceived)
+		}
+		logs.GetLogger().Warnf("job_uuid: %s, forced status transition from %s to %s", job.Uuid, current, models.JobReceived)
+	}
+
+	now := time.Now().Unix()
+	if _, err = redisConn.Do("DEL", key); err != nil {
+		return err
+	}
+	if _, err = redisConn.Do("SADD", constants.REDIS_ACTIVE_JOBS, job.Uuid); err != nil {
+		return err
+	}
+	_, err = redisConn.Do("HSET", key,
+		"job_uuid", job.Uuid,
+		"task_uuid", job.TaskUuid,
+		"space_uuid", job.SpaceUuid,
+		"wallet_address", job.WalletAddress,
+		"status", models.JobReceived,
+		"reported_status", "",
+		"url", job.Url,
+		"job_data", jobDataBytes,
+		"updated_at", now,
+		jobStageTimePrefix+string(models.JobReceived), now)
+	return err
+}
+
+// saveJobData replaces the JobData of a received job.
+func saveJobData(jobData models.JobData) error {
+	jobDataBytes, err := json.Marshal(jobData)
+	if err != nil {
+		return err
+	}
+
+	redisConn := redisPool.Get()
+	defer redisConn.Close()
+	_, err = redisConn.Do("HSET", constants.REDIS_JOB_PREFIX+jobData.UUID, "job_data", jobDataBytes)
+	return err
+}
+
+// retrieveJobData returns the JobData that was answered when the job was
+// received, together with the endpoints of the deployed space.
+func retrieveJobData(jobUuid string) (*models.JobData, error) {
+	redisConn := redisPool.Get()
+	defer redisConn.Close()
+
+	values, err := redis.ByteSlices(redisConn.Do("HMGET", constants.REDIS_JOB_PREFIX+jobUuid, "job_data", "endpoints"))
+	if err != nil {
+		return nil, err
+	}
+	if values[0] == nil {
+		return nil, NotFoundRedisKey
+	}
+
+	var jobData models.JobData
+	if err = json.Unmarshal(values[0], &jobData); err != nil {
+		return nil, err
+	}
+	if values[1] != nil {
+		if err = json.Unmarshal(values[1], &jobData.Endpoints); err != nil {
+			return nil, err
+		}
+	}
+	return &jobData, nil
+}
+
+// updateJobStatus persists a status transition of the job. Illegal transitions
+// are rejected and logged; the ScheduleTask reports the stored status to the hub.
+func updateJobStatus(jobUuid string, jobStatus models.JobStatus, url ...string) {
+	var fields []interface{}
+	if len(url) > 0 {
+		fields = append(fields, "url", url[0])
+	}
+	if _, err := transitJobStatus(jobUuid, jobStatus, fields...); err != nil {
+		logs.GetLogger().Warnf("Failed update job status, error: %v", err)
+	}
+}
+
+// failJob moves the job to the failed status and stores the reason. Errors
+// that are not a *models.JobFailure are recorded with the unknown code.
+func failJob(jobUuid string, err error) {
+	var failure *models.JobFailure
+	if !stErrors.As(err, &failure) {
+		failure = models.NewJobFailure(models.FailureUnknown, "deployment failed")
+		if err != nil {
+			failure.Message = err.Error()
+		}
+	}
+
+	stage, err := transitJobStatus(jobUuid, models.JobFailed,
+		"failure_stage", failure.Stage,
+		"failure_code", failure.Code,
+		"failure_message", failure.Message)
+	if err != nil {
+		logs.GetLogger().Warnf("Failed update job status, error: %v", err)
+		return
+	}
+	if failure.Stage != "" {
+		stage = failure.Stage
+	}
+	logs.GetLogger().Errorf("job_uuid: %s, failed at %s, code: %s, reason: %s", jobUuid, stage, failure.Code, failure.Message)
+}
+
+// transitJobStatus stores the new status with the extra hash fields and
+// returns the status the job was in before, which is also kept as previous_status.
+func transitJobStatus(jobUuid string, jobStatus models.JobStatus, fields ...interface{}) (models.JobStatus, error) {
+	jobStatusLock.Lock()
+	defer jobStatusLock.Unlock()
+
+	redisConn := redisPool.Get()
+	defer redisConn.Close()
+
+	job, err := retrieveJob(redisConn, jobUuid)
+	if err != nil {
+		return "", fmt.Errorf("job_uuid: %s, get job failed, error: %w", jobUuid, err)
+	}
+	if !job.Status.CanTransitionTo(jobStatus) {
+		return "", fmt.Errorf("job_uuid: %s, illegal status transition from %s to %s", jobUuid, job.Status, jobStatus)
+	}
+
+	now := time.Now().Unix()
+	args := []interface{}{constants.REDIS_JOB_PREFIX + jobUuid,
+		"status", jobStatus,
+		"previous_status", job.Status,
+		"updated_at", now,
+		jobStageTimePrefix + string(jobStatus), now,
+	}
+	args = append(args, fields...)
+	_, err = redisConn.Do("HSET", args...)
+	return job.Status, err
+}
+
+// markJobRollingUpdate records that the job replaces the pods of a running
+// space, so a failed rollout is rolled back instead of removed.
+func markJobRollingUpdate(jobUuid string) error {
+	redisConn := redisPool.Get()
+	defer redisConn.Close()
+	_, err := redisConn.Do("HSET", constants.REDIS_JOB_PREFIX+jobUuid, "rolling_update", "true")
+	return err
+}
+
+// markJobReported records that the hub acknowledged the given status. A job
+// whose terminal status is acknowledged leaves the active jobs.
+func markJobReported(jobUuid string, jobStatus models.JobStatus) error {
+	jobStatusLock.Lock()
+	defer jobStatusLock.Unlock()
+
+	redisConn := redisPool.Get()
+	defer redisConn.Close()
+
+	key := constants.REDIS_JOB_PREFIX + jobUuid
+	if _, err := redisConn.Do("HSET", key, "reported_status", jobStatus); err != nil {
+		return err
+	}
+	if jobStatus.IsTerminal() {
+		if _, err := redisConn.Do("EXPIRE", key, jobTerminalRetainInSec); err != nil {
+			return err
+		}
+		if _, err := redisConn.Do("SREM", constants.REDIS_ACTIVE_JOBS, jobUuid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func RetrieveJob(jobUuid string) (*models.Job, error) {
+	redisConn := redisPool.Get()
+	defer redisConn.Close()
+	return retrieveJob(redisConn, jobUuid)
+}
+
+func retrieveJob(redisConn redis.Conn, jobUuid string) (*models.Job, error) {
+	values, err := redis.StringMap(redisConn.Do("HGETALL", constants.REDIS_JOB_PREFIX+jobUuid))
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, NotFoundRedisKey
+	}
+
+	job := &models.Job{
+		Uuid:           values["job_uuid"],
+		TaskUuid:       values["task_uuid"],
+		SpaceUuid:      values["space_uuid"],
+		WalletAddress:  values["wallet_address"],
+		Status:         models.JobStatus(values["status"]),
+		ReportedStatus: models.JobStatus(values["reported_status"]),
+		Url:            values["url"],
+		StageTimes:     make(map[models.JobStatus]int64),
+	}
+	job.UpdatedAt, _ = strconv.ParseInt(values["updated_at"], 10, 64)
+	job.RollingUpdate = values["rolling_update"] == "true"
+	if job.Status == models.JobFailed {
+		job.Failure = &models.JobFailure{
+			Stage:   models.JobStatus(values["failure_stage"]),
+			Code:    models.JobFailureCode(values["failure_code"]),
+			Message: values["failure_message"],
+		}
+		if job.Failure.Stage == "" {
+			job.Failure.Stage = models.JobStatus(values["previous_status"])
+		}
+	}
+	for field, val := range values {
+		if strings.HasPrefix(field, jobStageTimePrefix) {
+			stageTime, _ := strconv.ParseInt(val, 10, 64)
+			job.StageTimes[models.JobStatus(strings.TrimPrefix(field, jobStageTimePrefix))] = stageTime
+		}
+	}
+	return job, nil
+}
+
+// listJobs returns the active jobs, those not terminal or whose terminal
+// status is not reported yet. The filter may be nil.
+func listJobs(filter func(job *models.Job) bool) ([]*models.Job, error) {
+	redisConn := redisPool.Get()
+	defer redisConn.Close()
+
+	jobUuids, err := scanSet(redisConn, constants.REDIS_ACTIVE_JOBS)
+	if err != nil {
+		return nil, fmt.Errorf("failed get redis %s, error: %w", constants.REDIS_ACTIVE_JOBS, err)
+	}
+
+	var jobs []*models.Job
+	for _, jobUuid := range jobUuids {
+		job, err := retrieveJob(redisConn, jobUuid)
+		if err == NotFoundRedisKey {
+			redisConn.Do("SREM", constants.REDIS_ACTIVE_JOBS, jobUuid)
+			continue
+		}
+		if err != nil {
+			logs.GetLogger().Errorf("Failed get job, job_uuid: %s, error: %+v", jobUuid, err)
+			continue
+		}
+		if filter == nil || filter(job) {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// scanSet returns the members of a redis set without blocking redis on a large one.
+func scanSet(conn redis.Conn, key string) ([]string, error) {
+	var members []string
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SSCAN", key, cursor, "COUNT", 1000))
+		if err != nil {
+			return nil, err
+		}
+		if cursor, err = redis.Int(values[0], nil); err != nil {
+			return nil, err
+		}
+		batch, err := redis.Strings(values[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, batch...)
+		if cursor == 0 {
+			return members, nil
+		}
+	}
+}
+
+// indexActiveJobs adds the jobs stored by a cp without the set of active jobs
+// to it, it runs once at start.
+func indexActiveJobs() {
+	redisConn := redisPool.Get()
+	defer redisConn.Close()
+
+	keys, err := scanKeys(redisConn, constants.REDIS_JOB_PREFIX+"*")
+	if err != nil {
+		logs.GetLogger().Errorf("Failed index active jobs, error: %+v", err)
+		return
+	}
+	for _, key := range keys {
+		job, err := retrieveJob(redisConn, strings.TrimPrefix(key, constants.REDIS_JOB_PREFIX))
+		if err != nil {
+			continue
+		}
+		if job.Status.IsTerminal() && job.Status == job.ReportedStatus {
+			continue
+		}
+		if _, err = redisConn.Do("SADD", constants.REDIS_ACTIVE_JOBS, job.Uuid); err != nil {
+			logs.GetLogger().Errorf("Failed index active jobs, job_uuid: %s, error: %+v", job.Uuid, err)
+		}
+	}
+}
+
+// failInterruptedJobs marks jobs whose deploy worker died with the previous cp
+// process as failed. Received jobs are still queued and deploying jobs are
+// already handed over to k8s, so both are left untouched.
+func failInterruptedJobs() {
+	jobs, err := listJobs(func(job *models.Job) bool {
+		switch job.Status {
+		case models.JobDownloadSource, models.JobBuildImage, models.JobPushImage, models.JobPullImage:
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		logs.GetLogger().Error(err)
+		return
+	}
+	for _, job := range jobs {
+		failJob(job.Uuid, models.NewJobFailure(models.FailureInterrupted, "deployment was interrupted at %s by a restart of the cp", job.Status))
+	}
+}
@@ -0,0 +1,283 @@
+package computing
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/swanchain/go-computing-provider/internal/models"
+	appV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	coreInformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	listersAppsV1 "k8s.io/client-go/listers/apps/v1"
+	listersCoreV1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	clusterCacheResync      = 10 * time.Minute
+	clusterCacheSyncTimeout = 2 * time.Minute
+	// resourceExporterTTL is how long the gpu info read from the logs of the
+	// resource-exporter pods is reused.
+	resourceExporterTTL = time.Minute
+)
+
+// clusterCache keeps the nodes, the running pods, the deployments, the
+// namespaces and the space volume claims of the cluster in shared informers, so
+// that the resource accounting does not list them from the API server on every
+// call.
+type clusterCache struct {
+	nodes       listersCoreV1.NodeLister
+	pods        listersCoreV1.PodLister
+	deployments listersAppsV1.DeploymentLister
+	namespaces  listersCoreV1.NamespaceLister
+	claims      listersCoreV1.PersistentVolumeClaimLister
+	informers   map[string]cache.SharedIndexInformer
+	startedAt   time.Time
+
+	lock       sync.RWMutex
+	synced     bool
+	syncedAt   time.Time
+	lastEvents map[string]time.Time
+
+	exporterLock sync.Mutex
+	exporterInfo map[string]models.CollectNodeInfo
+	exporterAt   time.Time
+}
+
+var clusterCacheOnce sync.Once
+var sharedClusterCache *clusterCache
+
+// ClusterCacheStatus reports the freshness of the cluster cache.
+type ClusterCacheStatus struct {
+	Started             bool                       `json:"started"`
+	Synced              bool                       `json:"synced"`
+	StartedAt           int64                      `json:"started_at,omitempty"`
+	SyncedAt            int64                      `json:"synced_at,omitempty"`
+	Informers           []ClusterCacheInformerInfo `json:"informers"`
+	ResourceExporterAt  int64                      `json:"resource_exporter_at,omitempty"`
+	ResourceExporterTTL int64                      `json:"resource_exporter_ttl"`
+}
+
+type ClusterCacheInformerInfo struct {
+	Resource        string `json:"resource"`
+	Synced          bool   `json:"synced"`
+	Objects         int    `json:"objects"`
+	LastEventAt     int64  `json:"last_event_at,omitempty"`
+	LastResourceVer string `json:"last_resource_version"`
+}
+
+// StartClusterCache starts the informers of the cluster cache, they sync in the
+// background. Until they are synced the queries of K8sService go to the API
+// server.
+func StartClusterCache() {
+	clusterCacheOnce.Do(func() {
+		k8sService := NewK8sService()
+		if k8sService.k8sClient == nil {
+			logs.GetLogger().Warnf("No k8s client, the cluster cache is disabled")
+			return
+		}
+		sharedClusterCache = newClusterCache(k8sService.streamClient)
+	})
+}
+
+func newClusterCache(client *kubernetes.Clientset) *clusterCache {
+	factory := informers.NewSharedInformerFactory(client, clusterCacheResync)
+	// only the running pods are accounted, the others are not kept in memory
+	podInformer := factory.InformerFor(&coreV1.Pod{}, func(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+		return coreInformers.NewFilteredPodInformer(client, metaV1.NamespaceAll, resync, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, func(options *metaV1.ListOptions) {
+			options.FieldSelector = "status.phase=Running"
+		})
+	})
+	// only the claims of the spaces are accounted
+	claimInformer := factory.InformerFor(&coreV1.PersistentVolumeClaim{}, func(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+		return coreInformers.NewFilteredPersistentVolumeClaimInformer(client, metaV1.NamespaceAll, resync, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, func(options *metaV1.ListOptions) {
+			options.LabelSelector = "lad_app"
+		})
+	})
+
+	c := &clusterCache{
+		nodes:       factory.Core().V1().Nodes().Lister(),
+		pods:        listersCoreV1.NewPodLister(podInformer.GetIndexer()),
+		deployments: factory.Apps().V1().Deployments().Lister(),
+		namespaces:  factory.Core().V1().Namespaces().Lister(),
+		claims:      listersCoreV1.NewPersistentVolumeClaimLister(claimInformer.GetIndexer()),
+		informers: map[string]cache.SharedIndexInformer{
+			"nodes":       factory.Core().V1().Nodes().Informer(),
+			"pods":        podInformer,
+			"deployments": factory.Apps().V1().Deployments().Informer(),
+			"namespaces":  factory.Core().V1().Namespaces().Informer(),
+			"claims":      claimInformer,
+		},
+		startedAt:  time.Now(),
+		lastEvents: make(map[string]time.Time),
+	}
+	for resource, informer := range c.informers {
+		resource := resource
+		onEvent := func(interface{}) { c.recordEvent(resource) }
+		_, _ = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    onEvent,
+			UpdateFunc: func(_, obj interface{}) { onEvent(obj) },
+			DeleteFunc: onEvent,
+		})
+	}
+
+	factory.Start(wait.NeverStop)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), clusterCacheSyncTimeout)
+		defer cancel()
+		var hasSynced []cache.InformerSynced
+		for _, informer := range c.informers {
+			hasSynced = append(hasSynced, informer.HasSynced)
+		}
+		if !cache.WaitForCacheSync(ctx.Done(), hasSynced...) {
+			logs.GetLogger().Warnf("The cluster cache is not synced after %v, querying the API server until it is", clusterCacheSyncTimeout)
+			cache.WaitForCacheSync(wait.NeverStop, hasSynced...)
+		}
+		c.lock.Lock()
+		c.synced = true
+		c.syncedAt = time.Now()
+		c.lock.Unlock()
+		logs.GetLogger().Infof("The cluster cache is synced in %v", time.Since(c.startedAt).Round(time.Millisecond))
+	}()
+	return c
+}
+
+func (c *clusterCache) recordEvent(resource string) {
+	c.lock.Lock()
+	c.lastEvents[resource] = time.Now()
+	c.lock.Unlock()
+}
+
+// ready tells whether the queries can be answered from the cache.
+func (c *clusterCache) ready() bool {
+	if c == nil {
+		return false
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.synced
+}
+
+func (c *clusterCache) status() ClusterCacheStatus {
+	status := ClusterCacheStatus{ResourceExporterTTL: int64(resourceExporterTTL.Seconds())}
+	if c == nil {
+		return status
+	}
+	status.Started = true
+	status.StartedAt = c.startedAt.Unix()
+
+	c.lock.RLock()
+	status.Synced = c.synced
+	if c.synced {
+		status.SyncedAt = c.syncedAt.Unix()
+	}
+	for resource, informer := range c.informers {
+		info := ClusterCacheInformerInfo{
+			Resource:        resource,
+			Synced:          informer.HasSynced(),
+			Objects:         len(informer.GetStore().ListKeys()),
+			LastResourceVer: informer.LastSyncResourceVersion(),
+		}
+		if lastEvent, ok := c.lastEvents[resource]; ok {
+			info.LastEventAt = lastEvent.Unix()
+		}
+		status.Informers = append(status.Informers, info)
+	}
+	c.lock.RUnlock()
+	sort.Slice(status.Informers, func(i, j int) bool {
+		return status.Informers[i].Resource < status.Informers[j].Resource
+	})
+
+	c.exporterLock.Lock()
+	if !c.exporterAt.IsZero() {
+		status.ResourceExporterAt = c.exporterAt.Unix()
+	}
+	c.exporterLock.Unlock()
+	return status
+}
+
+// resourceExporterInfo returns the gpu info of the nodes, it is read from the
+// logs of the resource-exporter pods at most once per resourceExporterTTL.
+func (c *clusterCache) resourceExporterInfo(read func() (map[string]models.CollectNodeInfo, error)) (map[string]models.CollectNodeInfo, error) {
+	c.exporterLock.Lock()
+	defer c.exporterLock.Unlock()
+	if c.exporterInfo != nil && time.Since(c.exporterAt) < resourceExporterTTL {
+		return c.exporterInfo, nil
+	}
+	info, err := read()
+	if err != nil {
+		return nil, err
+	}
+	c.exporterInfo = info
+	c.exporterAt = time.Now()
+	return info, nil
+}
+
+func (c *clusterCache) listNodes() ([]coreV1.Node, error) {
+	nodes, err := c.nodes.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]coreV1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		result = append(result, *node)
+	}
+	return result, nil
+}
+
+func (c *clusterCache) listPods(namespace string, selector labels.Selector) ([]coreV1.Pod, error) {
+	pods, err := c.pods.Pods(namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]coreV1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		result = append(result, *pod)
+	}
+	return result, nil
+}
+
+func (c *clusterCache) listDeployments(namespace string) ([]appV1.Deployment, error) {
+	deployments, err := c.deployments.Deployments(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]appV1.Deployment, 0, len(deployments))
+	for _, deployment := range deployments {
+		result = append(result, *deployment)
+	}
+	return result, nil
+}
+
+func (c *clusterCache) listClaims(namespace string) ([]coreV1.PersistentVolumeClaim, error) {
+	claims, err := c.claims.PersistentVolumeClaims(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]coreV1.PersistentVolumeClaim, 0, len(claims))
+	for _, claim := range claims {
+		result = append(result, *claim)
+	}
+	return result, nil
+}
+
+func (c *clusterCache) listNamespaces() ([]string, error) {
+	namespaces, err := c.namespaces.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		result = append(result, namespace.Name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
@@ -0,0 +1,105 @@
+package computing
+
+import (
+	"strings"
+	"time"
+
+	"github.com/swanchain/go-computing-provider/conf"
+	"github.com/swanchain/go-computing-provider/constants"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	defaultK8sQPS             = 30
+	defaultK8sBurst           = 50
+	defaultIngressClass       = "nginx"
+	defaultK8sSystemNamespace = "kube-system"
+)
+
+// k8sConfig returns the [K8S] section, empty if the config is not loaded.
+func k8sConfig() conf.K8S {
+	if conf.GetConfig() == nil {
+		return conf.K8S{}
+	}
+	return conf.GetConfig().K8S
+}
+
+// kubeRestConfig builds the client config from K8S.KubeConfig and K8S.Context.
+// Without them the in-cluster config is used, and outside of a cluster the
+// kubeconfig of the user with its current context.
+func kubeRestConfig() (*rest.Config, error) {
+	k8s := k8sConfig()
+
+	var restConfig *rest.Config
+	var err error
+	if k8s.KubeConfig == "" && k8s.Context == "" {
+		restConfig, err = rest.InClusterConfig()
+	}
+	if restConfig == nil {
+		loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+		if k8s.KubeConfig != "" {
+			loadingRules.ExplicitPath = k8s.KubeConfig
+		}
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: k8s.Context}
+		restConfig, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	restConfig.QPS = defaultK8sQPS
+	if k8s.QPS > 0 {
+		restConfig.QPS = k8s.QPS
+	}
+	restConfig.Burst = defaultK8sBurst
+	if k8s.Burst > 0 {
+		restConfig.Burst = k8s.Burst
+	}
+	return restConfig, nil
+}
+
+// requestRestConfig returns a copy of the client config whose requests end
+// after K8S.Timeout. Streams and watches last longer, they use the config
+// without a timeout.
+func requestRestConfig(restConfig *rest.Config) *rest.Config {
+	requestConfig := rest.CopyConfig(restConfig)
+	if timeout := k8sConfig().Timeout; timeout > 0 {
+		requestConfig.Timeout = time.Duration(timeout) * time.Second
+	}
+	return requestConfig
+}
+
+// ingressClassName is the ingress class of the space ingresses, K8S.IngressClass.
+func ingressClassName() string {
+	if class := k8sConfig().IngressClass; class != "" {
+		return class
+	}
+	return defaultIngressClass
+}
+
+// namespacePrefix is the prefix of the namespaces of the wallets, K8S.NamespacePrefix.
+func namespacePrefix() string {
+	if prefix := k8sConfig().NamespacePrefix; prefix != "" {
+		return prefix
+	}
+	return constants.K8S_NAMESPACE_NAME_PREFIX
+}
+
+// SpaceNamespace is the namespace the spaces of the wallet run in.
+func SpaceNamespace(walletAddress string) string {
+	return namespacePrefix() + strings.ToLower(walletAddress)
+}
+
+// isSpaceNamespace tells whether the namespace holds the spaces of a wallet.
+func isSpaceNamespace(namespace string) bool {
+	return strings.HasPrefix(namespace, namespacePrefix())
+}
+
+// systemNamespace is the namespace resource-exporter runs in, K8S.SystemNamespace.
+func systemNamespace() string {
+	if namespace := k8sConfig().SystemNamespace; namespace != "" {
+		return namespace
+	}
+	return defaultK8sSystemNamespace
+}
@@ -5,7 +5,6 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"flag"
 	"fmt"
 	"github.com/swanchain/go-computing-provider/constants"
 	"github.com/swanchain/go-computing-provider/internal/models"
@@ -15,59 +14,76 @@ import (
 	"k8s.io/client-go/tools/remotecommand"
 	"k8s.io/client-go/util/retry"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	appV1 "k8s.io/api/apps/v1"
 	coreV1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
 
 	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
 	networkingv1 "k8s.io/api/networking/v1"
+	storageV1 "k8s.io/api/storage/v1"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
 )
 
+const (
+	deploymentRevisionAnnotation  = "deployment.kubernetes.io/revision"
+	defaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+	// pausedReplicasAnnotation keeps the replica count of a standalone
+	// dependency while its space is paused.
+	pausedReplicasAnnotation = "lad_paused_replicas"
+)
+
+var ErrNoPreviousRevision = errors.New("no previous revision of the deployment")
+
 var clientSet *kubernetes.Clientset
+var streamClientSet *kubernetes.Clientset
 var k8sOnce sync.Once
 var config *rest.Config
 var version string
+var dynamicClient dynamic.Interface
 
+// K8sService talks to the API server. The requests of k8sClient end after
+// K8S.Timeout, the log streams, watches and informers use streamClient.
 type K8sService struct {
-	k8sClient *kubernetes.Clientset
-	Version   string
-	config    *rest.Config
+	k8sClient     *kubernetes.Clientset
+	streamClient  *kubernetes.Clientset
+	dynamicClient dynamic.Interface
+	Version       string
+	config        *rest.Config
 }
 
 func NewK8sService() *K8sService {
 	var err error
 	k8sOnce.Do(func() {
-		config, err = rest.InClusterConfig()
+		config, err = kubeRestConfig()
 		if err != nil {
-			var kubeConfig *string
-			if home := homedir.HomeDir(); home != "" {
-				kubeConfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
-			} else {
-				kubeConfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
-			}
-			flag.Parse()
-			config, err = clientcmd.BuildConfigFromFlags("", *kubeConfig)
-			if err != nil {
-				return
-			}
+			logs.GetLogger().Errorf("Failed load k8s config, error: %v", err)
+			return
+		}
+		clientSet, err = kubernetes.NewForConfig(requestRestConfig(config))
+		if err != nil {
+			logs.GetLogger().Errorf("Failed create k8s clientset, error: %v", err)
+			return
 		}
-		config.QPS = 30
-		config.Burst = 50
-		clientSet, err = kubernetes.NewForConfig(config)
+		streamClientSet, err = kubernetes.NewForConfig(config)
 		if err != nil {
 			logs.GetLogger().Errorf("Failed create k8s clientset, error: %v", err)
 			return
 		}
+		dynamicClient, err = dynamic.NewForConfig(requestRestConfig(config))
+		if err != nil {
+			logs.GetLogger().Errorf("Failed create k8s dynamic client, error: %v", err)
+			return
+		}
 
 		versionInfo, err := clientSet.Discovery().ServerVersion()
 		if err != nil {
@@ -78,9 +94,11 @@ func NewK8sService() *K8sService {
 	})
 
 	return &K8sService{
-		k8sClient: clientSet,
-		Version:   version,
-		config:    config,
+		k8sClient:     clientSet,
+		streamClient:  streamClientSet,
+		dynamicClient: dynamicClient,
+		Version:       version,
+		config:        config,
 	}
 }
 
@@ -88,6 +106,90 @@ func (s *K8sService) CreateDeployment(ctx context.Context, nameSpace string, dep
 	return s.k8sClient.AppsV1().Deployments(nameSpace).Create(ctx, deploy, metaV1.CreateOptions{})
 }
 
+// ApplyDeployment creates the deployment, or updates the pod template of the
+// existing one so that k8s replaces the pods by a rolling update. The bool
+// result reports whether an existing deployment was updated.
+func (s *K8sService) ApplyDeployment(ctx context.Context, nameSpace string, deploy *appV1.Deployment) (*appV1.Deployment, bool, error) {
+	existing, err := s.k8sClient.AppsV1().Deployments(nameSpace).Get(ctx, deploy.Name, metaV1.GetOptions{})
+	if err != nil {
+		if !k8sErrors.IsNotFound(err) {
+			return nil, false, err
+		}
+		result, err := s.CreateDeployment(ctx, nameSpace, deploy)
+		return result, false, err
+	}
+
+	existing.Spec.Replicas = deploy.Spec.Replicas
+	existing.Spec.Strategy = deploy.Spec.Strategy
+	existing.Spec.Template = deploy.Spec.Template
+	result, err := s.k8sClient.AppsV1().Deployments(nameSpace).Update(ctx, existing, metaV1.UpdateOptions{})
+	return result, true, err
+}
+
+// RollbackDeployment restores the pod template of the previous revision of the
+// deployment, it returns ErrNoPreviousRevision if there is none.
+func (s *K8sService) RollbackDeployment(ctx context.Context, nameSpace, deploymentName string) error {
+	deployment, err := s.k8sClient.AppsV1().Deployments(nameSpace).Get(ctx, deploymentName, metaV1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	currentRevision, _ := strconv.ParseInt(deployment.Annotations[deploymentRevisionAnnotation], 10, 64)
+
+	rsList, err := s.k8sClient.AppsV1().ReplicaSets(nameSpace).List(ctx, metaV1.ListOptions{
+		LabelSelector: metaV1.FormatLabelSelector(deployment.Spec.Selector),
+	})
+	if err != nil {
+		return err
+	}
+
+	var previous *appV1.ReplicaSet
+	var previousRevision int64
+	for i, rs := range rsList.Items {
+		if !metaV1.IsControlledBy(&rsList.Items[i], deployment) {
+			continue
+		}
+		revision, _ := strconv.ParseInt(rs.Annotations[deploymentRevisionAnnotation], 10, 64)
+		if revision < currentRevision && revision > previousRevision {
+			previous = &rsList.Items[i]
+			previousRevision = revision
+		}
+	}
+	if previous == nil {
+		return ErrNoPreviousRevision
+	}
+
+	template := previous.Spec.Template.DeepCopy()
+	delete(template.Labels, appV1.DefaultDeploymentUniqueLabelKey)
+	deployment.Spec.Template = *template
+	_, err = s.k8sClient.AppsV1().Deployments(nameSpace).Update(ctx, deployment, metaV1.UpdateOptions{})
+	return err
+}
+
+// IsDeploymentRolledOut reports whether all replicas of the deployment run the
+// latest pod template and are available.
+func IsDeploymentRolledOut(deployment *appV1.Deployment) bool {
+	if deployment.Generation > deployment.Status.ObservedGeneration {
+		return false
+	}
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	return deployment.Status.UpdatedReplicas >= replicas &&
+		deployment.Status.Replicas == deployment.Status.UpdatedReplicas &&
+		deployment.Status.AvailableReplicas >= replicas
+}
+
+func (s *K8sService) ScaleDeployment(ctx context.Context, namespace, deploymentName string, replicas int32) error {
+	scale, err := s.k8sClient.AppsV1().Deployments(namespace).GetScale(ctx, deploymentName, metaV1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	scale.Spec.Replicas = replicas
+	_, err = s.k8sClient.AppsV1().Deployments(namespace).UpdateScale(ctx, deploymentName, scale, metaV1.UpdateOptions{})
+	return err
+}
+
 func (s *K8sService) DeleteDeployment(ctx context.Context, namespace, deploymentName string) error {
 	return s.k8sClient.AppsV1().Deployments(namespace).Delete(ctx, deploymentName, metaV1.DeleteOptions{})
 }
@@ -105,7 +207,7 @@ func (s *K8sService) DeleteDeployRs(ctx context.Context, namespace, spaceUuid st
 }
 
 func (s *K8sService) GetDeploymentStatus(namespace, spaceUuid string) (string, error) {
-	namespace = constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(namespace)
+	namespace = SpaceNamespace(namespace)
 	podList, err := s.k8sClient.CoreV1().Pods(namespace).List(context.TODO(), metaV1.ListOptions{
 		LabelSelector: fmt.Sprintf("lad_app=%s", spaceUuid),
 	})
@@ -120,6 +222,66 @@ func (s *K8sService) GetDeploymentStatus(namespace, spaceUuid string) (string, e
 	return "", nil
 }
 
+// GetPodFailure inspects the pods of a space and returns the reason why they
+// can not run, or nil if none of them is stuck in a known failure state.
+func (s *K8sService) GetPodFailure(namespace, spaceUuid string) *models.JobFailure {
+	podList, err := s.k8sClient.CoreV1().Pods(namespace).List(context.TODO(), metaV1.ListOptions{
+		LabelSelector: fmt.Sprintf("lad_app=%s", spaceUuid),
+	})
+	if err != nil {
+		logs.GetLogger().Error(err)
+		return nil
+	}
+
+	for _, pod := range podList.Items {
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == coreV1.PodScheduled && condition.Status == coreV1.ConditionFalse && condition.Reason == coreV1.PodReasonUnschedulable {
+				return models.NewJobFailure(models.FailureResourceUnavailable, "pod can not be scheduled: %s", condition.Message)
+			}
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.State.Waiting == nil {
+				continue
+			}
+			switch status.State.Waiting.Reason {
+			case "ErrImagePull", "ImagePullBackOff", "InvalidImageName":
+				return models.NewJobFailure(models.FailureImagePull, "image pull failed: %s", status.State.Waiting.Message)
+			case "CrashLoopBackOff":
+				return models.NewJobFailure(models.FailureK8sDeploy, "container %s keeps crashing: %s", status.Name, status.State.Waiting.Message)
+			case "CreateContainerConfigError":
+				return models.NewJobFailure(models.FailureK8sDeploy, "container %s config error: %s", status.Name, status.State.Waiting.Message)
+			}
+		}
+	}
+	return nil
+}
+
+// GetAdmissionFailure returns why the ResourceQuota or the LimitRange of the
+// namespace rejects the pods of the space or of its standalone dependencies,
+// or nil if their pods are created. A rejection counts once it lasted
+// admissionGracePeriod, the quota may be freed meanwhile by the old pods of a
+// rolling update.
+func (s *K8sService) GetAdmissionFailure(namespace, spaceUuid string) *models.JobFailure {
+	deployments, err := s.ListDeployments(context.TODO(), namespace)
+	if err != nil {
+		logs.GetLogger().Error(err)
+		return nil
+	}
+
+	for _, deployment := range deployments {
+		if deployment.Name != constants.K8S_DEPLOY_NAME_PREFIX+spaceUuid && deployment.Labels["lad_space"] != spaceUuid {
+			continue
+		}
+		for _, condition := range deployment.Status.Conditions {
+			if condition.Type == appV1.DeploymentReplicaFailure && condition.Status == coreV1.ConditionTrue && condition.Reason == "FailedCreate" &&
+				time.Since(condition.LastTransitionTime.Time) >= admissionGracePeriod {
+				return models.NewJobFailure(models.FailureAdmissionRejected, "pods of %s are rejected: %s", deployment.Name, condition.Message)
+			}
+		}
+	}
+	return nil
+}
+
 func (s *K8sService) GetDeploymentImages(ctx context.Context, namespace, deploymentName string) ([]string, error) {
 	deployment, err := s.k8sClient.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metaV1.GetOptions{})
 	if err != nil {
@@ -137,7 +299,7 @@ func (s *K8sService) GetServiceByName(ctx context.Context, namespace, serviceNam
 	return s.k8sClient.CoreV1().Services(namespace).Get(ctx, serviceName, opts)
 }
 
-func (s *K8sService) CreateService(ctx context.Context, nameSpace, spaceUuid string, containerPort int32) (result *coreV1.Service, err error) {
+func (s *K8sService) CreateService(ctx context.Context, nameSpace, spaceUuid string, ports []coreV1.ServicePort) (result *coreV1.Service, err error) {
 	service := &coreV1.Service{
 		TypeMeta: metaV1.TypeMeta{
 			Kind:       "Service",
@@ -148,18 +310,176 @@ func (s *K8sService) CreateService(ctx context.Context, nameSpace, spaceUuid str
 			Namespace: nameSpace,
 		},
 		Spec: coreV1.ServiceSpec{
-			Ports: []coreV1.ServicePort{
-				{
-					Name: "http",
-					Port: containerPort,
-				},
+			Ports: ports,
+			Selector: map[string]string{
+				"lad_app": spaceUuid,
 			},
+		},
+	}
+	result, err = s.k8sClient.CoreV1().Services(nameSpace).Create(ctx, service, metaV1.CreateOptions{})
+	if k8sErrors.IsAlreadyExists(err) {
+		return s.updateService(ctx, nameSpace, service)
+	}
+	return result, err
+}
+
+// CreateDependencyService creates the ClusterIP service of a standalone
+// dependency of the space, it selects the pods of the dependency only.
+func (s *K8sService) CreateDependencyService(ctx context.Context, nameSpace, spaceUuid, dependName string, ports []coreV1.ServicePort) (*coreV1.Service, error) {
+	labels := map[string]string{"lad_space": spaceUuid, "lad_service": dependName}
+	service := &coreV1.Service{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      constants.K8S_SERVICE_NAME_PREFIX + spaceUuid + "-" + dependName,
+			Namespace: nameSpace,
+			Labels:    labels,
+		},
+		Spec: coreV1.ServiceSpec{
+			Ports:    ports,
+			Selector: labels,
+		},
+	}
+	result, err := s.k8sClient.CoreV1().Services(nameSpace).Create(ctx, service, metaV1.CreateOptions{})
+	if k8sErrors.IsAlreadyExists(err) {
+		return s.updateService(ctx, nameSpace, service)
+	}
+	return result, err
+}
+
+// DeleteSpaceDependencies deletes the deployments and services of the
+// standalone dependencies of the space, except the ones in keep.
+func (s *K8sService) DeleteSpaceDependencies(ctx context.Context, nameSpace, spaceUuid string, keep map[string]bool) error {
+	listOptions := metaV1.ListOptions{LabelSelector: fmt.Sprintf("lad_space=%s", spaceUuid)}
+	deployments, err := s.k8sClient.AppsV1().Deployments(nameSpace).List(ctx, listOptions)
+	if err != nil {
+		return err
+	}
+	for _, deployment := range deployments.Items {
+		if keep[deployment.Labels["lad_service"]] {
+			continue
+		}
+		if err = s.DeleteDeployment(ctx, nameSpace, deployment.Name); err != nil && !k8sErrors.IsNotFound(err) {
+			return err
+		}
+	}
+	services, err := s.k8sClient.CoreV1().Services(nameSpace).List(ctx, listOptions)
+	if err != nil {
+		return err
+	}
+	for _, service := range services.Items {
+		if keep[service.Labels["lad_service"]] {
+			continue
+		}
+		if err = s.DeleteService(ctx, nameSpace, service.Name); err != nil && !k8sErrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// PauseSpaceDependencies scales the deployments of the standalone
+// dependencies of the space to zero, their replica count is kept in an
+// annotation for ResumeSpaceDependencies.
+func (s *K8sService) PauseSpaceDependencies(ctx context.Context, nameSpace, spaceUuid string) error {
+	return s.updateSpaceDependencies(ctx, nameSpace, spaceUuid, func(deployment *appV1.Deployment) bool {
+		if _, ok := deployment.Annotations[pausedReplicasAnnotation]; ok {
+			return false
+		}
+		replicas := int32(1)
+		if deployment.Spec.Replicas != nil {
+			replicas = *deployment.Spec.Replicas
+		}
+		if deployment.Annotations == nil {
+			deployment.Annotations = make(map[string]string)
+		}
+		deployment.Annotations[pausedReplicasAnnotation] = strconv.FormatInt(int64(replicas), 10)
+		zero := int32(0)
+		deployment.Spec.Replicas = &zero
+		return true
+	})
+}
+
+// ResumeSpaceDependencies scales the deployments of the standalone
+// dependencies of the space back to the replica count they had when it was paused.
+func (s *K8sService) ResumeSpaceDependencies(ctx context.Context, nameSpace, spaceUuid string) error {
+	return s.updateSpaceDependencies(ctx, nameSpace, spaceUuid, func(deployment *appV1.Deployment) bool {
+		value, ok := deployment.Annotations[pausedReplicasAnnotation]
+		if !ok {
+			return false
+		}
+		replicas, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || replicas < 1 {
+			replicas = 1
+		}
+		replicas32 := int32(replicas)
+		deployment.Spec.Replicas = &replicas32
+		delete(deployment.Annotations, pausedReplicasAnnotation)
+		return true
+	})
+}
+
+// updateSpaceDependencies updates the deployments of the standalone
+// dependencies of the space that change reports as changed.
+func (s *K8sService) updateSpaceDependencies(ctx context.Context, nameSpace, spaceUuid string, change func(*appV1.Deployment) bool) error {
+	deployments, err := s.k8sClient.AppsV1().Deployments(nameSpace).List(ctx, metaV1.ListOptions{
+		LabelSelector: fmt.Sprintf("lad_space=%s", spaceUuid),
+	})
+	if err != nil {
+		return err
+	}
+	for _, item := range deployments.Items {
+		name := item.Name
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			deployment, err := s.k8sClient.AppsV1().Deployments(nameSpace).Get(ctx, name, metaV1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if !change(deployment) {
+				return nil
+			}
+			_, err = s.k8sClient.AppsV1().Deployments(nameSpace).Update(ctx, deployment, metaV1.UpdateOptions{})
+			return err
+		})
+		if err != nil && !k8sErrors.IsNotFound(err) {
+			return fmt.Errorf("failed scale deployment %s, error: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// CreateSpaceNodePortService publishes the ports of the space on the nodes,
+// the ports without a NodePort get one from the API server.
+func (s *K8sService) CreateSpaceNodePortService(ctx context.Context, nameSpace, spaceUuid string, ports []coreV1.ServicePort) (*coreV1.Service, error) {
+	service := &coreV1.Service{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      constants.K8S_SERVICE_NAME_PREFIX + spaceUuid + constants.K8S_NODEPORT_SERVICE_SUFFIX,
+			Namespace: nameSpace,
+			Labels:    map[string]string{"lad_app": spaceUuid},
+		},
+		Spec: coreV1.ServiceSpec{
+			Type:  coreV1.ServiceTypeNodePort,
+			Ports: ports,
 			Selector: map[string]string{
 				"lad_app": spaceUuid,
 			},
 		},
 	}
-	return s.k8sClient.CoreV1().Services(nameSpace).Create(ctx, service, metaV1.CreateOptions{})
+	result, err := s.k8sClient.CoreV1().Services(nameSpace).Create(ctx, service, metaV1.CreateOptions{})
+	if k8sErrors.IsAlreadyExists(err) {
+		return s.updateService(ctx, nameSpace, service)
+	}
+	return result, err
+}
+
+// updateService updates the ports and selector of an existing service, the
+// cluster ip is kept.
+func (s *K8sService) updateService(ctx context.Context, nameSpace string, service *coreV1.Service) (*coreV1.Service, error) {
+	existing, err := s.k8sClient.CoreV1().Services(nameSpace).Get(ctx, service.Name, metaV1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	existing.Spec.Ports = service.Spec.Ports
+	existing.Spec.Selector = service.Spec.Selector
+	return s.k8sClient.CoreV1().Services(nameSpace).Update(ctx, existing, metaV1.UpdateOptions{})
 }
 
 func (s *K8sService) CreateServiceByNodePort(ctx context.Context, nameSpace, taskUuid string, containerPort int32) (result *coreV1.Service, err error) {
@@ -192,69 +512,162 @@ func (s *K8sService) DeleteService(ctx context.Context, namespace, serviceName s
 	return s.k8sClient.CoreV1().Services(namespace).Delete(ctx, serviceName, metaV1.DeleteOptions{})
 }
 
-func (s *K8sService) CreateIngress(ctx context.Context, k8sNameSpace, spaceUuid, hostName string, port int32) (*networkingv1.Ingress, error) {
-	var ingressClassName = "nginx"
-	ingress := &networkingv1.Ingress{
+// ApplyIngress creates the ingress or updates the annotations and the spec of
+// the existing one.
+func (s *K8sService) ApplyIngress(ctx context.Context, nameSpace string, ingress *networkingv1.Ingress) (*networkingv1.Ingress, error) {
+	result, err := s.k8sClient.NetworkingV1().Ingresses(nameSpace).Create(ctx, ingress, metaV1.CreateOptions{})
+	if k8sErrors.IsAlreadyExists(err) {
+		existing, err := s.k8sClient.NetworkingV1().Ingresses(nameSpace).Get(ctx, ingress.Name, metaV1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		existing.Annotations = ingress.Annotations
+		existing.Spec = ingress.Spec
+		return s.k8sClient.NetworkingV1().Ingresses(nameSpace).Update(ctx, existing, metaV1.UpdateOptions{})
+	}
+	return result, err
+}
+
+func (s *K8sService) DeleteIngress(ctx context.Context, nameSpace, ingressName string) error {
+	return s.k8sClient.NetworkingV1().Ingresses(nameSpace).Delete(ctx, ingressName, metaV1.DeleteOptions{})
+}
+
+// CreateSpaceConfigMap creates an immutable ConfigMap holding config files of
+// the space, files that are not valid utf-8 go to its BinaryData. Its name is
+// the hash of the content, an existing one is kept.
+func (s *K8sService) CreateSpaceConfigMap(ctx context.Context, nameSpace, spaceUuid, name string, data map[string]string, binaryData map[string][]byte) (*coreV1.ConfigMap, error) {
+	immutable := true
+	configMap := &coreV1.ConfigMap{
 		ObjectMeta: metaV1.ObjectMeta{
-			Name: constants.K8S_INGRESS_NAME_PREFIX + spaceUuid,
-			Annotations: map[string]string{
-				"nginx.ingress.kubernetes.io/use-regex": "true",
-			},
+			Name:      name,
+			Namespace: nameSpace,
+			Labels:    map[string]string{"lad_app": spaceUuid, "lad_config": spaceUuid},
 		},
-		Spec: networkingv1.IngressSpec{
-			IngressClassName: &ingressClassName,
-			Rules: []networkingv1.IngressRule{
-				{
-					Host: hostName,
-					IngressRuleValue: networkingv1.IngressRuleValue{
-						HTTP: &networkingv1.HTTPIngressRuleValue{
-							Paths: []networkingv1.HTTPIngressPath{
-								{
-									Path:     "/*",
-									PathType: func() *networkingv1.PathType { t := networkingv1.PathTypePrefix; return &t }(),
-									Backend: networkingv1.IngressBackend{
-										Service: &networkingv1.IngressServiceBackend{
-											Name: constants.K8S_SERVICE_NAME_PREFIX + spaceUuid,
-											Port: networkingv1.ServiceBackendPort{
-												Number: port,
-											},
-										},
-									},
-								},
-							},
-						},
-					},
+		Data:       data,
+		BinaryData: binaryData,
+		Immutable:  &immutable,
+	}
+	result, err := s.k8sClient.CoreV1().ConfigMaps(nameSpace).Create(ctx, configMap, metaV1.CreateOptions{})
+	if k8sErrors.IsAlreadyExists(err) {
+		// the name is the hash of the content, the existing one has the same data
+		return s.k8sClient.CoreV1().ConfigMaps(nameSpace).Get(ctx, name, metaV1.GetOptions{})
+	}
+	return result, err
+}
+
+// DeleteConfigMaps deletes the config file ConfigMaps of the space, except the
+// ones named in keep.
+func (s *K8sService) DeleteConfigMaps(ctx context.Context, nameSpace, spaceUuid string, keep map[string]bool) error {
+	configMaps, err := s.k8sClient.CoreV1().ConfigMaps(nameSpace).List(ctx, metaV1.ListOptions{LabelSelector: fmt.Sprintf("lad_config=%s", spaceUuid)})
+	if err != nil {
+		return err
+	}
+	for _, configMap := range configMaps.Items {
+		if keep[configMap.Name] {
+			continue
+		}
+		if err = s.k8sClient.CoreV1().ConfigMaps(nameSpace).Delete(ctx, configMap.Name, metaV1.DeleteOptions{}); err != nil && !k8sErrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreatePersistentVolumeClaim creates the claim of a persistent mount of the
+// space. An existing claim is kept, so the data survives redeploys.
+func (s *K8sService) CreatePersistentVolumeClaim(ctx context.Context, nameSpace, spaceUuid, claimName string, size resource.Quantity, accessMode coreV1.PersistentVolumeAccessMode, storageClass string) (*coreV1.PersistentVolumeClaim, error) {
+	claim, err := s.k8sClient.CoreV1().PersistentVolumeClaims(nameSpace).Get(ctx, claimName, metaV1.GetOptions{})
+	if err == nil || !k8sErrors.IsNotFound(err) {
+		return claim, err
+	}
+
+	claim = &coreV1.PersistentVolumeClaim{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:   claimName,
+			Labels: map[string]string{"lad_app": spaceUuid},
+		},
+		Spec: coreV1.PersistentVolumeClaimSpec{
+			AccessModes: []coreV1.PersistentVolumeAccessMode{accessMode},
+			Resources: coreV1.VolumeResourceRequirements{
+				Requests: coreV1.ResourceList{
+					coreV1.ResourceStorage: size,
 				},
 			},
 		},
 	}
-
-	return s.k8sClient.NetworkingV1().Ingresses(k8sNameSpace).Create(ctx, ingress, metaV1.CreateOptions{})
+	if storageClass != "" {
+		claim.Spec.StorageClassName = &storageClass
+	}
+	return s.k8sClient.CoreV1().PersistentVolumeClaims(nameSpace).Create(ctx, claim, metaV1.CreateOptions{})
 }
 
-func (s *K8sService) DeleteIngress(ctx context.Context, nameSpace, ingressName string) error {
-	return s.k8sClient.NetworkingV1().Ingresses(nameSpace).Delete(ctx, ingressName, metaV1.DeleteOptions{})
+// ListSpaceClaims returns the persistent volume claims of the spaces in the
+// namespace, or in every namespace if it is empty, whether a pod mounts them or
+// not.
+func (s *K8sService) ListSpaceClaims(ctx context.Context, nameSpace string) ([]coreV1.PersistentVolumeClaim, error) {
+	if sharedClusterCache.ready() {
+		return sharedClusterCache.listClaims(nameSpace)
+	}
+	claims, err := s.k8sClient.CoreV1().PersistentVolumeClaims(nameSpace).List(ctx, metaV1.ListOptions{LabelSelector: "lad_app"})
+	if err != nil {
+		return nil, err
+	}
+	return claims.Items, nil
 }
 
-func (s *K8sService) CreateConfigMap(ctx context.Context, k8sNameSpace, spaceUuid, basePath, configName string) (*coreV1.ConfigMap, error) {
-	configFilePath := filepath.Join(basePath, configName)
-
-	fileNameWithoutExt := filepath.Base(configName[:len(configName)-len(filepath.Ext(configName))])
-
-	iniData, err := os.ReadFile(configFilePath)
+// GetStorageClass returns the storage class, or the default one if name is
+// empty. It is nil if the cluster has no default storage class.
+func (s *K8sService) GetStorageClass(ctx context.Context, name string) (*storageV1.StorageClass, error) {
+	if name != "" {
+		return s.k8sClient.StorageV1().StorageClasses().Get(ctx, name, metaV1.GetOptions{})
+	}
+	classes, err := s.k8sClient.StorageV1().StorageClasses().List(ctx, metaV1.ListOptions{})
 	if err != nil {
 		return nil, err
 	}
+	for i := range classes.Items {
+		if classes.Items[i].Annotations[defaultStorageClassAnnotation] == "true" {
+			return &classes.Items[i], nil
+		}
+	}
+	return nil, nil
+}
 
-	configMap := &coreV1.ConfigMap{
+func (s *K8sService) DeletePersistentVolumeClaims(ctx context.Context, nameSpace, spaceUuid string) error {
+	return s.k8sClient.CoreV1().PersistentVolumeClaims(nameSpace).DeleteCollection(ctx, metaV1.DeleteOptions{}, metaV1.ListOptions{
+		LabelSelector: fmt.Sprintf("lad_app=%s", spaceUuid),
+	})
+}
+
+// CreateSecret creates or replaces an Opaque secret of the space.
+func (s *K8sService) CreateSecret(ctx context.Context, nameSpace, spaceUuid, secretName string, data map[string]string) (*coreV1.Secret, error) {
+	secret := &coreV1.Secret{
 		ObjectMeta: metaV1.ObjectMeta{
-			Name: spaceUuid + "-" + fileNameWithoutExt,
-		},
-		Data: map[string]string{
-			configName: string(iniData),
+			Name:   secretName,
+			Labels: map[string]string{"lad_app": spaceUuid},
 		},
+		Type:       coreV1.SecretTypeOpaque,
+		StringData: data,
+	}
+
+	result, err := s.k8sClient.CoreV1().Secrets(nameSpace).Create(ctx, secret, metaV1.CreateOptions{})
+	if k8sErrors.IsAlreadyExists(err) {
+		existing, err := s.k8sClient.CoreV1().Secrets(nameSpace).Get(ctx, secretName, metaV1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		existing.Labels = secret.Labels
+		existing.Data = nil
+		existing.StringData = data
+		return s.k8sClient.CoreV1().Secrets(nameSpace).Update(ctx, existing, metaV1.UpdateOptions{})
 	}
-	return s.k8sClient.CoreV1().ConfigMaps(k8sNameSpace).Create(ctx, configMap, metaV1.CreateOptions{})
+	return result, err
+}
+
+func (s *K8sService) DeleteSecrets(ctx context.Context, nameSpace, spaceUuid string) error {
+	return s.k8sClient.CoreV1().Secrets(nameSpace).DeleteCollection(ctx, metaV1.DeleteOptions{}, metaV1.ListOptions{
+		LabelSelector: fmt.Sprintf("lad_app=%s", spaceUuid),
+	})
 }
 
 func (s *K8sService) GetPods(namespace, spaceUuid string) (bool, error) {
@@ -275,31 +688,65 @@ func (s *K8sService) GetPods(namespace, spaceUuid string) (bool, error) {
 	return false, nil
 }
 
-func (s *K8sService) CreateNetworkPolicy(ctx context.Context, namespace string) (*networkingv1.NetworkPolicy, error) {
-	networkPolicy := &networkingv1.NetworkPolicy{
-		ObjectMeta: metaV1.ObjectMeta{
-			Name:      namespace + "-" + generateString(4),
-			Namespace: namespace,
-		},
-		Spec: networkingv1.NetworkPolicySpec{
-			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
-			Ingress: []networkingv1.NetworkPolicyIngressRule{
-				{
-					From: []networkingv1.NetworkPolicyPeer{
-						{
-							NamespaceSelector: &metaV1.LabelSelector{
-								MatchLabels: map[string]string{
-									"kubernetes.io/metadata.name": "ingress-nginx",
-								},
-							},
-						},
-					},
-				},
-			},
-		},
+// ApplyNetworkPolicy creates the NetworkPolicy, or updates its spec if it exists.
+func (s *K8sService) ApplyNetworkPolicy(ctx context.Context, nameSpace string, networkPolicy *networkingv1.NetworkPolicy) (*networkingv1.NetworkPolicy, error) {
+	result, err := s.k8sClient.NetworkingV1().NetworkPolicies(nameSpace).Create(ctx, networkPolicy, metaV1.CreateOptions{})
+	if k8sErrors.IsAlreadyExists(err) {
+		existing, err := s.k8sClient.NetworkingV1().NetworkPolicies(nameSpace).Get(ctx, networkPolicy.Name, metaV1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		existing.Labels = networkPolicy.Labels
+		existing.Spec = networkPolicy.Spec
+		return s.k8sClient.NetworkingV1().NetworkPolicies(nameSpace).Update(ctx, existing, metaV1.UpdateOptions{})
 	}
+	return result, err
+}
 
-	return s.k8sClient.NetworkingV1().NetworkPolicies(namespace).Create(ctx, networkPolicy, metaV1.CreateOptions{})
+func (s *K8sService) DeleteNetworkPolicy(ctx context.Context, nameSpace, name string) error {
+	return s.k8sClient.NetworkingV1().NetworkPolicies(nameSpace).Delete(ctx, name, metaV1.DeleteOptions{})
+}
+
+// ApplyResourceQuota creates the ResourceQuota, or updates its spec if it exists.
+func (s *K8sService) ApplyResourceQuota(ctx context.Context, nameSpace string, quota *coreV1.ResourceQuota) (*coreV1.ResourceQuota, error) {
+	result, err := s.k8sClient.CoreV1().ResourceQuotas(nameSpace).Create(ctx, quota, metaV1.CreateOptions{})
+	if k8sErrors.IsAlreadyExists(err) {
+		existing, err := s.k8sClient.CoreV1().ResourceQuotas(nameSpace).Get(ctx, quota.Name, metaV1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		existing.Labels = quota.Labels
+		existing.Spec = quota.Spec
+		return s.k8sClient.CoreV1().ResourceQuotas(nameSpace).Update(ctx, existing, metaV1.UpdateOptions{})
+	}
+	return result, err
+}
+
+func (s *K8sService) GetResourceQuota(ctx context.Context, nameSpace, name string) (*coreV1.ResourceQuota, error) {
+	return s.k8sClient.CoreV1().ResourceQuotas(nameSpace).Get(ctx, name, metaV1.GetOptions{})
+}
+
+func (s *K8sService) DeleteResourceQuota(ctx context.Context, nameSpace, name string) error {
+	return s.k8sClient.CoreV1().ResourceQuotas(nameSpace).Delete(ctx, name, metaV1.DeleteOptions{})
+}
+
+// ApplyLimitRange creates the LimitRange, or updates its spec if it exists.
+func (s *K8sService) ApplyLimitRange(ctx context.Context, nameSpace string, limitRange *coreV1.LimitRange) (*coreV1.LimitRange, error) {
+	result, err := s.k8sClient.CoreV1().LimitRanges(nameSpace).Create(ctx, limitRange, metaV1.CreateOptions{})
+	if k8sErrors.IsAlreadyExists(err) {
+		existing, err := s.k8sClient.CoreV1().LimitRanges(nameSpace).Get(ctx, limitRange.Name, metaV1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		existing.Labels = limitRange.Labels
+		existing.Spec = limitRange.Spec
+		return s.k8sClient.CoreV1().LimitRanges(nameSpace).Update(ctx, existing, metaV1.UpdateOptions{})
+	}
+	return result, err
+}
+
+func (s *K8sService) DeleteLimitRange(ctx context.Context, nameSpace, name string) error {
+	return s.k8sClient.CoreV1().LimitRanges(nameSpace).Delete(ctx, name, metaV1.DeleteOptions{})
 }
 
 func (s *K8sService) CreateNameSpace(ctx context.Context, nameSpace *coreV1.Namespace, opts metaV1.CreateOptions) (result *coreV1.Namespace, err error) {
@@ -330,6 +777,9 @@ func (s *K8sService) ListUsedImage(ctx context.Context, nameSpace string) ([]str
 }
 
 func (s *K8sService) ListNamespace(ctx context.Context) ([]string, error) {
+	if sharedClusterCache.ready() {
+		return sharedClusterCache.listNamespaces()
+	}
 	list, err := s.k8sClient.CoreV1().Namespaces().List(ctx, metaV1.ListOptions{})
 	if err != nil {
 		return nil, err
@@ -347,9 +797,13 @@ func (s *K8sService) StatisticalSources(ctx context.Context) ([]*models.NodeReso
 	if err != nil {
 		return nil, err
 	}
+	claims, err := s.ListSpaceClaims(ctx, "")
+	if err != nil {
+		return nil, err
+	}
 	var nodeList []*models.NodeResource
 
-	nodes, err := s.k8sClient.CoreV1().Nodes().List(ctx, metaV1.ListOptions{})
+	nodes, err := s.ListNodes(ctx)
 	if err != nil {
 		logs.GetLogger().Error(err)
 		return nil, err
@@ -360,8 +814,8 @@ func (s *K8sService) StatisticalSources(ctx context.Context) ([]*models.NodeReso
 		logs.GetLogger().Errorf("Collect cluster gpu info Failed, if have available gpu, please check resource-exporter. error: %+v", err)
 	}
 
-	for _, node := range nodes.Items {
-		nodeGpu, _, nodeResource := GetNodeResource(activePods, &node)
+	for _, node := range nodes {
+		nodeGpu, _, nodeResource := GetNodeResource(activePods, claims, &node)
 		if nodeGpuInfoMap != nil {
 			collectGpu := make(map[string]collectGpuInfo)
 			if gpu, ok := nodeGpuInfoMap[node.Name]; ok {
@@ -417,25 +871,41 @@ func (s *K8sService) StatisticalSources(ctx context.Context) ([]*models.NodeReso
 	return nodeList, nil
 }
 
+// GetResourceExporterPodLog returns the hardware info of the nodes that the
+// resource-exporter pods log. With the cluster cache the logs are read at most
+// once per resourceExporterTTL.
 func (s *K8sService) GetResourceExporterPodLog(ctx context.Context) (map[string]models.CollectNodeInfo, error) {
-	var num int64 = 1
-	podLogOptions := coreV1.PodLogOptions{
-		Container:  "",
-		TailLines:  &num,
-		Timestamps: false,
+	if sharedClusterCache.ready() {
+		return sharedClusterCache.resourceExporterInfo(func() (map[string]models.CollectNodeInfo, error) {
+			pods, err := sharedClusterCache.listPods(systemNamespace(), labels.SelectorFromSet(labels.Set{"app": "resource-exporter"}))
+			if err != nil {
+				return nil, err
+			}
+			return s.readResourceExporterLogs(pods), nil
+		})
 	}
 
-	podList, err := s.k8sClient.CoreV1().Pods("kube-system").List(ctx, metaV1.ListOptions{
+	podList, err := s.k8sClient.CoreV1().Pods(systemNamespace()).List(ctx, metaV1.ListOptions{
 		LabelSelector: "app=resource-exporter",
 	})
 	if err != nil {
 		logs.GetLogger().Error(err)
 		return nil, err
 	}
+	return s.readResourceExporterLogs(podList.Items), nil
+}
+
+func (s *K8sService) readResourceExporterLogs(pods []coreV1.Pod) map[string]models.CollectNodeInfo {
+	var num int64 = 1
+	podLogOptions := coreV1.PodLogOptions{
+		Container:  "",
+		TailLines:  &num,
+		Timestamps: false,
+	}
 
 	result := make(map[string]models.CollectNodeInfo)
-	for _, pod := range podList.Items {
-		podLog, err := s.GetPodLogByPodName("kube-system", pod.Name, &podLogOptions)
+	for _, pod := range pods {
+		podLog, err := s.GetPodLogByPodName(systemNamespace(), pod.Name, &podLogOptions)
 		if err != nil {
 			logs.GetLogger().Errorf("collect gpu deatil info, nodeName: %s, error: %+v", pod.Spec.NodeName, err)
 			continue
@@ -447,12 +917,12 @@ func (s *K8sService) GetResourceExporterPodLog(ctx context.Context) (map[string]
 
 		var nodeInfo models.CollectNodeInfo
 		if err := json.Unmarshal([]byte(podLog), &nodeInfo); err != nil {
-			logs.GetLogger().Error("nodeName: %s, collect gpu error: %+v", pod.Spec.NodeName, err)
+			logs.GetLogger().Errorf("nodeName: %s, collect gpu error: %+v", pod.Spec.NodeName, err)
 			continue
 		}
 		result[pod.Spec.NodeName] = nodeInfo
 	}
-	return result, nil
+	return result
 }
 
 func (s *K8sService) GetPodLogByPodName(namespace, podName string, podLogOptions *coreV1.PodLogOptions) (string, error) {
@@ -602,7 +1072,12 @@ func (s *K8sService) GetNodeGpuSummary(ctx context.Context) (map[string]map[stri
 	return nodeGpuSummary, nil
 }
 
+// GetAllActivePod returns the running pods of the cluster. From the cluster
+// cache they may lag a moment behind the changes the cp just made.
 func (s *K8sService) GetAllActivePod(ctx context.Context) ([]coreV1.Pod, error) {
+	if sharedClusterCache.ready() {
+		return sharedClusterCache.listPods(metaV1.NamespaceAll, labels.Everything())
+	}
 	allPods, err := clientSet.CoreV1().Pods("").List(ctx, metaV1.ListOptions{
 		FieldSelector: "status.phase=Running",
 	})
@@ -612,6 +1087,36 @@ func (s *K8sService) GetAllActivePod(ctx context.Context) ([]coreV1.Pod, error)
 	return allPods.Items, nil
 }
 
+// ListNodes returns the nodes of the cluster.
+func (s *K8sService) ListNodes(ctx context.Context) ([]coreV1.Node, error) {
+	if sharedClusterCache.ready() {
+		return sharedClusterCache.listNodes()
+	}
+	nodes, err := s.k8sClient.CoreV1().Nodes().List(ctx, metaV1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return nodes.Items, nil
+}
+
+// ListDeployments returns the deployments of the namespace.
+func (s *K8sService) ListDeployments(ctx context.Context, namespace string) ([]appV1.Deployment, error) {
+	if sharedClusterCache.ready() {
+		return sharedClusterCache.listDeployments(namespace)
+	}
+	deployments, err := s.k8sClient.AppsV1().Deployments(namespace).List(ctx, metaV1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return deployments.Items, nil
+}
+
+// ClusterCacheStatus reports whether the cluster cache is synced and when its
+// informers last saw a change.
+func (s *K8sService) ClusterCacheStatus() ClusterCacheStatus {
+	return sharedClusterCache.status()
+}
+
 func (s *K8sService) GetAPIServerEndpoint() string {
 	last := strings.LastIndex(s.config.Host, ":")
 	return s.config.Host[:last]
@@ -626,14 +1131,14 @@ func (s *K8sService) GetDeploymentActiveCount() (int, error) {
 
 	var total int
 	for _, namespace := range namespaces {
-		if strings.HasPrefix(namespace, constants.K8S_NAMESPACE_NAME_PREFIX) {
-			deployments, err := s.k8sClient.AppsV1().Deployments(namespace).List(context.TODO(), metaV1.ListOptions{})
+		if isSpaceNamespace(namespace) {
+			deployments, err := s.ListDeployments(context.TODO(), namespace)
 			if err != nil {
 				logs.GetLogger().Errorf("Error getting deployments in namespace %s: %v\n", namespace, err)
 				continue
 			}
 
-			for _, deployment := range deployments.Items {
+			for _, deployment := range deployments {
 				creationTimestamp := deployment.ObjectMeta.CreationTimestamp.Time
 				currentTime := time.Now()
 				age := currentTime.Sub(creationTimestamp)
@@ -0,0 +1,190 @@
+package computing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/swanchain/go-computing-provider/conf"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	resourceQuotaName  = "lad-quota"
+	limitRangeName     = "lad-limits"
+	defaultQuotaTier   = "default"
+	resourceGpuRequest = "requests.nvidia.com/gpu"
+	// admissionGracePeriod is how long the pods of a space may be rejected by
+	// the quota before the deploy fails.
+	admissionGracePeriod = 2 * time.Minute
+)
+
+// walletQuotaTier returns the name and the resources of the quota tier of the
+// wallet, from Quota.Wallets or else Quota.DefaultTier.
+func walletQuotaTier(walletAddress string) (string, conf.QuotaTier, error) {
+	quota := conf.GetConfig().Quota
+	tierName := quota.DefaultTier
+	for wallet, name := range quota.Wallets {
+		if strings.EqualFold(wallet, walletAddress) {
+			tierName = name
+			break
+		}
+	}
+	if tierName == "" {
+		tierName = defaultQuotaTier
+	}
+	tier, ok := quota.Tiers[tierName]
+	if !ok {
+		return "", conf.QuotaTier{}, fmt.Errorf("unknown quota tier %q of wallet %s", tierName, walletAddress)
+	}
+	return tierName, tier, nil
+}
+
+// ensureNamespaceQuota applies the ResourceQuota and the LimitRange of the
+// quota tier of the wallet to its namespace. The quota counts the requests of
+// the pods, the LimitRange gives a request and a limit to the containers
+// without one, e.g. the dependencies without a compute profile. They are
+// deleted when Quota.Enable is off.
+func ensureNamespaceQuota(k8sService *K8sService, namespace, walletAddress string) error {
+	if !conf.GetConfig().Quota.Enable {
+		if err := k8sService.DeleteResourceQuota(context.TODO(), namespace, resourceQuotaName); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		if err := k8sService.DeleteLimitRange(context.TODO(), namespace, limitRangeName); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		return nil
+	}
+
+	tierName, tier, err := walletQuotaTier(walletAddress)
+	if err != nil {
+		return err
+	}
+	labels := map[string]string{"lad_quota_tier": tierName}
+
+	hard, err := quotaResourceList(map[coreV1.ResourceName]string{
+		coreV1.ResourceRequestsCPU:              tier.Cpu,
+		coreV1.ResourceRequestsMemory:           tier.Memory,
+		coreV1.ResourceRequestsEphemeralStorage: tier.EphemeralStorage,
+		resourceGpuRequest:                      tier.Gpu,
+		coreV1.ResourcePods:                     tier.Pods,
+	})
+	if err != nil {
+		return fmt.Errorf("invalid quota tier %s, error: %w", tierName, err)
+	}
+	quota := &coreV1.ResourceQuota{
+		ObjectMeta: metaV1.ObjectMeta{Name: resourceQuotaName, Labels: labels},
+		Spec:       coreV1.ResourceQuotaSpec{Hard: hard},
+	}
+	if _, err = k8sService.ApplyResourceQuota(context.TODO(), namespace, quota); err != nil {
+		return fmt.Errorf("failed apply resource quota, error: %w", err)
+	}
+
+	defaultLimit, err := quotaResourceList(map[coreV1.ResourceName]string{
+		coreV1.ResourceCPU:              tier.DefaultCpu,
+		coreV1.ResourceMemory:           tier.DefaultMemory,
+		coreV1.ResourceEphemeralStorage: tier.DefaultEphemeralStorage,
+	})
+	if err != nil {
+		return fmt.Errorf("invalid quota tier %s, error: %w", tierName, err)
+	}
+	defaultRequest, err := quotaResourceList(map[coreV1.ResourceName]string{
+		coreV1.ResourceCPU:              tier.DefaultRequestCpu,
+		coreV1.ResourceMemory:           tier.DefaultRequestMemory,
+		coreV1.ResourceEphemeralStorage: tier.DefaultRequestEphemeralStorage,
+	})
+	if err != nil {
+		return fmt.Errorf("invalid quota tier %s, error: %w", tierName, err)
+	}
+	limitRange := &coreV1.LimitRange{
+		ObjectMeta: metaV1.ObjectMeta{Name: limitRangeName, Labels: labels},
+		Spec: coreV1.LimitRangeSpec{
+			Limits: []coreV1.LimitRangeItem{
+				{
+					Type:           coreV1.LimitTypeContainer,
+					Default:        defaultLimit,
+					DefaultRequest: defaultRequest,
+				},
+			},
+		},
+	}
+	if _, err = k8sService.ApplyLimitRange(context.TODO(), namespace, limitRange); err != nil {
+		return fmt.Errorf("failed apply limit range, error: %w", err)
+	}
+	return nil
+}
+
+// quotaResourceList parses the quantities of a quota tier, empty ones are left out.
+func quotaResourceList(values map[coreV1.ResourceName]string) (coreV1.ResourceList, error) {
+	list := make(coreV1.ResourceList)
+	for name, value := range values {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s %q: %w", name, value, err)
+		}
+		list[name] = quantity
+	}
+	return list, nil
+}
+
+// quotaAllowsSurge tells whether the ResourceQuota of the namespace has room
+// for one more pod of the template, the extra pod of a rolling update.
+func quotaAllowsSurge(k8sService *K8sService, namespace string, podSpec coreV1.PodSpec) bool {
+	if !conf.GetConfig().Quota.Enable {
+		return true
+	}
+	quota, err := k8sService.GetResourceQuota(context.TODO(), namespace, resourceQuotaName)
+	if err != nil {
+		return errors.IsNotFound(err)
+	}
+
+	requests := podRequests(podSpec)
+	for name, hard := range quota.Spec.Hard {
+		var need resource.Quantity
+		switch {
+		case name == coreV1.ResourcePods:
+			need = *resource.NewQuantity(1, resource.DecimalSI)
+		case strings.HasPrefix(string(name), "requests."):
+			need = requests[coreV1.ResourceName(strings.TrimPrefix(string(name), "requests."))]
+		default:
+			continue
+		}
+		left := hard.DeepCopy()
+		if used, ok := quota.Status.Used[name]; ok {
+			left.Sub(used)
+		}
+		if need.Cmp(left) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// podRequests returns the requests the quota counts for a pod: those of its
+// containers and sidecars, or of its largest init container if that is more.
+func podRequests(podSpec coreV1.PodSpec) coreV1.ResourceList {
+	requests := make(coreV1.ResourceList)
+	for _, container := range podSpec.Containers {
+		addResourceList(requests, container.Resources.Requests)
+	}
+	for _, container := range podSpec.InitContainers {
+		if container.RestartPolicy != nil && *container.RestartPolicy == coreV1.ContainerRestartPolicyAlways {
+			addResourceList(requests, container.Resources.Requests)
+		}
+	}
+	for _, container := range podSpec.InitContainers {
+		for name, quantity := range container.Resources.Requests {
+			if current := requests[name]; quantity.Cmp(current) > 0 {
+				requests[name] = quantity
+			}
+		}
+	}
+	return requests
+}
@@ -0,0 +1,266 @@
+package computing
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/swanchain/go-computing-provider/conf"
+	"github.com/swanchain/go-computing-provider/constants"
+	coreV1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// The egress the pods of the isolated namespaces are allowed, NetworkPolicy.Egress.
+const (
+	NetworkEgressPublic = "public"
+	NetworkEgressAll    = "all"
+	NetworkEgressNone   = "none"
+)
+
+const (
+	defaultDenyPolicyName   = "lad-default-deny"
+	tenantPolicyName        = "lad-tenant"
+	defaultIngressNamespace = "ingress-nginx"
+	// metadataCIDR is the metadata service of the cloud providers, it hands out
+	// the credentials of the nodes.
+	metadataCIDR = "169.254.169.254/32"
+)
+
+// isTenantNamespace tells whether the namespace runs the workloads of a
+// wallet or a ubi task, they are isolated from each other and from the cluster.
+func isTenantNamespace(namespace string) bool {
+	return isSpaceNamespace(namespace) || strings.HasPrefix(namespace, constants.K8S_UBI_NAMESPACE_PREFIX)
+}
+
+// ensureNetworkPolicies applies the NetworkPolicies of a tenant namespace: a
+// default deny, and a policy allowing the traffic inside the namespace, from
+// the ingress controller, to the DNS and the egress of NetworkPolicy.Egress.
+// They are deleted when NetworkPolicy.Enable is off.
+func ensureNetworkPolicies(k8sService *K8sService, namespace string) error {
+	if !conf.GetConfig().NetworkPolicy.Enable {
+		for _, name := range []string{defaultDenyPolicyName, tenantPolicyName} {
+			if err := k8sService.DeleteNetworkPolicy(context.TODO(), namespace, name); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tenantPolicy, err := tenantNetworkPolicy(k8sService)
+	if err != nil {
+		return err
+	}
+	policies := []*networkingv1.NetworkPolicy{
+		{
+			ObjectMeta: metaV1.ObjectMeta{Name: defaultDenyPolicyName},
+			Spec: networkingv1.NetworkPolicySpec{
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			},
+		},
+		tenantPolicy,
+	}
+	for _, policy := range policies {
+		if _, err = k8sService.ApplyNetworkPolicy(context.TODO(), namespace, policy); err != nil {
+			return fmt.Errorf("failed apply network policy %s, error: %w", policy.Name, err)
+		}
+	}
+	return nil
+}
+
+func tenantNetworkPolicy(k8sService *K8sService) (*networkingv1.NetworkPolicy, error) {
+	config := conf.GetConfig().NetworkPolicy
+	ingressNamespaces := config.IngressNamespaces
+	if len(ingressNamespaces) == 0 {
+		ingressNamespaces = []string{defaultIngressNamespace}
+	}
+	sameNamespace := networkingv1.NetworkPolicyPeer{PodSelector: &metaV1.LabelSelector{}}
+	udp, tcp := coreV1.ProtocolUDP, coreV1.ProtocolTCP
+	dnsPort := intstr.FromInt32(53)
+
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metaV1.ObjectMeta{Name: tenantPolicyName},
+		Spec: networkingv1.NetworkPolicySpec{
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					From: []networkingv1.NetworkPolicyPeer{
+						sameNamespace,
+						{
+							NamespaceSelector: &metaV1.LabelSelector{
+								MatchExpressions: []metaV1.LabelSelectorRequirement{
+									{
+										Key:      "kubernetes.io/metadata.name",
+										Operator: metaV1.LabelSelectorOpIn,
+										Values:   ingressNamespaces,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			Egress: []networkingv1.NetworkPolicyEgressRule{
+				{To: []networkingv1.NetworkPolicyPeer{sameNamespace}},
+				{
+					To: []networkingv1.NetworkPolicyPeer{{NamespaceSelector: &metaV1.LabelSelector{}}},
+					Ports: []networkingv1.NetworkPolicyPort{
+						{Protocol: &udp, Port: &dnsPort},
+						{Protocol: &tcp, Port: &dnsPort},
+					},
+				},
+			},
+		},
+	}
+
+	// the ubi tasks post their results to the cp, it is reached at the host of the API server
+	if cpPeer, ok := cpNetworkPeer(k8sService); ok {
+		cpPort := intstr.FromInt(conf.GetConfig().API.Port)
+		policy.Spec.Egress = append(policy.Spec.Egress, networkingv1.NetworkPolicyEgressRule{
+			To:    []networkingv1.NetworkPolicyPeer{cpPeer},
+			Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &cpPort}},
+		})
+	}
+
+	for _, cidr := range config.AllowedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("invalid NetworkPolicy.AllowedCIDRs %q, error: %w", cidr, err)
+		}
+		policy.Spec.Egress = append(policy.Spec.Egress, networkingv1.NetworkPolicyEgressRule{
+			To: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: cidr}}},
+		})
+	}
+
+	switch config.Egress {
+	case "", NetworkEgressPublic:
+		var exceptV4, exceptV6 []string
+		for _, cidr := range append([]string{metadataCIDR}, config.ClusterCIDRs...) {
+			ip, _, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid NetworkPolicy.ClusterCIDRs %q, error: %w", cidr, err)
+			}
+			if ip.To4() != nil {
+				exceptV4 = append(exceptV4, cidr)
+			} else {
+				exceptV6 = append(exceptV6, cidr)
+			}
+		}
+		policy.Spec.Egress = append(policy.Spec.Egress, networkingv1.NetworkPolicyEgressRule{
+			To: []networkingv1.NetworkPolicyPeer{
+				{IPBlock: &networkingv1.IPBlock{CIDR: "0.0.0.0/0", Except: exceptV4}},
+				{IPBlock: &networkingv1.IPBlock{CIDR: "::/0", Except: exceptV6}},
+			},
+		})
+	case NetworkEgressAll:
+		policy.Spec.Egress = append(policy.Spec.Egress, networkingv1.NetworkPolicyEgressRule{})
+	case NetworkEgressNone:
+	default:
+		return nil, fmt.Errorf("unknown NetworkPolicy.Egress %q, expected public, all or none", config.Egress)
+	}
+	return policy, nil
+}
+
+// cpNetworkPeer returns the host of the cp api as a network peer, if it is an ip.
+func cpNetworkPeer(k8sService *K8sService) (networkingv1.NetworkPolicyPeer, bool) {
+	if k8sService.config == nil {
+		return networkingv1.NetworkPolicyPeer{}, false
+	}
+	apiServer, err := url.Parse(k8sService.config.Host)
+	if err != nil {
+		return networkingv1.NetworkPolicyPeer{}, false
+	}
+	ip := net.ParseIP(apiServer.Hostname())
+	if ip == nil {
+		return networkingv1.NetworkPolicyPeer{}, false
+	}
+	cidr := ip.String() + "/32"
+	if ip.To4() == nil {
+		cidr = ip.String() + "/128"
+	}
+	return networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}}, true
+}
+
+// applyNodePortPolicy lets the node ports of the space be reached from
+// anywhere, the default deny of its namespace would drop them otherwise.
+func applyNodePortPolicy(k8sService *K8sService, namespace, spaceUuid string, ports []coreV1.ServicePort) error {
+	name := constants.K8S_SERVICE_NAME_PREFIX + spaceUuid + constants.K8S_NODEPORT_SERVICE_SUFFIX
+	if !conf.GetConfig().NetworkPolicy.Enable || len(ports) == 0 {
+		if err := k8sService.DeleteNetworkPolicy(context.TODO(), namespace, name); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		return nil
+	}
+
+	var policyPorts []networkingv1.NetworkPolicyPort
+	for _, port := range ports {
+		protocol, targetPort := port.Protocol, port.TargetPort
+		policyPorts = append(policyPorts, networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &targetPort})
+	}
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"lad_app": spaceUuid},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metaV1.LabelSelector{MatchLabels: map[string]string{"lad_app": spaceUuid}},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					From:  []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "0.0.0.0/0"}}},
+					Ports: policyPorts,
+				},
+			},
+		},
+	}
+	_, err := k8sService.ApplyNetworkPolicy(context.TODO(), namespace, policy)
+	return err
+}
+
+// ReconcileNetworkPolicies applies the NetworkPolicies of the tenant
+// namespaces that exist, and of the node ports of their spaces.
+func ReconcileNetworkPolicies() {
+	k8sService := NewK8sService()
+	if k8sService.k8sClient == nil {
+		return
+	}
+	namespaces, err := k8sService.ListNamespace(context.TODO())
+	if err != nil {
+		logs.GetLogger().Errorf("Failed reconcile network policies, error: %+v", err)
+		return
+	}
+
+	var reconciled int
+	for _, namespace := range namespaces {
+		if !isTenantNamespace(namespace) {
+			continue
+		}
+		if err = ensureNetworkPolicies(k8sService, namespace); err != nil {
+			logs.GetLogger().Errorf("Failed reconcile network policies, namespace: %s, error: %+v", namespace, err)
+			continue
+		}
+		if isSpaceNamespace(namespace) {
+			services, err := k8sService.k8sClient.CoreV1().Services(namespace).List(context.TODO(), metaV1.ListOptions{})
+			if err != nil {
+				logs.GetLogger().Errorf("Failed reconcile network policies, namespace: %s, error: %+v", namespace, err)
+				continue
+			}
+			for _, service := range services.Items {
+				spaceUuid := service.Labels["lad_app"]
+				if spaceUuid == "" || service.Name != constants.K8S_SERVICE_NAME_PREFIX+spaceUuid+constants.K8S_NODEPORT_SERVICE_SUFFIX {
+					continue
+				}
+				if err = applyNodePortPolicy(k8sService, namespace, spaceUuid, service.Spec.Ports); err != nil {
+					logs.GetLogger().Errorf("Failed reconcile network policies, service: %s, error: %+v", service.Name, err)
+				}
+			}
+		}
+		reconciled++
+	}
+	logs.GetLogger().Infof("Reconciled the network policies of %d namespaces, enabled: %v", reconciled, conf.GetConfig().NetworkPolicy.Enable)
+}
@@ -7,8 +7,6 @@ import (
 	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
 	"github.com/swanchain/go-computing-provider/internal/models"
 	corev1 "k8s.io/api/core/v1"
-	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -20,17 +18,16 @@ const (
 	ResourceStorage string = "storage"
 )
 
-func allActivePods(clientSet *kubernetes.Clientset) ([]corev1.Pod, error) {
-	allPods, err := clientSet.CoreV1().Pods("").List(context.TODO(), metaV1.ListOptions{
-		FieldSelector: "status.phase=Running",
-	})
-	if err != nil {
-		return nil, err
-	}
-	return allPods.Items, nil
-}
+// PersistentStorageAnnotation holds the bytes claimed by the persistent
+// volumes a space pod mounts, such a pod is replaced without a surge. The
+// claims are counted from the claims themselves, see storageInClaims.
+const PersistentStorageAnnotation = "lad_persistent_storage"
+
+// selectedNodeAnnotation names the node a volume with a delayed binding was
+// provisioned for.
+const selectedNodeAnnotation = "volume.kubernetes.io/selected-node"
 
-func GetNodeResource(allPods []corev1.Pod, node *corev1.Node) (map[string]int64, map[string]int64, *models.NodeResource) {
+func GetNodeResource(allPods []corev1.Pod, claims []corev1.PersistentVolumeClaim, node *corev1.Node) (map[string]int64, map[string]int64, *models.NodeResource) {
 	var (
 		usedCpu     int64
 		usedMem     int64
@@ -42,6 +39,7 @@ func GetNodeResource(allPods []corev1.Pod, node *corev1.Node) (map[string]int64,
 	var nodeResource = new(models.NodeResource)
 	nodeResource.MachineId = node.Status.NodeInfo.MachineID
 
+	usedStorage += storageInClaims(claims, node)
 	for _, pod := range getPodsFromNode(allPods, node) {
 		usedCpu += cpuInPod(&pod)
 		usedMem += memInPod(&pod)
@@ -100,6 +98,24 @@ func storageInPod(pod *corev1.Pod) (storageUsed int64) {
 	return storageUsed
 }
 
+// storageInClaims returns the bytes the persistent volume claims hold on the
+// node. A claim is counted once, on the node its volume was provisioned for,
+// also while no pod mounts it, e.g. when its space is paused. The claims of
+// network storage use no disk of the nodes.
+func storageInClaims(claims []corev1.PersistentVolumeClaim, node *corev1.Node) (storageUsed int64) {
+	for _, claim := range claims {
+		if claim.Annotations[selectedNodeAnnotation] != node.Name {
+			continue
+		}
+		size := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+		if capacity, ok := claim.Status.Capacity[corev1.ResourceStorage]; ok && capacity.Cmp(size) > 0 {
+			size = capacity
+		}
+		storageUsed += size.Value()
+	}
+	return storageUsed
+}
+
 func cpuInPod(pod *corev1.Pod) (cpuCount int64) {
 	containers := pod.Spec.Containers
 	for _, container := range containers {
@@ -158,20 +174,26 @@ func checkClusterProviderStatus() {
 		}
 	}
 	service := NewK8sService()
-	activePods, err := allActivePods(service.k8sClient)
+	activePods, err := service.GetAllActivePod(context.TODO())
 	if err != nil {
 		logs.GetLogger().Errorf("get all active pod failed, error: %v", err)
 		return
 	}
 
-	nodes, err := service.k8sClient.CoreV1().Nodes().List(context.TODO(), metaV1.ListOptions{})
+	claims, err := service.ListSpaceClaims(context.TODO(), "")
+	if err != nil {
+		logs.GetLogger().Errorf("get all persistent volume claims failed, error: %v", err)
+		return
+	}
+
+	nodes, err := service.ListNodes(context.TODO())
 	if err != nil {
 		logs.GetLogger().Errorf("get all node failed, error: %v", err)
 		return
 	}
 
-	for _, node := range nodes.Items {
-		_, remainderResource, nodeResource := GetNodeResource(activePods, &node)
+	for _, node := range nodes {
+		_, remainderResource, nodeResource := GetNodeResource(activePods, claims, &node)
 		if remainderResource[ResourceCpu] < policy.Cpu.Quota {
 			logs.GetLogger().Warningf("Insufficient cpu resources, current cpu resource: %s less than %d", nodeResource.Cpu.Free, policy.Cpu.Quota)
 			return
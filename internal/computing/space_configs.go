@@ -0,0 +1,239 @@
+package computing
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/swanchain/go-computing-provider/conf"
+	"github.com/swanchain/go-computing-provider/constants"
+	"github.com/swanchain/go-computing-provider/internal/yaml"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// maxConfigMapSize keeps a ConfigMap below the 1MiB k8s allows, leaving
+	// room for its metadata.
+	maxConfigMapSize     = 1000 * 1024
+	defaultMaxConfigSize = 3 * 1024 * 1024
+)
+
+// spaceConfigs collects the config ConfigMaps of a space while it is deployed,
+// their number names the next volume and their size is checked against
+// SPACE.MaxConfigSize.
+type spaceConfigs struct {
+	count int
+	size  int64
+}
+
+// configContent is a config file or directory read for its ConfigMap.
+type configContent struct {
+	data       map[string]string
+	binaryData map[string][]byte
+	items      []coreV1.KeyToPath
+	size       int64
+	dir        bool
+	// placeholders are expanded in the text files
+	placeholders map[string]string
+}
+
+// configMounts creates a ConfigMap for every config entry of the service, it
+// returns the volumes of the pod and the mounts of the service container. A
+// file is mounted at its target, the files of a directory below it.
+func (d *Deploy) configMounts(service yaml.ContainerResource, configs *spaceConfigs) ([]coreV1.Volume, []coreV1.VolumeMount, error) {
+	maxSize := conf.GetConfig().SPACE.MaxConfigSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxConfigSize
+	}
+
+	var volumes []coreV1.Volume
+	var volumeMounts []coreV1.VolumeMount
+	for _, file := range service.VolumeMounts {
+		content, err := readConfig(filepath.Dir(d.yamlPath), file.Name, d.placeholders)
+		if err != nil {
+			return nil, nil, fmt.Errorf("config %s of %s: %w", file.Name, service.Name, err)
+		}
+		if content.size > maxConfigMapSize {
+			return nil, nil, fmt.Errorf("config %s of %s has %d bytes, more than the %d bytes of a ConfigMap", file.Name, service.Name, content.size, maxConfigMapSize)
+		}
+		configs.size += content.size
+		if configs.size > maxSize {
+			return nil, nil, fmt.Errorf("the config files of the space have more than the %d bytes allowed", maxSize)
+		}
+
+		// the ConfigMap is named by its content and never changes, the pods of
+		// the previous version keep theirs until the rollout succeeded
+		volumeName := fmt.Sprintf("config-%d", configs.count)
+		configName := fmt.Sprintf("%s%s-%s", constants.K8S_CONFIG_NAME_PREFIX, d.spaceUuid, content.hash())
+		if _, err = NewK8sService().CreateSpaceConfigMap(context.TODO(), d.k8sNameSpace, d.spaceUuid, configName, content.data, content.binaryData); err != nil {
+			return nil, nil, fmt.Errorf("failed create config map of %s: %w", file.Name, err)
+		}
+		configs.count++
+
+		for i := range content.items {
+			content.items[i].Mode = file.Mode
+		}
+		volumes = append(volumes, coreV1.Volume{
+			Name: volumeName,
+			VolumeSource: coreV1.VolumeSource{
+				ConfigMap: &coreV1.ConfigMapVolumeSource{
+					LocalObjectReference: coreV1.LocalObjectReference{Name: configName},
+					Items:                content.items,
+					DefaultMode:          file.Mode,
+				},
+			},
+		})
+		volumeMount := coreV1.VolumeMount{Name: volumeName, MountPath: file.Path, ReadOnly: true}
+		if !content.dir {
+			volumeMount.SubPath = content.items[0].Path
+		}
+		volumeMounts = append(volumeMounts, volumeMount)
+	}
+	return volumes, volumeMounts, nil
+}
+
+// readConfig reads a config file or directory inside baseDir and expands the
+// placeholders in its text files. Symlinks are rejected, so a space can not
+// mount files of the cp host.
+func readConfig(baseDir, source string, placeholders map[string]string) (*configContent, error) {
+	source = filepath.Clean(source)
+	if filepath.IsAbs(source) || source == ".." || strings.HasPrefix(source, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("must be a path inside the space")
+	}
+	root := filepath.Join(baseDir, source)
+	info, err := os.Lstat(root)
+	if err != nil {
+		return nil, err
+	}
+
+	content := &configContent{
+		data:         make(map[string]string),
+		binaryData:   make(map[string][]byte),
+		dir:          info.IsDir(),
+		placeholders: placeholders,
+	}
+	if !content.dir {
+		if !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("must be a regular file or a directory")
+		}
+		return content, content.add(root, filepath.Base(root))
+	}
+
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return fmt.Errorf("%s must be a regular file", rel)
+		}
+		return content.add(path, filepath.ToSlash(rel))
+	})
+	return content, err
+}
+
+// add reads the file into the content, it is mounted at path relative to the
+// target. Text goes to the data of the ConfigMap, anything else to its binary data.
+func (c *configContent) add(file, path string) error {
+	fileBytes, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	key := configKey(path)
+	if _, ok := c.data[key]; ok {
+		return fmt.Errorf("%s clashes with another file of the config", path)
+	}
+	if _, ok := c.binaryData[key]; ok {
+		return fmt.Errorf("%s clashes with another file of the config", path)
+	}
+	if utf8.Valid(fileBytes) {
+		text := yaml.ExpandPlaceholders(string(fileBytes), c.placeholders)
+		c.data[key] = text
+		c.size += int64(len(text))
+	} else {
+		c.binaryData[key] = fileBytes
+		c.size += int64(len(fileBytes))
+	}
+	c.items = append(c.items, coreV1.KeyToPath{Key: key, Path: path})
+	return nil
+}
+
+// hash returns a short hash of the data of the content, it names its ConfigMap.
+func (c *configContent) hash() string {
+	keys := make([]string, 0, len(c.data)+len(c.binaryData))
+	for key := range c.data {
+		keys = append(keys, key)
+	}
+	for key := range c.binaryData {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, key := range keys {
+		value, ok := c.data[key]
+		if !ok {
+			value = string(c.binaryData[key])
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%s\x00", key, len(value), value)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:10]
+}
+
+// pruneConfigMaps deletes the config ConfigMaps of the space that neither the
+// deployments of the space nor their ReplicaSets use. It runs once the
+// rollout succeeded, the ReplicaSets kept for a rollback keep their ConfigMaps.
+func pruneConfigMaps(k8sService *K8sService, namespace, spaceUuid string) error {
+	used := make(map[string]bool)
+	addVolumes := func(podSpec coreV1.PodSpec) {
+		for _, volume := range podSpec.Volumes {
+			if volume.ConfigMap != nil {
+				used[volume.ConfigMap.Name] = true
+			}
+		}
+	}
+	for _, selector := range []string{"lad_app=" + spaceUuid, "lad_space=" + spaceUuid} {
+		listOptions := metaV1.ListOptions{LabelSelector: selector}
+		deployments, err := k8sService.k8sClient.AppsV1().Deployments(namespace).List(context.TODO(), listOptions)
+		if err != nil {
+			return err
+		}
+		for _, deployment := range deployments.Items {
+			addVolumes(deployment.Spec.Template.Spec)
+		}
+		replicaSets, err := k8sService.k8sClient.AppsV1().ReplicaSets(namespace).List(context.TODO(), listOptions)
+		if err != nil {
+			return err
+		}
+		for _, replicaSet := range replicaSets.Items {
+			addVolumes(replicaSet.Spec.Template.Spec)
+		}
+	}
+	return k8sService.DeleteConfigMaps(context.TODO(), namespace, spaceUuid, used)
+}
+
+// configKey turns a file path into a ConfigMap key, "conf/app.ini" becomes
+// "conf__app.ini".
+func configKey(path string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, strings.ReplaceAll(path, "/", "__"))
+}
@@ -0,0 +1,241 @@
+package computing
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/swanchain/go-computing-provider/constants"
+	"github.com/swanchain/go-computing-provider/internal/yaml"
+	appV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// dependenciesAnnotation lists the standalone dependencies of the pod template
+// of a space, the others are deleted once the template is rolled out.
+const dependenciesAnnotation = "lad_dependencies"
+
+// spaceHelperImage runs the init containers the cp adds to a space, the ones
+// waiting for standalone dependencies and the ones downloading models.
+const spaceHelperImage = "busybox:1.36"
+
+// podServices returns the services that run in the pod of the service: the
+// service itself and its dependencies that are not standalone.
+func podServices(cr yaml.ContainerResource) []yaml.ContainerResource {
+	var services []yaml.ContainerResource
+	for _, depend := range cr.Depends {
+		if !depend.Standalone {
+			services = append(services, depend)
+		}
+	}
+	return append(services, cr)
+}
+
+// podVolumes returns the volumes the mounts of the pod use.
+func podVolumes(volumes []coreV1.Volume, services []yaml.ContainerResource) []coreV1.Volume {
+	used := make(map[string]bool)
+	for _, service := range services {
+		for _, volumeMount := range persistentVolumeMounts(service.Persistent) {
+			used[volumeMount.Name] = true
+		}
+	}
+	var result []coreV1.Volume
+	for _, volume := range volumes {
+		if used[volume.Name] {
+			result = append(result, volume)
+		}
+	}
+	return result
+}
+
+// dependencyNames joins the names of the standalone dependencies for the
+// dependenciesAnnotation.
+func dependencyNames(standalone map[string]bool) string {
+	var names []string
+	for name := range standalone {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// pruneSpaceResources deletes what the rolled out version of the space no
+// longer uses, the Deployments and services of removed standalone
+// dependencies and the unused ConfigMaps. Until then the previous version can
+// be rolled back. Deployments without the dependenciesAnnotation keep all
+// their dependencies.
+func pruneSpaceResources(k8sService *K8sService, namespace, spaceUuid string, deployment *appV1.Deployment) error {
+	if names, ok := deployment.Spec.Template.Annotations[dependenciesAnnotation]; ok {
+		keep := make(map[string]bool)
+		for _, name := range strings.Split(names, ",") {
+			if name != "" {
+				keep[name] = true
+			}
+		}
+		if err := k8sService.DeleteSpaceDependencies(context.TODO(), namespace, spaceUuid, keep); err != nil {
+			return fmt.Errorf("failed delete removed dependencies, error: %w", err)
+		}
+	}
+	if err := pruneConfigMaps(k8sService, namespace, spaceUuid); err != nil {
+		return fmt.Errorf("failed delete unused config maps, error: %w", err)
+	}
+	return nil
+}
+
+// dependencyHost is the address of a standalone dependency inside the cluster.
+func dependencyHost(namespace, spaceUuid, name string) string {
+	return constants.K8S_SERVICE_NAME_PREFIX + spaceUuid + "-" + name + "." + namespace
+}
+
+// dependencyPort returns the service port the readiness of a standalone
+// dependency is checked on, the first port that is not udp.
+func dependencyPort(depend yaml.ContainerResource) (int32, error) {
+	for _, expose := range depend.Exposes {
+		if expose.Protocol != yaml.ExposeUdp {
+			return expose.As, nil
+		}
+	}
+	return 0, fmt.Errorf("standalone dependency %s must expose a tcp port", depend.Name)
+}
+
+// checkStandaloneDependency rejects standalone dependencies whose k8s names
+// would be invalid or that can not be waited for.
+func (d *Deploy) checkStandaloneDependency(depend yaml.ContainerResource) error {
+	names := []string{
+		constants.K8S_SERVICE_NAME_PREFIX + d.spaceUuid + "-" + depend.Name,
+		d.spaceUuid + "-wait-" + depend.Name,
+	}
+	for _, name := range names {
+		if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+			return fmt.Errorf("standalone dependency name %s is too long or invalid: %s", depend.Name, strings.Join(errs, ", "))
+		}
+	}
+	if _, err := dependencyPort(depend); err != nil {
+		return err
+	}
+	return checkExposeConflicts(depend.Exposes)
+}
+
+// deployStandaloneDependency runs a dependency in its own Deployment behind a
+// ClusterIP service, it mounts its config files and the claims of its
+// persistent storage. It
+// returns the env that tells the dependent service where to find it and the
+// init container that waits until it is ready.
+func (d *Deploy) deployStandaloneDependency(depend yaml.ContainerResource, resources coreV1.ResourceRequirements, volumes []coreV1.Volume, claimSize int64, configs *spaceConfigs) ([]coreV1.EnvVar, coreV1.Container, error) {
+	secretEnv, err := d.createSecretEnv(depend.Name, depend.Secrets)
+	if err != nil {
+		return nil, coreV1.Container{}, fmt.Errorf("failed create secret: %w", err)
+	}
+	configVolumes, configMounts, err := d.configMounts(depend, configs)
+	if err != nil {
+		return nil, coreV1.Container{}, err
+	}
+	container := coreV1.Container{
+		Name:            d.spaceUuid + "-" + depend.Name,
+		Image:           depend.ImageName,
+		Command:         depend.Command,
+		Args:            depend.Args,
+		Env:             append(depend.Env, secretEnv...),
+		Ports:           depend.Ports,
+		ImagePullPolicy: coreV1.PullIfNotPresent,
+		VolumeMounts:    append(configMounts, persistentVolumeMounts(depend.Persistent)...),
+		Resources:       resources,
+	}
+	withContainerSettings(&container, depend)
+	if container.ReadinessProbe == nil && len(depend.ReadyCmd) > 0 {
+		container.ReadinessProbe = &coreV1.Probe{
+			ProbeHandler: coreV1.ProbeHandler{
+				Exec: &coreV1.ExecAction{Command: depend.ReadyCmd},
+			},
+			InitialDelaySeconds: 5,
+			PeriodSeconds:       5,
+		}
+	}
+
+	initContainers, err := d.initContainers(depend, map[string]coreV1.ResourceRequirements{depend.Name: resources})
+	if err != nil {
+		return nil, coreV1.Container{}, err
+	}
+
+	var nodeSelector map[string]string
+	if _, ok := resources.Limits[yaml.ResourceGpu]; ok {
+		nodeSelector = generateLabel(d.gpuProductName)
+	}
+	depVolumes := podVolumes(volumes, []yaml.ContainerResource{depend})
+	persistentStorage := claimSize * int64(len(depVolumes))
+
+	replicas := int32(depend.Count)
+	if replicas < 1 {
+		replicas = 1
+	}
+	labels := map[string]string{"lad_space": d.spaceUuid, "lad_service": depend.Name}
+	deployment := &appV1.Deployment{
+		TypeMeta: metaV1.TypeMeta{
+			Kind:       "Deployment",
+			APIVersion: "apps/v1",
+		},
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      constants.K8S_DEPLOY_NAME_PREFIX + d.spaceUuid + "-" + depend.Name,
+			Namespace: d.k8sNameSpace,
+			Labels:    labels,
+		},
+		Spec: appV1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metaV1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: coreV1.PodTemplateSpec{
+				ObjectMeta: metaV1.ObjectMeta{
+					Labels:    labels,
+					Namespace: d.k8sNameSpace,
+					Annotations: map[string]string{
+						PersistentStorageAnnotation: strconv.FormatInt(persistentStorage, 10),
+					},
+				},
+				Spec: coreV1.PodSpec{
+					NodeSelector:   nodeSelector,
+					InitContainers: initContainers,
+					Containers:     []coreV1.Container{container},
+					Volumes:        append(configVolumes, depVolumes...),
+				},
+			},
+		},
+	}
+	if err = d.withSpaceLifecycle(&deployment.Spec.Template.Spec); err != nil {
+		return nil, coreV1.Container{}, err
+	}
+	withTerminationGracePeriod(&deployment.Spec.Template.Spec, depend)
+	if _, err = d.applyDeployment(deployment); err != nil {
+		return nil, coreV1.Container{}, fmt.Errorf("failed apply deployment of %s: %w", depend.Name, err)
+	}
+
+	var ports []coreV1.ServicePort
+	for _, expose := range depend.Exposes {
+		ports = append(ports, spaceServicePort(expose))
+	}
+	if _, err = NewK8sService().CreateDependencyService(context.TODO(), d.k8sNameSpace, d.spaceUuid, depend.Name, ports); err != nil {
+		return nil, coreV1.Container{}, fmt.Errorf("failed create service of %s: %w", depend.Name, err)
+	}
+
+	host := dependencyHost(d.k8sNameSpace, d.spaceUuid, depend.Name)
+	port, err := dependencyPort(depend)
+	if err != nil {
+		return nil, coreV1.Container{}, err
+	}
+	envName := yaml.EnvName(depend.Name)
+	env := []coreV1.EnvVar{
+		{Name: envName + "_HOST", Value: host},
+		{Name: envName + "_PORT", Value: strconv.Itoa(int(port))},
+	}
+	wait := coreV1.Container{
+		Name:            d.spaceUuid + "-wait-" + depend.Name,
+		Image:           spaceHelperImage,
+		Command:         []string{"sh", "-c", fmt.Sprintf("until nc -z -w 2 %s %d; do echo waiting for %s; sleep 2; done", host, port, depend.Name)},
+		ImagePullPolicy: coreV1.PullIfNotPresent,
+	}
+	return env, wait, nil
+}
@@ -0,0 +1,298 @@
+package computing
+
+import (
+	"bytes"
+	"context"
+	stErrors "errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/swanchain/go-computing-provider/conf"
+	"github.com/swanchain/go-computing-provider/constants"
+	"github.com/swanchain/go-computing-provider/internal/models"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ContainerLogFileName = "container.log"
+
+	defaultExpireNoticeInSec     = 600
+	defaultTerminationGraceInSec = 30
+
+	// the notice ConfigMap is mounted into every space container, the
+	// expire_notice file only appears once the lease is about to end
+	spaceNoticeMountPath = "/var/run/lagrange"
+	spaceNoticeExpireAt  = "expire_at"
+	spaceNoticeExpire    = "expire_notice"
+
+	finalLogTailLines = 1000
+	finalLogMaxBytes  = 1024 * 1024
+
+	PausedExpireFrozen   = "frozen"
+	PausedExpireCounting = "counting"
+)
+
+var (
+	ErrNoResourcesAvailable = stErrors.New("no resources available")
+	ErrJobNotRunning        = stErrors.New("the job is not running")
+)
+
+func expireNoticePeriod() int64 {
+	if conf.GetConfig().SPACE.ExpireNotice > 0 {
+		return conf.GetConfig().SPACE.ExpireNotice
+	}
+	return defaultExpireNoticeInSec
+}
+
+func terminationGracePeriod() int64 {
+	if conf.GetConfig().SPACE.TerminationGracePeriod > 0 {
+		return conf.GetConfig().SPACE.TerminationGracePeriod
+	}
+	return defaultTerminationGraceInSec
+}
+
+// createSpaceNotice creates or resets the notice ConfigMap of the space.
+func createSpaceNotice(namespace, spaceUuid string, expireAt int64) error {
+	k8sService := NewK8sService()
+	data := map[string]string{spaceNoticeExpireAt: strconv.FormatInt(expireAt, 10)}
+
+	configMap, err := k8sService.k8sClient.CoreV1().ConfigMaps(namespace).Get(context.TODO(), constants.K8S_NOTICE_NAME_PREFIX+spaceUuid, metaV1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		_, err = k8sService.k8sClient.CoreV1().ConfigMaps(namespace).Create(context.TODO(), &coreV1.ConfigMap{
+			ObjectMeta: metaV1.ObjectMeta{
+				Name:   constants.K8S_NOTICE_NAME_PREFIX + spaceUuid,
+				Labels: map[string]string{"lad_app": spaceUuid},
+			},
+			Data: data,
+		}, metaV1.CreateOptions{})
+		return err
+	}
+	configMap.Data = data
+	_, err = k8sService.k8sClient.CoreV1().ConfigMaps(namespace).Update(context.TODO(), configMap, metaV1.UpdateOptions{})
+	return err
+}
+
+// sendExpireNotice writes the expire_notice file into the notice volume of the
+// space, spaces deployed without the volume are skipped.
+func sendExpireNotice(namespace string, jobMetadata models.CacheSpaceDetail) {
+	k8sService := NewK8sService()
+	configMap, err := k8sService.k8sClient.CoreV1().ConfigMaps(namespace).Get(context.TODO(), constants.K8S_NOTICE_NAME_PREFIX+jobMetadata.SpaceUuid, metaV1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			logs.GetLogger().Errorf("Failed get notice config map, space_uuid: %s, error: %+v", jobMetadata.SpaceUuid, err)
+		}
+		return
+	}
+	if _, ok := configMap.Data[spaceNoticeExpire]; ok {
+		return
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	expireAt := time.Unix(jobMetadata.ExpireTime, 0).UTC().Format(time.RFC3339)
+	configMap.Data[spaceNoticeExpireAt] = strconv.FormatInt(jobMetadata.ExpireTime, 10)
+	configMap.Data[spaceNoticeExpire] = fmt.Sprintf("The space will be terminated at %s, please save your data.\n", expireAt)
+	if _, err = k8sService.k8sClient.CoreV1().ConfigMaps(namespace).Update(context.TODO(), configMap, metaV1.UpdateOptions{}); err != nil {
+		logs.GetLogger().Errorf("Failed send expire notice, space_uuid: %s, error: %+v", jobMetadata.SpaceUuid, err)
+		return
+	}
+	logs.GetLogger().Infof("Sent expire notice, space_uuid: %s, expire at: %s", jobMetadata.SpaceUuid, expireAt)
+}
+
+// spaceNoticeVolume returns the volume, mount and env that expose the notice
+// ConfigMap to a space container.
+func spaceNoticeVolume(spaceUuid string) (coreV1.Volume, coreV1.VolumeMount, []coreV1.EnvVar) {
+	optional := true
+	volume := coreV1.Volume{
+		Name: constants.K8S_NOTICE_NAME_PREFIX + spaceUuid,
+		VolumeSource: coreV1.VolumeSource{
+			ConfigMap: &coreV1.ConfigMapVolumeSource{
+				LocalObjectReference: coreV1.LocalObjectReference{
+					Name: constants.K8S_NOTICE_NAME_PREFIX + spaceUuid,
+				},
+				Optional: &optional,
+			},
+		},
+	}
+	volumeMount := coreV1.VolumeMount{
+		Name:      volume.Name,
+		MountPath: spaceNoticeMountPath,
+		ReadOnly:  true,
+	}
+	envs := []coreV1.EnvVar{
+		{
+			Name:  "LAGRANGE_EXPIRE_AT_FILE",
+			Value: filepath.Join(spaceNoticeMountPath, spaceNoticeExpireAt),
+		},
+		{
+			Name:  "LAGRANGE_EXPIRE_NOTICE_FILE",
+			Value: filepath.Join(spaceNoticeMountPath, spaceNoticeExpire),
+		},
+	}
+	return volume, volumeMount, envs
+}
+
+func containerLogPath(walletAddress, spaceName string) string {
+	return filepath.Join("build", walletAddress, "spaces", spaceName, ContainerLogFileName)
+}
+
+// saveFinalContainerLog stores the last lines of every container of the space,
+// so the logs are still available after the pods are deleted.
+func saveFinalContainerLog(namespace string, jobMetadata models.CacheSpaceDetail) {
+	k8sService := NewK8sService()
+	pods, err := k8sService.k8sClient.CoreV1().Pods(namespace).List(context.TODO(), metaV1.ListOptions{
+		LabelSelector: fmt.Sprintf("lad_app=%s", jobMetadata.SpaceUuid),
+	})
+	if err != nil {
+		logs.GetLogger().Errorf("Failed list pods, space_uuid: %s, error: %+v", jobMetadata.SpaceUuid, err)
+		return
+	}
+	if len(pods.Items) == 0 {
+		return
+	}
+
+	var buf bytes.Buffer
+	tailLines := int64(finalLogTailLines)
+	limitBytes := int64(finalLogMaxBytes)
+	for _, pod := range pods.Items {
+		for _, container := range pod.Spec.Containers {
+			podLog, err := k8sService.GetPodLogByPodName(namespace, pod.Name, &coreV1.PodLogOptions{
+				Container:  container.Name,
+				Timestamps: true,
+				TailLines:  &tailLines,
+				LimitBytes: &limitBytes,
+			})
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(&buf, "==== pod: %s, container: %s ====\n", pod.Name, container.Name)
+			buf.WriteString(podLog)
+		}
+	}
+
+	logPath := containerLogPath(jobMetadata.WalletAddress, jobMetadata.SpaceName)
+	if err = os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		logs.GetLogger().Errorf("Failed create log dir, space_uuid: %s, error: %+v", jobMetadata.SpaceUuid, err)
+		return
+	}
+	if err = os.WriteFile(logPath, buf.Bytes(), 0644); err != nil {
+		logs.GetLogger().Errorf("Failed save container log, space_uuid: %s, error: %+v", jobMetadata.SpaceUuid, err)
+	}
+}
+
+func pausedExpireFrozen() bool {
+	return conf.GetConfig().SPACE.PausedExpirePolicy != PausedExpireCounting
+}
+
+// effectiveExpireTime returns when the lease of the space ends. With the frozen
+// policy, the time a space stays paused does not use up its lease.
+func effectiveExpireTime(jobMetadata models.CacheSpaceDetail) int64 {
+	if jobMetadata.PausedAt > 0 && pausedExpireFrozen() {
+		return jobMetadata.ExpireTime + time.Now().Unix() - jobMetadata.PausedAt
+	}
+	return jobMetadata.ExpireTime
+}
+
+// PauseSpace scales the deployment of the space and the deployments of its
+// standalone dependencies to zero. The services, ingress, config maps and the
+// space metadata are kept, so the space can be resumed.
+func PauseSpace(spaceUuid string) error {
+	jobMetadata, err := RetrieveJobMetadata(constants.REDIS_SPACE_PREFIX + spaceUuid)
+	if err != nil {
+		return err
+	}
+	if jobMetadata.PausedAt > 0 {
+		return fmt.Errorf("space %s is already paused", spaceUuid)
+	}
+	// a deployment still rolling out would be scaled to zero under the deploy
+	// task, which then reports the job as running
+	job, err := RetrieveJob(jobMetadata.JobUuid)
+	if err != nil {
+		return err
+	}
+	if job.Status != models.JobRunning {
+		return ErrJobNotRunning
+	}
+
+	namespace := SpaceNamespace(jobMetadata.WalletAddress)
+	k8sService := NewK8sService()
+	if err = k8sService.ScaleDeployment(context.TODO(), namespace, constants.K8S_DEPLOY_NAME_PREFIX+spaceUuid, 0); err != nil {
+		return fmt.Errorf("failed scale deployment, error: %w", err)
+	}
+	if err = k8sService.PauseSpaceDependencies(context.TODO(), namespace, spaceUuid); err != nil {
+		return fmt.Errorf("failed scale dependencies, error: %w", err)
+	}
+
+	redisConn := redisPool.Get()
+	defer redisConn.Close()
+	if _, err = redisConn.Do("HSET", constants.REDIS_SPACE_PREFIX+spaceUuid, "paused_at", time.Now().Unix()); err != nil {
+		return err
+	}
+	updateJobStatus(jobMetadata.JobUuid, models.JobPaused)
+	logs.GetLogger().Infof("Paused space, space_uuid: %s", spaceUuid)
+	return nil
+}
+
+// ResumeSpace scales the deployments of a paused space and of its standalone
+// dependencies back to their replica counts, if the resources are still available.
+func ResumeSpace(spaceUuid string) error {
+	jobMetadata, err := RetrieveJobMetadata(constants.REDIS_SPACE_PREFIX + spaceUuid)
+	if err != nil {
+		return err
+	}
+	if jobMetadata.PausedAt == 0 {
+		return fmt.Errorf("space %s is not paused", spaceUuid)
+	}
+
+	expireTime := effectiveExpireTime(jobMetadata)
+	leftTime := expireTime - time.Now().Unix()
+	if leftTime <= 0 {
+		return fmt.Errorf("space %s has expired", spaceUuid)
+	}
+
+	available, _, err := checkResourceAvailableForSpace(jobMetadata.Hardware, jobMetadata.Replicas, spaceUuid)
+	if err != nil {
+		return fmt.Errorf("failed check resources, error: %w", err)
+	}
+	if !available {
+		return ErrNoResourcesAvailable
+	}
+
+	namespace := SpaceNamespace(jobMetadata.WalletAddress)
+	k8sService := NewK8sService()
+	// the dependencies come first, the space waits for them to be ready
+	if err = k8sService.ResumeSpaceDependencies(context.TODO(), namespace, spaceUuid); err != nil {
+		return fmt.Errorf("failed scale dependencies, error: %w", err)
+	}
+	if err = k8sService.ScaleDeployment(context.TODO(), namespace, constants.K8S_DEPLOY_NAME_PREFIX+spaceUuid, int32(jobMetadata.Replicas)); err != nil {
+		return fmt.Errorf("failed scale deployment, error: %w", err)
+	}
+
+	redisConn := redisPool.Get()
+	defer redisConn.Close()
+	key := constants.REDIS_SPACE_PREFIX + spaceUuid
+	if _, err = redisConn.Do("HSET", key, "expire_time", strconv.FormatInt(expireTime, 10)); err != nil {
+		return err
+	}
+	if _, err = redisConn.Do("HDEL", key, "paused_at"); err != nil {
+		return err
+	}
+	redisConn.Do("SET", spaceUuid, "wait-delete", "EX", leftTime)
+
+	if err = createSpaceNotice(namespace, spaceUuid, expireTime); err != nil {
+		logs.GetLogger().Errorf("Failed reset notice config map, space_uuid: %s, error: %+v", spaceUuid, err)
+	}
+	updateJobStatus(jobMetadata.JobUuid, models.JobDeployToK8s)
+	logs.GetLogger().Infof("Resumed space, space_uuid: %s", spaceUuid)
+	return nil
+}
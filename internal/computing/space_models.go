@@ -0,0 +1,173 @@
+package computing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/swanchain/go-computing-provider/conf"
+	"github.com/swanchain/go-computing-provider/internal/yaml"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	modelCacheVolumeName   = "model-cache"
+	modelCacheMountPath    = "/model-cache"
+	defaultModelRetries    = 5
+	defaultModelCacheSize  = "100Gi"
+	modelDownloadScriptLib = `set -e
+download() {
+  url="$1"; cache="$2"; sum="$3"
+  exec 9>"$cache.lock"
+  flock 9
+  if [ -f "$cache" ]; then
+    if [ -z "$sum" ] || echo "$sum  $cache" | sha256sum -c >/dev/null 2>&1; then
+      echo "model $url is cached"
+      touch "$cache"
+      flock -u 9
+      return 0
+    fi
+    echo "cached model $url does not match its checksum"
+    rm -f "$cache"
+  fi
+  n=0
+  until wget -c -O "$cache.part" "$url"; do
+    n=$((n+1))
+    if [ "$n" -ge "$MODEL_RETRIES" ]; then
+      echo "failed download model $url after $n attempts"
+      return 1
+    fi
+    sleep $((n*5))
+  done
+  if [ -n "$sum" ] && ! echo "$sum  $cache.part" | sha256sum -c >/dev/null 2>&1; then
+    echo "model $url does not match its checksum $sum"
+    rm -f "$cache.part"
+    return 1
+  fi
+  mv "$cache.part" "$cache"
+  flock -u 9
+  echo "downloaded model $url"
+}
+# evict deletes the least recently used models of the cache until it fits in
+# MODEL_CACHE_LIMIT KiB, the models of the pod and the ones in use are kept.
+evict() {
+  [ -n "$MODEL_CACHE_LIMIT" ] || return 0
+  exec 8>"$MODEL_CACHE/.evict.lock"
+  flock 8
+  used=$(du -sk "$MODEL_CACHE" | cut -f1)
+  for name in $(ls -tr "$MODEL_CACHE"); do
+    [ "$used" -gt "$MODEL_CACHE_LIMIT" ] || break
+    case "$name" in *.lock|*.part) continue ;; esac
+    case " $MODEL_KEEP " in *" $name "*) continue ;; esac
+    size=$(du -sk "$MODEL_CACHE/$name" | cut -f1)
+    if (flock -n 7 && rm -f "$MODEL_CACHE/$name") 7>"$MODEL_CACHE/$name.lock"; then
+      echo "evicted model $name of $size KiB from the cache"
+      used=$((used-size))
+    fi
+  done
+  flock -u 8
+}
+`
+)
+
+// modelCacheKey is the file name of the model in the cache, models with a
+// checksum are shared by content and the others by url.
+func modelCacheKey(model yaml.ModelResource) string {
+	if model.Sha256 != "" {
+		return strings.ToLower(model.Sha256)
+	}
+	sum := sha256.Sum256([]byte(model.Url))
+	return "url-" + hex.EncodeToString(sum[:])
+}
+
+// modelCacheVolume is the cache the models are downloaded to. With
+// SPACE.ModelCacheDir it is a directory of the node shared by every space on
+// it, kept below SPACE.ModelCacheSize by the init containers. Otherwise the
+// models are kept for the lifetime of the pod in an emptyDir, it counts
+// against the storage of the pod and is limited to it.
+func modelCacheVolume(podStorage resource.Quantity) coreV1.Volume {
+	volume := coreV1.Volume{Name: modelCacheVolumeName}
+	if cacheDir := conf.GetConfig().SPACE.ModelCacheDir; cacheDir != "" {
+		hostPathType := coreV1.HostPathDirectoryOrCreate
+		volume.HostPath = &coreV1.HostPathVolumeSource{Path: cacheDir, Type: &hostPathType}
+	} else {
+		volume.EmptyDir = &coreV1.EmptyDirVolumeSource{}
+		if !podStorage.IsZero() {
+			volume.EmptyDir.SizeLimit = &podStorage
+		}
+	}
+	return volume
+}
+
+// modelCacheLimit is SPACE.ModelCacheSize in KiB, the size the shared cache
+// of a node is kept below. It is empty if the models are cached per pod.
+func modelCacheLimit() (string, error) {
+	if conf.GetConfig().SPACE.ModelCacheDir == "" {
+		return "", nil
+	}
+	size := conf.GetConfig().SPACE.ModelCacheSize
+	if size == "" {
+		size = defaultModelCacheSize
+	}
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil || quantity.Sign() <= 0 {
+		return "", fmt.Errorf("invalid SPACE.ModelCacheSize %q", size)
+	}
+	return fmt.Sprintf("%d", quantity.Value()/1024), nil
+}
+
+// modelInitContainer downloads the models of the service before it starts.
+// Downloads are resumed and retried, and checked against their sha256 if one
+// is given. It returns the init container and the read-only mounts that put
+// every model at its path in the service container.
+func (d *Deploy) modelInitContainer(models []yaml.ModelResource) (coreV1.Container, []coreV1.VolumeMount, error) {
+	retries := conf.GetConfig().SPACE.ModelDownloadRetries
+	if retries <= 0 {
+		retries = defaultModelRetries
+	}
+	cacheLimit, err := modelCacheLimit()
+	if err != nil {
+		return coreV1.Container{}, nil, err
+	}
+
+	script := modelDownloadScriptLib + "evict\n"
+	var keys []string
+	var volumeMounts []coreV1.VolumeMount
+	for _, model := range models {
+		key := modelCacheKey(model)
+		keys = append(keys, key)
+		script += fmt.Sprintf("download %s %s %s\n", shellQuote(model.Url), shellQuote(modelCacheMountPath+"/"+key), shellQuote(strings.ToLower(model.Sha256)))
+		volumeMounts = append(volumeMounts, coreV1.VolumeMount{
+			Name:      modelCacheVolumeName,
+			MountPath: filepath.Join(model.Dir, model.Name),
+			SubPath:   key,
+			ReadOnly:  true,
+		})
+	}
+
+	script += "evict\n"
+
+	container := coreV1.Container{
+		Name:    d.spaceUuid + "-models",
+		Image:   spaceHelperImage,
+		Command: []string{"sh", "-c", script},
+		Env: []coreV1.EnvVar{
+			{Name: "MODEL_RETRIES", Value: fmt.Sprintf("%d", retries)},
+			{Name: "MODEL_CACHE", Value: modelCacheMountPath},
+			{Name: "MODEL_CACHE_LIMIT", Value: cacheLimit},
+			{Name: "MODEL_KEEP", Value: strings.Join(keys, " ")},
+		},
+		ImagePullPolicy: coreV1.PullIfNotPresent,
+		VolumeMounts: []coreV1.VolumeMount{
+			{Name: modelCacheVolumeName, MountPath: modelCacheMountPath},
+		},
+	}
+	return container, volumeMounts, nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,97 @@
+package computing
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/swanchain/go-computing-provider/internal/yaml"
+	coreV1 "k8s.io/api/core/v1"
+)
+
+// spacePlaceholders returns the values of the placeholders the services of the
+// space can use. Dependencies in the pod are reached on localhost, standalone
+// ones through their service.
+func (d *Deploy) spacePlaceholders(cr yaml.ContainerResource) map[string]string {
+	values := map[string]string{
+		yaml.PlaceholderSpaceUrl:      "https://" + d.hostName,
+		yaml.PlaceholderSpaceUuid:     d.spaceUuid,
+		yaml.PlaceholderWalletAddress: d.walletAddress,
+		yaml.PlaceholderJobUuid:       d.jobUuid,
+		yaml.PlaceholderResultHost:    d.hostName,
+		yaml.PlaceholderExpireAt:      strconv.FormatInt(time.Now().Unix()+d.duration, 10),
+	}
+	for _, depend := range cr.Depends {
+		host := "localhost"
+		if depend.Standalone {
+			host = dependencyHost(d.k8sNameSpace, d.spaceUuid, depend.Name)
+		}
+		values[yaml.DependencyHostPlaceholder(depend.Name)] = host
+	}
+	return values
+}
+
+// expandPlaceholders returns the service and its dependencies with the
+// placeholders in their env, command, args and init containers expanded.
+func expandPlaceholders(service yaml.ContainerResource, values map[string]string) yaml.ContainerResource {
+	service.Command = expandStrings(service.Command, values)
+	service.Args = expandStrings(service.Args, values)
+	service.Env = expandEnv(service.Env, values)
+
+	initContainers := make([]yaml.InitResource, len(service.InitContainers))
+	for i, init := range service.InitContainers {
+		init.Command = expandStrings(init.Command, values)
+		init.Args = expandStrings(init.Args, values)
+		init.Env = expandEnv(init.Env, values)
+		initContainers[i] = init
+	}
+	service.InitContainers = initContainers
+
+	depends := make([]yaml.ContainerResource, len(service.Depends))
+	for i, depend := range service.Depends {
+		depends[i] = expandPlaceholders(depend, values)
+	}
+	service.Depends = depends
+	return service
+}
+
+// legacyNextAuthUrl sets NEXTAUTH_URL to the url of the space, as the cp did
+// before the placeholders, unless the space sets it with a placeholder.
+// Deprecated: it is kept for one release, spaces should use ${SPACE_URL}.
+func (d *Deploy) legacyNextAuthUrl(env []coreV1.EnvVar) []coreV1.EnvVar {
+	for i, envVar := range env {
+		if !strings.Contains(envVar.Name, "NEXTAUTH_URL") || strings.Contains(envVar.Value, "${") {
+			continue
+		}
+		logs.GetLogger().Warnf("space_uuid: %s, %s is set to the space url, this is deprecated and will be removed in the next release, use %s=${%s}",
+			d.spaceUuid, envVar.Name, envVar.Name, yaml.PlaceholderSpaceUrl)
+		result := append([]coreV1.EnvVar{}, env...)
+		result[i].Value = "https://" + d.hostName
+		return result
+	}
+	return env
+}
+
+func expandStrings(values []string, placeholders map[string]string) []string {
+	if values == nil {
+		return nil
+	}
+	result := make([]string, len(values))
+	for i, value := range values {
+		result[i] = yaml.ExpandPlaceholders(value, placeholders)
+	}
+	return result
+}
+
+func expandEnv(env []coreV1.EnvVar, placeholders map[string]string) []coreV1.EnvVar {
+	if env == nil {
+		return nil
+	}
+	result := make([]coreV1.EnvVar, len(env))
+	for i, envVar := range env {
+		envVar.Value = yaml.ExpandPlaceholders(envVar.Value, placeholders)
+		result[i] = envVar
+	}
+	return result
+}
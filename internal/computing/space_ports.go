@@ -0,0 +1,230 @@
+package computing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/swanchain/go-computing-provider/conf"
+	"github.com/swanchain/go-computing-provider/constants"
+	"github.com/swanchain/go-computing-provider/internal/models"
+	"github.com/swanchain/go-computing-provider/internal/yaml"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// maxNodePortAttempts bounds the node ports tried for a space when
+// NodePortRange restricts them.
+const maxNodePortAttempts = 32
+
+// nodePortLock serializes the node port choices of the deploys of this cp, the
+// API server rejects the ports another cp or user allocated meanwhile.
+var nodePortLock sync.Mutex
+
+// httpExpose is the single port of spaces that are not deployed from a deploy.yaml.
+func httpExpose(port int32) []yaml.ExposePort {
+	return []yaml.ExposePort{{Port: port, As: port, Global: true, Protocol: yaml.ExposeHttp}}
+}
+
+func spaceServicePort(expose yaml.ExposePort) coreV1.ServicePort {
+	protocol := coreV1.ProtocolTCP
+	if expose.Protocol == yaml.ExposeUdp {
+		protocol = coreV1.ProtocolUDP
+	}
+	return coreV1.ServicePort{
+		Name:       fmt.Sprintf("%s-%d", expose.Protocol, expose.As),
+		Protocol:   protocol,
+		Port:       expose.As,
+		TargetPort: intstr.FromInt32(expose.Port),
+	}
+}
+
+// checkExposeConflicts rejects service ports that are exposed by more than
+// one container of the space.
+func checkExposeConflicts(exposes []yaml.ExposePort) error {
+	seen := make(map[string]bool)
+	for _, expose := range exposes {
+		servicePort := spaceServicePort(expose)
+		key := fmt.Sprintf("%s/%d", servicePort.Protocol, servicePort.Port)
+		if seen[key] {
+			return fmt.Errorf("port %d/%s is exposed more than once", servicePort.Port, strings.ToLower(string(servicePort.Protocol)))
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// nodePortRange returns the node ports the spaces may use, from
+// SPACE.NodePortRange. The bool is false if it is not set, the API server
+// then allocates them from the service-node-port-range of the cluster.
+func nodePortRange() (int32, int32, bool, error) {
+	portRange := strings.TrimSpace(conf.GetConfig().SPACE.NodePortRange)
+	if portRange == "" {
+		return 0, 0, false, nil
+	}
+	minStr, maxStr, ok := strings.Cut(portRange, "-")
+	if !ok {
+		return 0, 0, false, fmt.Errorf("invalid NodePortRange %q, expected min-max", portRange)
+	}
+	minPort, err := strconv.ParseInt(strings.TrimSpace(minStr), 10, 32)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("invalid NodePortRange %q, error: %w", portRange, err)
+	}
+	maxPort, err := strconv.ParseInt(strings.TrimSpace(maxStr), 10, 32)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("invalid NodePortRange %q, error: %w", portRange, err)
+	}
+	if minPort < 1 || maxPort > 65535 || minPort > maxPort {
+		return 0, 0, false, fmt.Errorf("invalid NodePortRange %q", portRange)
+	}
+	return int32(minPort), int32(maxPort), true, nil
+}
+
+// cpPublicHost returns the public ip of the cp from API.MultiAddress.
+func cpPublicHost() string {
+	multiAddressSplit := strings.Split(conf.GetConfig().API.MultiAddress, "/")
+	if len(multiAddressSplit) > 2 {
+		return multiAddressSplit[2]
+	}
+	return ""
+}
+
+// publishNodePorts publishes the global tcp/udp ports of the space through a
+// NodePort service. Ports that were published before keep their node port,
+// the new ones get one from the API server.
+func (d *Deploy) publishNodePorts(exposes []yaml.ExposePort) ([]models.JobEndpoint, error) {
+	k8sService := NewK8sService()
+	serviceName := constants.K8S_SERVICE_NAME_PREFIX + d.spaceUuid + constants.K8S_NODEPORT_SERVICE_SUFFIX
+	if len(exposes) == 0 {
+		if err := k8sService.DeleteService(context.TODO(), d.k8sNameSpace, serviceName); err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		return nil, applyNodePortPolicy(k8sService, d.k8sNameSpace, d.spaceUuid, nil)
+	}
+
+	current := make(map[string]int32)
+	existing, err := k8sService.k8sClient.CoreV1().Services(d.k8sNameSpace).Get(context.TODO(), serviceName, metaV1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		for _, port := range existing.Spec.Ports {
+			current[port.Name] = port.NodePort
+		}
+	}
+
+	minPort, maxPort, restricted, err := nodePortRange()
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []coreV1.ServicePort
+	var unallocated []int
+	for i, expose := range exposes {
+		servicePort := spaceServicePort(expose)
+		if nodePort, ok := current[servicePort.Name]; ok && (!restricted || nodePort >= minPort && nodePort <= maxPort) {
+			servicePort.NodePort = nodePort
+		} else if restricted {
+			unallocated = append(unallocated, i)
+		}
+		ports = append(ports, servicePort)
+	}
+
+	service, err := d.createNodePortService(k8sService, ports, unallocated, minPort, maxPort)
+	if err != nil {
+		return nil, err
+	}
+	if err = applyNodePortPolicy(k8sService, d.k8sNameSpace, d.spaceUuid, service.Spec.Ports); err != nil {
+		return nil, fmt.Errorf("failed apply node port network policy, error: %w", err)
+	}
+
+	var endpoints []models.JobEndpoint
+	host := cpPublicHost()
+	for i, port := range service.Spec.Ports {
+		endpoints = append(endpoints, models.JobEndpoint{
+			Port:     exposes[i].Port,
+			Protocol: exposes[i].Protocol,
+			Global:   true,
+			Url:      fmt.Sprintf("%s:%d", host, port.NodePort),
+		})
+	}
+	return endpoints, nil
+}
+
+// createNodePortService creates or updates the NodePort service of the space.
+// The ports at the unallocated indexes are given node ports of NodePortRange,
+// from a random one on, until the API server accepts them.
+func (d *Deploy) createNodePortService(k8sService *K8sService, ports []coreV1.ServicePort, unallocated []int, minPort, maxPort int32) (*coreV1.Service, error) {
+	if len(unallocated) == 0 {
+		return k8sService.CreateSpaceNodePortService(context.TODO(), d.k8sNameSpace, d.spaceUuid, ports)
+	}
+
+	nodePortLock.Lock()
+	defer nodePortLock.Unlock()
+
+	size := maxPort - minPort + 1
+	offset := rand.Int31n(size)
+	var tried int32
+	for attempt := 0; attempt < maxNodePortAttempts; attempt++ {
+		for _, i := range unallocated {
+			for {
+				if tried >= size {
+					return nil, fmt.Errorf("no free node port left in %d-%d", minPort, maxPort)
+				}
+				candidate := minPort + (offset+tried)%size
+				tried++
+				if !hasNodePort(ports, candidate) {
+					ports[i].NodePort = candidate
+					break
+				}
+			}
+		}
+		service, err := k8sService.CreateSpaceNodePortService(context.TODO(), d.k8sNameSpace, d.spaceUuid, ports)
+		if err == nil || !isNodePortAllocated(err) {
+			return service, err
+		}
+		for _, i := range unallocated {
+			ports[i].NodePort = 0
+		}
+	}
+	return nil, fmt.Errorf("no free node port found in %d-%d after %d attempts", minPort, maxPort, maxNodePortAttempts)
+}
+
+func hasNodePort(ports []coreV1.ServicePort, nodePort int32) bool {
+	for _, port := range ports {
+		if port.NodePort == nodePort {
+			return true
+		}
+	}
+	return false
+}
+
+// isNodePortAllocated tells whether the API server rejected a node port that
+// another service uses.
+func isNodePortAllocated(err error) bool {
+	return errors.IsInvalid(err) && strings.Contains(err.Error(), "provided port is already allocated")
+}
+
+// saveJobEndpoints stores the endpoints of the job next to its JobData, so
+// they are kept when the JobData is saved again.
+func saveJobEndpoints(jobUuid string, endpoints []models.JobEndpoint) error {
+	endpointsBytes, err := json.Marshal(endpoints)
+	if err != nil {
+		return err
+	}
+	redisConn := redisPool.Get()
+	defer redisConn.Close()
+	exist, err := redis.Int(redisConn.Do("EXISTS", constants.REDIS_JOB_PREFIX+jobUuid))
+	if err != nil || exist == 0 {
+		return err
+	}
+	_, err = redisConn.Do("HSET", constants.REDIS_JOB_PREFIX+jobUuid, "endpoints", endpointsBytes)
+	return err
+}
@@ -0,0 +1,31 @@
+package computing
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestIsNodePortAllocated(t *testing.T) {
+	serviceKind := schema.GroupKind{Kind: "Service"}
+	portPath := field.NewPath("spec", "ports").Index(0).Child("nodePort")
+
+	allocated := errors.NewInvalid(serviceKind, "svc-space-nodeport", field.ErrorList{
+		field.Invalid(portPath, 30080, "provided port is already allocated"),
+	})
+	if !isNodePortAllocated(allocated) {
+		t.Errorf("expected %v to be an allocated node port", allocated)
+	}
+
+	outOfRange := errors.NewInvalid(serviceKind, "svc-space-nodeport", field.ErrorList{
+		field.Invalid(portPath, 80, "provided port is not in the valid range. The range of valid ports is 30000-32767"),
+	})
+	if isNodePortAllocated(outOfRange) {
+		t.Errorf("expected %v not to be an allocated node port", outOfRange)
+	}
+	if isNodePortAllocated(errors.NewAlreadyExists(schema.GroupResource{Resource: "services"}, "svc-space-nodeport")) {
+		t.Error("an existing service is not an allocated node port")
+	}
+}
@@ -18,44 +18,132 @@ import (
 	"net/http"
 	"os"
 	"strings"
-	"sync"
 	"time"
 )
 
-var deployingChan = make(chan models2.Job)
+// defaultRolloutTimeout is how long, in seconds, a deployed job may stay not
+// rolled out before it is rolled back or the reason of its pods is reported.
+const defaultRolloutTimeout = 10 * 60
+
+func rolloutTimeout() int64 {
+	if conf.GetConfig().SPACE.RolloutTimeout > 0 {
+		return conf.GetConfig().SPACE.RolloutTimeout
+	}
+	return defaultRolloutTimeout
+}
 
 type ScheduleTask struct {
-	TaskMap sync.Map
+	k8sService *K8sService
 }
 
 func NewScheduleTask() *ScheduleTask {
 	return &ScheduleTask{}
 }
 
+// Run reports every job status that the hub has not acknowledged yet. The
+// statuses are persisted in redis, so reporting resumes after a restart.
 func (s *ScheduleTask) Run() {
-	for {
-		select {
-		case job := <-deployingChan:
-			s.TaskMap.Store(job.Uuid, &job)
-		case <-time.After(3 * time.Second):
-			s.TaskMap.Range(func(key, value any) bool {
-				jobUuid := key.(string)
-				job := value.(*models2.Job)
-				if reportJobStatus(jobUuid, job.Status) && job.Status == models2.JobDeployToK8s {
-					s.TaskMap.Delete(jobUuid)
+	indexActiveJobs()
+	failInterruptedJobs()
+	s.k8sService = NewK8sService()
+
+	ticker := time.NewTicker(3 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.checkDeployedJobs()
+		s.reportPendingJobs()
+	}
+}
+
+func (s *ScheduleTask) reportPendingJobs() {
+	jobs, err := listJobs(func(job *models2.Job) bool {
+		return job.Status != job.ReportedStatus
+	})
+	if err != nil {
+		logs.GetLogger().Error(err)
+		return
+	}
+	for _, job := range jobs {
+		if !reportJobStatus(job) {
+			continue
+		}
+		if err = markJobReported(job.Uuid, job.Status); err != nil {
+			logs.GetLogger().Errorf("Failed mark job status reported, job_uuid: %s, error: %+v", job.Uuid, err)
+		}
+	}
+}
+
+func (s *ScheduleTask) checkDeployedJobs() {
+	jobs, err := listJobs(func(job *models2.Job) bool {
+		return job.Status == models2.JobDeployToK8s
+	})
+	if err != nil {
+		logs.GetLogger().Error(err)
+		return
+	}
+
+	k8sService := s.k8sService
+	for _, job := range jobs {
+		namespace := SpaceNamespace(job.WalletAddress)
+		deployment, err := k8sService.k8sClient.AppsV1().Deployments(namespace).Get(context.TODO(), constants.K8S_DEPLOY_NAME_PREFIX+job.SpaceUuid, metaV1.GetOptions{})
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				logs.GetLogger().Errorf("Failed get deployment, job_uuid: %s, error: %+v", job.Uuid, err)
+			}
+			continue
+		}
+		if IsDeploymentRolledOut(deployment) {
+			updateJobStatus(job.Uuid, models2.JobRunning)
+			if err = pruneSpaceResources(k8sService, namespace, job.SpaceUuid, deployment); err != nil {
+				logs.GetLogger().Warnf("space_uuid: %s, %v", job.SpaceUuid, err)
+			}
+			continue
+		}
+
+		// the pods rejected by the quota of the wallet are never created, they
+		// are reported once the rejection lasted its grace period, without
+		// waiting for the rollout timeout
+		failure := k8sService.GetAdmissionFailure(namespace, job.SpaceUuid)
+		if failure == nil {
+			if time.Now().Unix()-job.StageTimes[models2.JobDeployToK8s] < rolloutTimeout() {
+				continue
+			}
+			failure = k8sService.GetPodFailure(namespace, job.SpaceUuid)
+		}
+		if job.RollingUpdate {
+			err = k8sService.RollbackDeployment(context.TODO(), namespace, deployment.Name)
+			if err == nil {
+				reason := "the new version was not ready in time"
+				if failure != nil {
+					reason = failure.Message
 				}
-				return true
-			})
+				failJob(job.Uuid, models2.NewJobFailure(models2.FailureRolledBack, "%s, rolled back to the previous version", reason))
+				continue
+			}
+			if err != ErrNoPreviousRevision {
+				logs.GetLogger().Errorf("Failed rollback deployment, job_uuid: %s, error: %+v", job.Uuid, err)
+			}
+		}
+		if failure != nil {
+			failJob(job.Uuid, failure)
 		}
 	}
 }
 
-func reportJobStatus(jobUuid string, jobStatus models2.JobStatus) bool {
+func reportJobStatus(job *models2.Job) bool {
 	reqParam := map[string]interface{}{
-		"job_uuid":       jobUuid,
-		"status":         jobStatus,
+		"job_uuid":       job.Uuid,
+		"status":         job.Status,
 		"public_address": conf.GetConfig().HUB.WalletAddress,
 	}
+	if job.Failure != nil {
+		reqParam["failure"] = job.Failure
+	}
+	if job.Status == models2.JobRunning {
+		if jobData, err := retrieveJobData(job.Uuid); err == nil && len(jobData.Endpoints) > 0 {
+			reqParam["endpoints"] = jobData.Endpoints
+		}
+	}
 
 	payload, err := json.Marshal(reqParam)
 	if err != nil {
@@ -84,14 +172,14 @@ func reportJobStatus(jobUuid string, jobStatus models2.JobStatus) bool {
 		return false
 	}
 
-	logs.GetLogger().Debugf("report job status successfully. uuid: %s, status: %s", jobUuid, jobStatus)
+	logs.GetLogger().Debugf("report job status successfully. uuid: %s, status: %s", job.Uuid, job.Status)
 	return true
 }
 
 func RunSyncTask(nodeId string) {
 	go func() {
 		k8sService := NewK8sService()
-		nodes, err := k8sService.k8sClient.CoreV1().Nodes().List(context.TODO(), metaV1.ListOptions{})
+		nodes, err := k8sService.ListNodes(context.TODO())
 		if err != nil {
 			logs.GetLogger().Error(err)
 			return
@@ -103,8 +191,8 @@ func RunSyncTask(nodeId string) {
 			return
 		}
 
-		logs.GetLogger().Infof("collect all node: %d", len(nodes.Items))
-		for _, node := range nodes.Items {
+		logs.GetLogger().Infof("collect all node: %d", len(nodes))
+		for _, node := range nodes {
 			cpNode := node
 			if collectInfo, ok := nodeGpuInfoMap[cpNode.Name]; ok {
 				for _, detail := range collectInfo.Gpu.Details {
@@ -140,6 +228,7 @@ func RunSyncTask(nodeId string) {
 	}()
 
 	watchExpiredTask()
+	watchCustomDomains()
 	watchNameSpaceForDeleted()
 	monitorDaemonSetPods()
 }
@@ -215,7 +304,7 @@ func watchExpiredTask() {
 						return
 					}
 
-					namespace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(jobMetadata.WalletAddress)
+					namespace := SpaceNamespace(jobMetadata.WalletAddress)
 
 					if len(strings.TrimSpace(jobMetadata.TaskUuid)) == 0 {
 						taskStatus, err := checkTaskStatusByHub(jobMetadata.TaskUuid, nodeId)
@@ -226,6 +315,8 @@ func watchExpiredTask() {
 						if strings.Contains(taskStatus, "Task not found") {
 							logs.GetLogger().Infof("task_uuid: %s, task not found on the orchestrator service, starting to delete it.", jobMetadata.TaskUuid)
 							deleteJob(namespace, jobMetadata.SpaceUuid)
+							deleteSpaceVolumes(namespace, jobMetadata.SpaceUuid)
+							updateJobStatus(jobMetadata.JobUuid, models2.JobCancelled)
 							deleteKey = append(deleteKey, key)
 							continue
 						}
@@ -233,22 +324,30 @@ func watchExpiredTask() {
 							strings.Contains(taskStatus, "Cancelled") || strings.Contains(taskStatus, "Failed") {
 							logs.GetLogger().Infof("task_uuid: %s, current status is %s, starting to delete it.", jobMetadata.TaskUuid, taskStatus)
 							if err = deleteJob(namespace, jobMetadata.SpaceUuid); err == nil {
+								deleteSpaceVolumes(namespace, jobMetadata.SpaceUuid)
+								updateJobStatus(jobMetadata.JobUuid, models2.JobCancelled)
 								deleteKey = append(deleteKey, key)
 								continue
 							}
 						}
 					}
 
-					if time.Now().Unix() > jobMetadata.ExpireTime {
-						expireTimeStr := time.Unix(jobMetadata.ExpireTime, 0).Format("2006-01-02 15:04:05")
+					expireTime := effectiveExpireTime(jobMetadata)
+					if time.Now().Unix() > expireTime {
+						expireTimeStr := time.Unix(expireTime, 0).Format("2006-01-02 15:04:05")
 						logs.GetLogger().Infof("<timer-task> redis-key: %s,expireTime: %s. the job starting terminated", key, expireTimeStr)
+						saveFinalContainerLog(namespace, jobMetadata)
 						if err = deleteJob(namespace, jobMetadata.SpaceUuid); err == nil {
+							deleteSpaceVolumes(namespace, jobMetadata.SpaceUuid)
+							updateJobStatus(jobMetadata.JobUuid, models2.JobExpired)
 							deleteKey = append(deleteKey, key)
 							continue
 						}
+					} else if jobMetadata.PausedAt == 0 && time.Now().Unix() > expireTime-expireNoticePeriod() {
+						sendExpireNotice(namespace, jobMetadata)
 					}
 
-					k8sNameSpace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(jobMetadata.WalletAddress)
+					k8sNameSpace := SpaceNamespace(jobMetadata.WalletAddress)
 					deployName := constants.K8S_DEPLOY_NAME_PREFIX + jobMetadata.SpaceUuid
 					service := NewK8sService()
 					if _, err = service.k8sClient.AppsV1().Deployments(k8sNameSpace).Get(context.TODO(), deployName, metaV1.GetOptions{}); err != nil && errors.IsNotFound(err) {
@@ -284,12 +383,15 @@ func watchNameSpaceForDeleted() {
 				}
 
 				for _, namespace := range namespaces {
-					getPods, err := service.GetPods(namespace, "")
+					if !isTenantNamespace(namespace) {
+						continue
+					}
+					inUse, err := namespaceInUse(service, namespace)
 					if err != nil {
-						logs.GetLogger().Errorf("Failed get pods form namespace,namepace: %s, error: %+v", namespace, err)
+						logs.GetLogger().Errorf("Failed check namespace, namepace: %s, error: %+v", namespace, err)
 						continue
 					}
-					if !getPods && (strings.HasPrefix(namespace, constants.K8S_NAMESPACE_NAME_PREFIX) || strings.HasPrefix(namespace, "ubi-task")) {
+					if !inUse {
 						if err = service.DeleteNameSpace(context.TODO(), namespace); err != nil {
 							logs.GetLogger().Errorf("Failed delete namespace, namepace: %s, error: %+v", namespace, err)
 						}
@@ -301,6 +403,29 @@ func watchNameSpaceForDeleted() {
 	}()
 }
 
+// namespaceInUse tells whether a tenant namespace still holds pods,
+// deployments or space volumes. A paused space has no pods, its deployment is
+// scaled to zero and keeps the namespace until the space expires. The volumes
+// are removed with the space by deleteSpaceVolumes, never with the namespace.
+func namespaceInUse(service *K8sService, namespace string) (bool, error) {
+	hasPods, err := service.GetPods(namespace, "")
+	if err != nil || hasPods {
+		return hasPods, err
+	}
+	deployments, err := service.ListDeployments(context.TODO(), namespace)
+	if err != nil {
+		return false, err
+	}
+	if len(deployments) > 0 {
+		return true, nil
+	}
+	claims, err := service.ListSpaceClaims(context.TODO(), namespace)
+	if err != nil {
+		return false, err
+	}
+	return len(claims) > 0, nil
+}
+
 func checkTaskStatusByHub(taskUuid, nodeId string) (string, error) {
 	url := fmt.Sprintf("%s/check_task_status_with_node_id/%s/%s", conf.GetConfig().HUB.ServerUrl, taskUuid, nodeId)
 	client := &http.Client{}
@@ -351,7 +476,7 @@ func monitorDaemonSetPods() {
 			}
 		}()
 
-		namespace := "kube-system"
+		namespace := systemNamespace()
 		service := NewK8sService()
 		stopCh := wait.NeverStop
 		var num int64 = 1
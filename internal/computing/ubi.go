@@ -147,7 +147,7 @@ func DoUbiTaskForK8s(c *gin.Context) {
 	if err != nil {
 		ubiTaskToRedis.Status = constants.UBI_TASK_FAILED_STATUS
 		SaveUbiTaskMetadata(ubiTaskToRedis)
-		logs.GetLogger().Error("get memory failed, error: %+v", err)
+		logs.GetLogger().Errorf("get memory failed, error: %+v", err)
 		return
 	}
 
@@ -155,7 +155,7 @@ func DoUbiTaskForK8s(c *gin.Context) {
 	if err != nil {
 		ubiTaskToRedis.Status = constants.UBI_TASK_FAILED_STATUS
 		SaveUbiTaskMetadata(ubiTaskToRedis)
-		logs.GetLogger().Error("get storage failed, error: %+v", err)
+		logs.GetLogger().Errorf("get storage failed, error: %+v", err)
 		return
 	}
 
@@ -163,7 +163,7 @@ func DoUbiTaskForK8s(c *gin.Context) {
 	if err != nil {
 		ubiTaskToRedis.Status = constants.UBI_TASK_FAILED_STATUS
 		SaveUbiTaskMetadata(ubiTaskToRedis)
-		logs.GetLogger().Error("get memory failed, error: %+v", err)
+		logs.GetLogger().Errorf("get memory failed, error: %+v", err)
 		return
 	}
 
@@ -171,7 +171,7 @@ func DoUbiTaskForK8s(c *gin.Context) {
 	if err != nil {
 		ubiTaskToRedis.Status = constants.UBI_TASK_FAILED_STATUS
 		SaveUbiTaskMetadata(ubiTaskToRedis)
-		logs.GetLogger().Error("get storage failed, error: %+v", err)
+		logs.GetLogger().Errorf("get storage failed, error: %+v", err)
 		return
 	}
 
@@ -191,7 +191,7 @@ func DoUbiTaskForK8s(c *gin.Context) {
 	}
 
 	go func() {
-		var namespace = "ubi-task-" + strconv.Itoa(ubiTask.ID)
+		var namespace = constants.K8S_UBI_NAMESPACE_PREFIX + strconv.Itoa(ubiTask.ID)
 		var err error
 		defer func() {
 			key := constants.REDIS_UBI_C2_PERFIX + strconv.Itoa(ubiTask.ID)
@@ -233,6 +233,10 @@ func DoUbiTaskForK8s(c *gin.Context) {
 				}
 			}
 		}
+		if err = ensureNetworkPolicies(k8sService, namespace); err != nil {
+			logs.GetLogger().Errorf("apply network policies failed, namespace: %s, error: %v", namespace, err)
+			return
+		}
 
 		receiveUrl := fmt.Sprintf("%s:%d/api/v1/computing/cp/receive/ubi", k8sService.GetAPIServerEndpoint(), conf.GetConfig().API.Port)
 		execCommand := []string{"ubi-bench", "c2"}
@@ -342,7 +346,7 @@ func DoUbiTaskForK8s(c *gin.Context) {
 			break
 		}
 
-		req := k8sService.k8sClient.CoreV1().Pods(namespace).GetLogs(podName, &v1.PodLogOptions{
+		req := k8sService.streamClient.CoreV1().Pods(namespace).GetLogs(podName, &v1.PodLogOptions{
 			Container:  "",
 			Follow:     true,
 			Timestamps: true,
@@ -661,7 +665,7 @@ func checkResourceForUbi(resource *models.TaskResource) (bool, string, int64, in
 
 	var nodeResource models.NodeResource
 	if err := json.Unmarshal([]byte(containerLogStr), &nodeResource); err != nil {
-		logs.GetLogger().Error("collect host hardware resource failed, error: %+v", err)
+		logs.GetLogger().Errorf("collect host hardware resource failed, error: %+v", err)
 		return false, "", 0, 0, err
 	}
 
@@ -746,13 +750,13 @@ func GetCpResource(c *gin.Context) {
 	dockerService := NewDockerService()
 	containerLogStr, err := dockerService.ContainerLogs("resource-exporter")
 	if err != nil {
-		logs.GetLogger().Error("collect host hardware resource failed, error: %+v", err)
+		logs.GetLogger().Errorf("collect host hardware resource failed, error: %+v", err)
 		return
 	}
 
 	var nodeResource models.NodeResource
 	if err := json.Unmarshal([]byte(containerLogStr), &nodeResource); err != nil {
-		logs.GetLogger().Error("hardware info parse to json failed, error: %+v", err)
+		logs.GetLogger().Errorf("hardware info parse to json failed, error: %+v", err)
 		return
 	}
 
@@ -850,13 +854,13 @@ func reportClusterResourceForDocker() {
 	dockerService := NewDockerService()
 	containerLogStr, err := dockerService.ContainerLogs("resource-exporter")
 	if err != nil {
-		logs.GetLogger().Error("collect host hardware resource failed, error: %+v", err)
+		logs.GetLogger().Errorf("collect host hardware resource failed, error: %+v", err)
 		return
 	}
 
 	var nodeResource models.NodeResource
 	if err := json.Unmarshal([]byte(containerLogStr), &nodeResource); err != nil {
-		logs.GetLogger().Error("hardware info parse to json failed, error: %+v", err)
+		logs.GetLogger().Errorf("hardware info parse to json failed, error: %+v", err)
 		return
 	}
 
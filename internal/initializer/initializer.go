@@ -69,6 +69,8 @@ func ProjectInit(cpRepoPath string) {
 		logs.GetLogger().Fatal(err)
 	}
 	nodeID := computing.InitComputingProvider(cpRepoPath)
+	computing.StartClusterCache()
+	go computing.ReconcileNetworkPolicies()
 	// Start sending heartbeats
 	go SendHeartbeats(nodeID)
 
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"fmt"
 	"math/big"
 	"time"
 )
@@ -31,35 +32,124 @@ type JobData struct {
 	Status   string `json:"status"`
 	Duration int    `json:"duration"`
 	//Hardware      string `json:"hardware"`
-	JobSourceURI                string `json:"job_source_uri"`
-	JobResultURI                string `json:"job_result_uri,omitempty"`
-	StorageSource               string `json:"storage_source,omitempty"`
-	TaskUUID                    string `json:"task_uuid"`
-	CreatedAt                   string `json:"created_at"`
-	UpdatedAt                   string `json:"updated_at,omitempty"`
-	BuildLog                    string `json:"build_log,omitempty"`
-	ContainerLog                string `json:"container_log"`
-	NodeIdJobSourceUriSignature string `json:"node_id_job_source_uri_signature"`
-	JobRealUri                  string `json:"job_real_uri,omitempty"`
+	JobSourceURI                string        `json:"job_source_uri"`
+	JobResultURI                string        `json:"job_result_uri,omitempty"`
+	StorageSource               string        `json:"storage_source,omitempty"`
+	TaskUUID                    string        `json:"task_uuid"`
+	CreatedAt                   string        `json:"created_at"`
+	UpdatedAt                   string        `json:"updated_at,omitempty"`
+	BuildLog                    string        `json:"build_log,omitempty"`
+	ContainerLog                string        `json:"container_log"`
+	NodeIdJobSourceUriSignature string        `json:"node_id_job_source_uri_signature"`
+	JobRealUri                  string        `json:"job_real_uri,omitempty"`
+	Force                       bool          `json:"force,omitempty"`
+	CustomDomains               []string      `json:"custom_domains,omitempty"`
+	Endpoints                   []JobEndpoint `json:"endpoints,omitempty"`
+}
+
+// JobEndpoint is an exposed port of a space. Global endpoints are reachable
+// from the internet, the others only inside the cluster.
+type JobEndpoint struct {
+	Port     int32  `json:"port"`
+	Protocol string `json:"protocol"`
+	Global   bool   `json:"global"`
+	Url      string `json:"url"`
 }
 
 type Job struct {
-	Uuid   string
-	Status JobStatus
-	Url    string
+	Uuid           string              `json:"job_uuid"`
+	TaskUuid       string              `json:"task_uuid"`
+	SpaceUuid      string              `json:"space_uuid"`
+	WalletAddress  string              `json:"wallet_address"`
+	Status         JobStatus           `json:"status"`
+	ReportedStatus JobStatus           `json:"reported_status"`
+	Url            string              `json:"url"`
+	UpdatedAt      int64               `json:"updated_at"`
+	StageTimes     map[JobStatus]int64 `json:"stage_times"`
+	RollingUpdate  bool                `json:"rolling_update,omitempty"` // the job updates a running space
+	Failure        *JobFailure         `json:"failure,omitempty"`
 }
 
 type JobStatus string
 
 const (
+	JobReceived       JobStatus = "received"       // job accepted by the cp
 	JobDownloadSource JobStatus = "downloadSource" // download file form job_resource_uri
-	JobUploadResult   JobStatus = "uploadResult"   // upload task result to mcs
 	JobBuildImage     JobStatus = "buildImage"     // build images
 	JobPushImage      JobStatus = "pushImage"      // push image to registry
 	JobPullImage      JobStatus = "pullImage"      // download file form job_resource_uri
 	JobDeployToK8s    JobStatus = "deployToK8s"    // deploy image to k8s
+	JobRunning        JobStatus = "running"        // the space is ready to serve
+	JobPaused         JobStatus = "paused"         // the space is scaled to zero and keeps its resources
+	JobExpired        JobStatus = "expired"        // the lease ended and the space was removed
+	JobFailed         JobStatus = "failed"         // the deployment failed
+	JobCancelled      JobStatus = "cancelled"      // the job was cancelled by the hub
 )
 
+var jobTransitions = map[JobStatus][]JobStatus{
+	"":                {JobReceived},
+	JobReceived:       {JobDownloadSource, JobFailed, JobCancelled},
+	JobDownloadSource: {JobBuildImage, JobPullImage, JobFailed, JobCancelled},
+	JobBuildImage:     {JobPushImage, JobPullImage, JobFailed, JobCancelled},
+	JobPushImage:      {JobPullImage, JobFailed, JobCancelled},
+	JobPullImage:      {JobDeployToK8s, JobFailed, JobCancelled},
+	JobDeployToK8s:    {JobRunning, JobExpired, JobFailed, JobCancelled},
+	JobRunning:        {JobReceived, JobPaused, JobExpired, JobFailed, JobCancelled},
+	JobPaused:         {JobReceived, JobDeployToK8s, JobExpired, JobCancelled},
+	JobFailed:         {JobReceived},
+}
+
+// CanTransitionTo reports whether a job in status s may move to next.
+// A running or failed job may go back to received when it is redeployed.
+func (s JobStatus) CanTransitionTo(next JobStatus) bool {
+	for _, status := range jobTransitions[s] {
+		if status == next {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTerminal reports whether no further progress is expected for the job.
+func (s JobStatus) IsTerminal() bool {
+	return s == JobExpired || s == JobFailed || s == JobCancelled
+}
+
+type JobFailureCode string
+
+const (
+	FailureSourceUnavailable   JobFailureCode = "SOURCE_UNAVAILABLE"   // the space files can not be fetched
+	FailureInvalidSpec         JobFailureCode = "INVALID_SPEC"         // the deploy.yaml or Dockerfile is not valid
+	FailureImageBuild          JobFailureCode = "IMAGE_BUILD_FAILED"   // docker build failed
+	FailureImagePush           JobFailureCode = "IMAGE_PUSH_FAILED"    // pushing to the registry failed
+	FailureImagePull           JobFailureCode = "IMAGE_PULL_FAILED"    // k8s can not pull the image
+	FailureResourceUnavailable JobFailureCode = "RESOURCE_UNAVAILABLE" // no node has the hardware of the order
+	FailureAdmissionRejected   JobFailureCode = "ADMISSION_REJECTED"   // the quota or limit range of the wallet namespace rejected the pods
+	FailureK8sDeploy           JobFailureCode = "K8S_DEPLOY_FAILED"    // creating the k8s resources failed
+	FailureInterrupted         JobFailureCode = "INTERRUPTED"          // the cp restarted during the deployment
+	FailureRolledBack          JobFailureCode = "ROLLED_BACK"          // the new version was not ready in time, the previous one keeps running
+	FailureUnknown             JobFailureCode = "UNKNOWN"
+)
+
+// JobFailure describes why a job ended in the failed status. Stage is the
+// status the job was in when it failed.
+type JobFailure struct {
+	Stage   JobStatus      `json:"stage"`
+	Code    JobFailureCode `json:"code"`
+	Message string         `json:"message"`
+}
+
+func NewJobFailure(code JobFailureCode, format string, args ...interface{}) *JobFailure {
+	return &JobFailure{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (f *JobFailure) Error() string {
+	return f.Message
+}
+
 type DeleteJobReq struct {
 	CreatorWallet string `json:"creator_wallet"`
 	SpaceName     string `json:"space_name"`
@@ -75,7 +165,8 @@ type SpaceJSON struct {
 			Uuid        string `json:"uuid"`
 			Name        string `json:"name"`
 			ActiveOrder struct {
-				Config SpaceHardware `json:"config"`
+				Config   SpaceHardware `json:"config"`
+				Replicas int           `json:"replicas"`
 			} `json:"activeOrder"`
 		} `json:"space"`
 	} `json:"data"`
@@ -83,6 +174,24 @@ type SpaceJSON struct {
 	Status  string `json:"status"`
 }
 
+// OrderReplicas returns the replica count the active order of the space paid
+// for, one for the orders without a count.
+func (s SpaceJSON) OrderReplicas() int {
+	if s.Data.Space.ActiveOrder.Replicas < 1 {
+		return 1
+	}
+	return s.Data.Space.ActiveOrder.Replicas
+}
+
+// CheckReplicas checks that a replica count is between one and the count paid
+// by the order.
+func CheckReplicas(replicas, maxReplicas int) error {
+	if replicas < 1 || replicas > maxReplicas {
+		return fmt.Errorf("replicas must be between 1 and %d, got %d", maxReplicas, replicas)
+	}
+	return nil
+}
+
 type SpaceFile struct {
 	Name string `json:"name"`
 	URL  string `json:"url"`
@@ -109,17 +218,47 @@ type Specification struct {
 }
 
 type CacheSpaceDetail struct {
-	WalletAddress string
-	SpaceName     string
-	SpaceUuid     string
-	ExpireTime    int64
-	JobUuid       string
-	TaskType      string
-	DeployName    string
-	Hardware      string
-	Url           string
-	TaskUuid      string
-	SpaceType     string
+	WalletAddress string   `json:"wallet_address"`
+	SpaceName     string   `json:"space_name"`
+	SpaceUuid     string   `json:"space_uuid"`
+	ExpireTime    int64    `json:"expire_time"`
+	JobUuid       string   `json:"job_uuid"`
+	TaskType      string   `json:"task_type"`
+	DeployName    string   `json:"deploy_name"`
+	Hardware      string   `json:"hardware"`
+	Url           string   `json:"url"`
+	TaskUuid      string   `json:"task_uuid"`
+	SpaceType     string   `json:"space_type"`
+	Replicas      int      `json:"replicas"`
+	MaxReplicas   int      `json:"max_replicas"`
+	PausedAt      int64    `json:"paused_at,omitempty"`
+	CustomDomains []string `json:"custom_domains,omitempty"`
+}
+
+type JobDetail struct {
+	Space             CacheSpaceDetail `json:"space"`
+	Job               *Job             `json:"job,omitempty"`
+	DeploymentStatus  string           `json:"deployment_status"`
+	Pods              []PodDetail      `json:"pods"`
+	Events            []JobEvent       `json:"events"`
+	IngressHost       string           `json:"ingress_host"`
+	ExpiresIn         int64            `json:"expires_in"`
+	CustomDomainToken string           `json:"custom_domain_token,omitempty"`
+}
+
+type PodDetail struct {
+	Name         string `json:"name"`
+	Phase        string `json:"phase"`
+	Ready        bool   `json:"ready"`
+	RestartCount int32  `json:"restart_count"`
+}
+
+type JobEvent struct {
+	Type      string `json:"type"`
+	Reason    string `json:"reason"`
+	Object    string `json:"object"`
+	Message   string `json:"message"`
+	Timestamp int64  `json:"timestamp"`
 }
 
 type UBITaskReq struct {
@@ -0,0 +1,591 @@
+package yaml
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/loader"
+	composetypes "github.com/compose-spec/compose-go/v2/types"
+	yamlv3 "gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// composeFileNames are the file names docker compose looks up by default.
+var composeFileNames = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+// IsComposeFile reports whether the file is a docker compose file.
+func IsComposeFile(path string) bool {
+	name := strings.ToLower(filepath.Base(path))
+	for _, composeFileName := range composeFileNames {
+		if name == composeFileName {
+			return true
+		}
+	}
+	return false
+}
+
+// UnsupportedComposeError lists the compose features of a project that can
+// not be deployed as a space.
+type UnsupportedComposeError struct {
+	Features []string
+}
+
+func (e *UnsupportedComposeError) Error() string {
+	return "unsupported compose features:\n" + strings.Join(e.Features, "\n")
+}
+
+// HandlerCompose translates a compose project into the containers of a space.
+// The space is one pod: the service no other service depends on is the main
+// container and the other services run next to it.
+func HandlerCompose(composeFilePath string) ([]ContainerResource, error) {
+	project, err := loadComposeProject(composeFilePath)
+	if err != nil {
+		return nil, err
+	}
+	return ComposeToK8sResource(project)
+}
+
+func loadComposeProject(composeFilePath string) (*composetypes.Project, error) {
+	composeFile, err := os.ReadFile(composeFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed unable to read file, %w", err)
+	}
+	workingDir, err := filepath.Abs(filepath.Dir(composeFilePath))
+	if err != nil {
+		return nil, err
+	}
+
+	// the loader reads env_file and extends.file, they are checked before so
+	// that no file outside of the space ends up in its containers
+	if features := composeFileReferences(composeFile, workingDir); len(features) > 0 {
+		return nil, &UnsupportedComposeError{Features: features}
+	}
+
+	// the environment of the cp is not passed to the project, so that
+	// variables of the host never end up in the containers of a space
+	project, err := loader.LoadWithContext(context.TODO(), composetypes.ConfigDetails{
+		WorkingDir: workingDir,
+		ConfigFiles: []composetypes.ConfigFile{
+			{Filename: composeFilePath, Content: composeFile},
+		},
+		Environment: map[string]string{},
+	}, func(options *loader.Options) {
+		options.SetProjectName("space", true)
+		options.SkipInclude = true
+		// placeholders are expanded by the cp when the space is deployed
+		lookupValue := options.Interpolate.LookupValue
+		options.Interpolate.LookupValue = func(key string) (string, bool) {
+			if isComposePlaceholder(key) {
+				return "${" + key + "}", true
+			}
+			return lookupValue(key)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed unable to parse compose file, %w", err)
+	}
+	return project, nil
+}
+
+// composeFileReferences returns the env_file and extends.file paths of the
+// services that are not relative paths inside the working dir.
+func composeFileReferences(composeFile []byte, workingDir string) []string {
+	var raw struct {
+		Services map[string]map[string]interface{} `yaml:"services"`
+	}
+	// a malformed file is reported by the loader
+	if err := yamlv3.Unmarshal(composeFile, &raw); err != nil {
+		return nil
+	}
+
+	var features []string
+	check := func(name, feature string, value interface{}) {
+		path, ok := value.(string)
+		if !ok {
+			features = append(features, fmt.Sprintf("services.%s.%s: must be a path", name, feature))
+			return
+		}
+		if !composePathInside(workingDir, path) {
+			features = append(features, fmt.Sprintf("services.%s.%s: %q must be a relative path inside the space", name, feature, path))
+		}
+	}
+	names := make([]string, 0, len(raw.Services))
+	for name := range raw.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		service := raw.Services[name]
+		switch envFile := service["env_file"].(type) {
+		case nil:
+		case []interface{}:
+			for _, entry := range envFile {
+				if entryMap, ok := entry.(map[string]interface{}); ok {
+					entry = entryMap["path"]
+				}
+				check(name, "env_file", entry)
+			}
+		default:
+			check(name, "env_file", envFile)
+		}
+		if extends, ok := service["extends"].(map[string]interface{}); ok {
+			if file, ok := extends["file"]; ok {
+				check(name, "extends.file", file)
+			}
+		}
+	}
+	return features
+}
+
+// composePathInside tells whether path is relative and stays inside dir,
+// also after its symlinks are resolved.
+func composePathInside(dir, path string) bool {
+	if path == "" || filepath.IsAbs(path) || strings.Contains(path, "$") {
+		return false
+	}
+	if resolvedDir, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolvedDir
+	}
+	target := filepath.Join(dir, path)
+	if resolved, err := filepath.EvalSymlinks(target); err == nil {
+		target = resolved
+	}
+	rel, err := filepath.Rel(dir, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// ComposeToK8sResource converts the services of a compose project, every
+// unsupported feature is reported in one UnsupportedComposeError.
+func ComposeToK8sResource(project *composetypes.Project) ([]ContainerResource, error) {
+	if len(project.Services) == 0 {
+		return nil, fmt.Errorf("at least one service must be defined")
+	}
+
+	var unsupported []string
+	unsupported = append(unsupported, unsupportedProjectFeatures(project)...)
+	names := project.ServiceNames()
+	sort.Strings(names)
+	for _, name := range names {
+		unsupported = append(unsupported, unsupportedServiceFeatures(project.Services[name])...)
+	}
+
+	dependedOn := make(map[string]bool)
+	for _, service := range project.Services {
+		for depend := range service.DependsOn {
+			dependedOn[depend] = true
+		}
+	}
+	var roots []string
+	for _, name := range names {
+		if !dependedOn[name] {
+			roots = append(roots, name)
+		}
+	}
+	if len(roots) != 1 {
+		unsupported = append(unsupported, fmt.Sprintf("the project must have exactly one service no other service depends on, found %d: %s", len(roots), strings.Join(roots, ", ")))
+	}
+	if len(unsupported) > 0 {
+		return nil, &UnsupportedComposeError{Features: unsupported}
+	}
+
+	main := roots[0]
+	mainContainer, err := composeServiceToContainer(project.Services[main], true)
+	if err != nil {
+		return nil, err
+	}
+	order, err := composeDependencyOrder(project, main)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range order {
+		container, err := composeServiceToContainer(project.Services[name], false)
+		if err != nil {
+			return nil, err
+		}
+		mainContainer.Depends = append(mainContainer.Depends, *container)
+	}
+	return []ContainerResource{*mainContainer}, nil
+}
+
+// composeDependencyOrder returns the services the main service depends on,
+// directly or not, with the dependencies before the services using them.
+func composeDependencyOrder(project *composetypes.Project, main string) ([]string, error) {
+	var order []string
+	state := make(map[string]int)
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("service %s is part of a depends_on cycle", name)
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		service := project.Services[name]
+		depends := make([]string, 0, len(service.DependsOn))
+		for depend := range service.DependsOn {
+			depends = append(depends, depend)
+		}
+		sort.Strings(depends)
+		for _, depend := range depends {
+			if err := visit(depend); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		if name != main {
+			order = append(order, name)
+		}
+		return nil
+	}
+	if err := visit(main); err != nil {
+		return nil, err
+	}
+
+	// services that are not reachable from the main service still run in the pod
+	names := project.ServiceNames()
+	sort.Strings(names)
+	for _, name := range names {
+		if state[name] == 0 {
+			if err := visit(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return order, nil
+}
+
+func unsupportedProjectFeatures(project *composetypes.Project) []string {
+	var features []string
+	if len(project.Secrets) > 0 {
+		features = append(features, "secrets: top-level secrets are not supported, use the environment instead")
+	}
+	if len(project.Configs) > 0 {
+		features = append(features, "configs: top-level configs are not supported")
+	}
+	for name, volume := range project.Volumes {
+		if volume.External {
+			features = append(features, fmt.Sprintf("volumes.%s: external volumes are not supported", name))
+		}
+		if volume.Driver != "" && volume.Driver != "local" {
+			features = append(features, fmt.Sprintf("volumes.%s: volume driver %s is not supported", name, volume.Driver))
+		}
+	}
+	sort.Strings(features)
+	return features
+}
+
+func unsupportedServiceFeatures(service composetypes.ServiceConfig) []string {
+	var features []string
+	add := func(feature, reason string) {
+		features = append(features, fmt.Sprintf("services.%s.%s: %s", service.Name, feature, reason))
+	}
+
+	if errs := validation.IsDNS1123Label(service.Name); len(errs) > 0 {
+		features = append(features, fmt.Sprintf("services.%s: the service name must be a lowercase RFC 1123 label", service.Name))
+	}
+	if service.Build != nil {
+		add("build", "images are not built from compose files, use a prebuilt image")
+	} else if service.Image == "" {
+		add("image", "an image is required")
+	}
+	if service.Privileged {
+		add("privileged", "privileged containers are not supported")
+	}
+	if service.NetworkMode != "" {
+		add("network_mode", "the services of a space share the network of one pod")
+	}
+	if service.Pid != "" {
+		add("pid", "is not supported")
+	}
+	if service.Ipc != "" {
+		add("ipc", "is not supported")
+	}
+	if service.UserNSMode != "" {
+		add("userns_mode", "is not supported")
+	}
+	if len(service.CapAdd) > 0 {
+		add("cap_add", "adding capabilities is not supported")
+	}
+	if len(service.Devices) > 0 {
+		add("devices", "host devices are not supported, request gpus in deploy.resources")
+	}
+	if len(service.SecurityOpt) > 0 {
+		add("security_opt", "is not supported")
+	}
+	if len(service.Sysctls) > 0 {
+		add("sysctls", "is not supported")
+	}
+	if len(service.Ulimits) > 0 {
+		add("ulimits", "is not supported")
+	}
+	if len(service.Tmpfs) > 0 {
+		add("tmpfs", "is not supported")
+	}
+	if len(service.VolumesFrom) > 0 {
+		add("volumes_from", "is not supported, share a named volume instead")
+	}
+	if len(service.ExtraHosts) > 0 {
+		add("extra_hosts", "is not supported")
+	}
+	if len(service.Secrets) > 0 {
+		add("secrets", "is not supported, use the environment instead")
+	}
+	if len(service.Configs) > 0 {
+		add("configs", "is not supported")
+	}
+	if service.User != "" {
+		add("user", "is not supported")
+	}
+	if service.WorkingDir != "" {
+		add("working_dir", "is not supported")
+	}
+	if service.Scale != nil && *service.Scale > 1 {
+		add("scale", "is not supported, use deploy.replicas on the main service")
+	}
+
+	for depend, dependency := range service.DependsOn {
+		if dependency.Condition == composetypes.ServiceConditionCompletedSuccessfully {
+			add("depends_on."+depend, "the service_completed_successfully condition is not supported")
+		}
+	}
+	for _, volume := range service.Volumes {
+		switch {
+		case volume.Type != composetypes.VolumeTypeVolume:
+			add("volumes", fmt.Sprintf("%s volume %s is not supported, use a named volume", volume.Type, volume.Target))
+		case volume.Source == "":
+			add("volumes", fmt.Sprintf("anonymous volume %s is not supported, use a named volume", volume.Target))
+		}
+	}
+	for _, port := range service.Ports {
+		if port.Protocol != "" && port.Protocol != ExposeTcp && port.Protocol != ExposeUdp {
+			add("ports", fmt.Sprintf("protocol %s is not supported", port.Protocol))
+		}
+	}
+
+	if service.Deploy != nil {
+		if service.Deploy.Mode != "" && service.Deploy.Mode != "replicated" {
+			add("deploy.mode", fmt.Sprintf("mode %s is not supported", service.Deploy.Mode))
+		}
+		if len(service.Deploy.Placement.Constraints) > 0 || len(service.Deploy.Placement.Preferences) > 0 {
+			add("deploy.placement", "is not supported")
+		}
+		for _, res := range []*composetypes.Resource{service.Deploy.Resources.Limits, service.Deploy.Resources.Reservations} {
+			if res == nil {
+				continue
+			}
+			for _, device := range res.Devices {
+				if !isGpuDevice(device) {
+					add("deploy.resources.devices", "only gpu devices are supported")
+				} else if device.Count < 0 {
+					add("deploy.resources.devices", "count: all is not supported, request a number of gpus")
+				} else if len(device.IDs) > 0 {
+					add("deploy.resources.devices", "device_ids is not supported, request a number of gpus")
+				}
+			}
+			if len(res.GenericResources) > 0 {
+				add("deploy.resources.generic_resources", "is not supported")
+			}
+		}
+	}
+	return features
+}
+
+func isGpuDevice(device composetypes.DeviceRequest) bool {
+	for _, capability := range device.Capabilities {
+		if capability == "gpu" {
+			return true
+		}
+	}
+	return false
+}
+
+func composeServiceToContainer(service composetypes.ServiceConfig, main bool) (*ContainerResource, error) {
+	container := &ContainerResource{
+		Name:      service.Name,
+		ImageName: service.Image,
+		Command:   service.Entrypoint,
+		Args:      service.Command,
+	}
+
+	envNames := make([]string, 0, len(service.Environment))
+	for name := range service.Environment {
+		envNames = append(envNames, name)
+	}
+	sort.Strings(envNames)
+	for _, name := range envNames {
+		var value string
+		if v := service.Environment[name]; v != nil {
+			value = *v
+		}
+		container.Env = append(container.Env, corev1.EnvVar{Name: name, Value: value})
+	}
+
+	container.Exposes = composeExposePorts(service, main)
+	for _, expose := range container.Exposes {
+		container.Ports = append(container.Ports, corev1.ContainerPort{
+			ContainerPort: expose.Port,
+			Protocol:      getProtocol(expose.Protocol),
+		})
+	}
+
+	if service.HealthCheck != nil && !service.HealthCheck.Disable && len(service.HealthCheck.Test) > 1 {
+		switch service.HealthCheck.Test[0] {
+		case "CMD":
+			container.ReadyCmd = service.HealthCheck.Test[1:]
+		case "CMD-SHELL":
+			container.ReadyCmd = []string{"/bin/sh", "-c", strings.Join(service.HealthCheck.Test[1:], " ")}
+		}
+	}
+
+	for _, volume := range service.Volumes {
+		container.Persistent = append(container.Persistent, PersistentMount{
+			Name: volume.Source,
+			Path: volume.Target,
+		})
+	}
+
+	if service.Deploy != nil {
+		if main && service.Deploy.Replicas != nil {
+			container.Count = *service.Deploy.Replicas
+		}
+		profile, err := composeProfile(service.Deploy.Resources)
+		if err != nil {
+			return nil, fmt.Errorf("service %s, %w", service.Name, err)
+		}
+		container.Profile = profile
+	}
+	return container, nil
+}
+
+// composeExposePorts maps the published ports to global ports and the exposed
+// ones to ports of the space service. The first published tcp port of the main
+// service is served through the ingress, like the first port of a deploy.yaml.
+func composeExposePorts(service composetypes.ServiceConfig, main bool) []ExposePort {
+	var exposes []ExposePort
+	seen := make(map[string]bool)
+	var httpAssigned bool
+	for _, port := range service.Ports {
+		protocol := ExposeTcp
+		if port.Protocol == ExposeUdp {
+			protocol = ExposeUdp
+		}
+		key := fmt.Sprintf("%s/%d", protocol, port.Target)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if main && !httpAssigned && protocol == ExposeTcp {
+			protocol = ExposeHttp
+			httpAssigned = true
+		}
+		exposes = append(exposes, ExposePort{
+			Port:     int32(port.Target),
+			As:       int32(port.Target),
+			Global:   true,
+			Protocol: protocol,
+		})
+	}
+	for _, expose := range service.Expose {
+		port, proto, _ := strings.Cut(expose, "/")
+		number, err := strconv.ParseInt(port, 10, 32)
+		if err != nil {
+			continue
+		}
+		protocol := ExposeTcp
+		if proto == ExposeUdp {
+			protocol = ExposeUdp
+		}
+		key := fmt.Sprintf("%s/%d", protocol, number)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		exposes = append(exposes, ExposePort{
+			Port:     int32(number),
+			As:       int32(number),
+			Protocol: protocol,
+		})
+	}
+	return exposes
+}
+
+// composeProfile converts deploy.resources into a compute profile, the limits
+// are used and the reservations when no limit is set.
+func composeProfile(resources composetypes.Resources) (*Compute, error) {
+	res := resources.Limits
+	if res == nil {
+		res = resources.Reservations
+	}
+	if res == nil {
+		return nil, nil
+	}
+
+	var profile Compute
+	if res.NanoCPUs > 0 {
+		profile.Resources.Cpu.Units = strconv.FormatFloat(float64(res.NanoCPUs), 'f', -1, 32)
+	}
+	if res.MemoryBytes > 0 {
+		profile.Resources.Memory.Size = strconv.FormatInt(int64(res.MemoryBytes), 10)
+	}
+	var gpus int64
+	for _, r := range []*composetypes.Resource{resources.Limits, resources.Reservations} {
+		if r == nil {
+			continue
+		}
+		for _, device := range r.Devices {
+			if isGpuDevice(device) && int64(device.Count) > gpus {
+				gpus = int64(device.Count)
+			}
+		}
+	}
+	if gpus > 0 {
+		profile.Resources.Gpu.Units = strconv.FormatInt(gpus, 10)
+	}
+	if _, err := profile.ResourceList(); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// validateCompose reports the problems of a compose file as ValidationErrors.
+func validateCompose(composeFilePath string) error {
+	project, err := loadComposeProject(composeFilePath)
+	if err != nil {
+		return ValidationErrors{{Message: err.Error()}}
+	}
+	containers, err := ComposeToK8sResource(project)
+	if err != nil {
+		if unsupportedErr, ok := err.(*UnsupportedComposeError); ok {
+			var errs ValidationErrors
+			for _, feature := range unsupportedErr.Features {
+				errs = append(errs, ValidationError{Message: feature})
+			}
+			return errs
+		}
+		return ValidationErrors{{Message: err.Error()}}
+	}
+
+	var errs ValidationErrors
+	for _, container := range containers {
+		var dependencies []string
+		for _, depend := range container.Depends {
+			dependencies = append(dependencies, depend.Name)
+		}
+		for _, service := range append(container.Depends, container) {
+			for _, placeholder := range checkPlaceholders(service, dependencies) {
+				errs = append(errs, ValidationError{Message: fmt.Sprintf("service %q uses an unknown placeholder %s", service.Name, placeholder)})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
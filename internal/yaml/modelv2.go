@@ -1,9 +1,16 @@
 package yaml
 
 import (
+	"fmt"
 	"gopkg.in/errgo.v2/errors"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"path"
+	"strconv"
 	"strings"
+	"unicode"
 )
 
 type DeployYamlV2 struct {
@@ -13,161 +20,24 @@ type DeployYamlV2 struct {
 	Deployment map[string]Deployment `yaml:"deployment"`
 }
 
-func (dy *DeployYamlV2) checkRequired() error {
-	if len(dy.Services) <= 0 {
-		return errors.New("at least one service must be defined")
-	}
-	return nil
-}
-
-func (dy *DeployYamlV2) ServiceToK8sResource() ([]ContainerResource, error) {
-	if err := dy.checkRequired(); err != nil {
-		return nil, err
-	}
-	var containers []ContainerResource
-	var waitDelete []string
-
-	for name, deployment := range dy.Deployment {
-		containerNew := new(ContainerResource)
-		if service, ok := dy.Services[name]; ok {
-			containerNew.Name = name
-
-			var depends []ContainerResource
-			for _, depend := range service.DependsOn {
-				if service, ok := dy.Services[depend]; ok {
-					container := new(ContainerResource)
-					container.Name = depend
-					container.ImageName = service.Image
-					if len(service.Command) > 0 {
-						container.Command = service.Command
-					}
-					if len(service.Args) > 0 {
-						container.Args = service.Args
-					}
-					if len(service.Env) > 0 {
-						var envVars []corev1.EnvVar
-						for _, env := range service.Env {
-							envSplit := strings.Split(strings.TrimSpace(env), "=")
-							envVars = append(envVars, corev1.EnvVar{
-								Name:  envSplit[0],
-								Value: envSplit[1],
-							})
-						}
-						container.Env = envVars
-					}
-					if len(service.Expose) > 0 {
-						var ports []corev1.ContainerPort
-						for _, expose := range service.Expose {
-							ports = append(ports, corev1.ContainerPort{
-								ContainerPort: int32(expose.Port),
-								Protocol:      getProtocol(expose.Protocol),
-							})
-						}
-						container.Ports = ports
-					}
-
-					if service.Config.Name != "" && service.Config.Path != "" {
-						container.VolumeMounts = ConfigFile{
-							Name: service.Config.Name,
-							Path: service.Config.Path,
-						}
-					}
-
-					if len(service.ReadyCmd) > 0 {
-						container.ReadyCmd = service.ReadyCmd
-					}
-
-					if deployment.Akash.Count != 0 {
-						container.Count = deployment.Akash.Count
-					}
-					if deployment.Lagrange.Count != 0 {
-						container.Count = deployment.Lagrange.Count
-					}
-
-					depends = append(depends, *container)
-					waitDelete = append(waitDelete, depend)
-				}
-			}
-			containerNew.Depends = depends
-			containerNew.ImageName = service.Image
-			if len(service.Command) > 0 {
-				containerNew.Command = service.Command
-			}
-			if len(service.Args) > 0 {
-				containerNew.Args = service.Args
-			}
-			if len(service.Env) > 0 {
-				var envVars []corev1.EnvVar
-				for _, env := range service.Env {
-					envSplit := strings.Split(strings.TrimSpace(env), "=")
-					envVars = append(envVars, corev1.EnvVar{
-						Name:  envSplit[0],
-						Value: envSplit[1],
-					})
-				}
-				containerNew.Env = envVars
-			}
-			if len(service.Expose) > 0 {
-				var ports []corev1.ContainerPort
-				for _, expose := range service.Expose {
-					ports = append(ports, corev1.ContainerPort{
-						ContainerPort: int32(expose.Port),
-						Protocol:      getProtocol(expose.Protocol),
-					})
-				}
-				containerNew.Ports = ports
-			}
-
-			if service.Config.Name != "" && service.Config.Path != "" {
-				containerNew.VolumeMounts = ConfigFile{
-					Name: service.Config.Name,
-					Path: service.Config.Path,
-				}
-			}
-			containerNew.Models = service.Models
-		}
-
-		containerNew.ResourceLimit = make(corev1.ResourceList)
-		if deployment.Akash.Count != 0 {
-			containerNew.Count = deployment.Akash.Count
-		}
-		if deployment.Lagrange.Count != 0 {
-			containerNew.Count = deployment.Lagrange.Count
-		}
-		containers = append(containers, *containerNew)
-	}
-
-	var result []ContainerResource
-	for _, c := range containers {
-		var flag bool
-		for _, needToDel := range waitDelete {
-			if c.Name == needToDel {
-				flag = true
-				break
-			}
-		}
-		if !flag {
-			result = append(result, c)
-		}
-	}
-
-	return result, nil
+type Service struct {
+	Name       string
+	Image      string            `yaml:"image"`
+	Command    []string          `yaml:"command"`
+	Args       []string          `yaml:"args"`
+	Env        Env               `yaml:"env"`
+	Secrets    Env               `yaml:"secrets"`
+	Expose     []Expose          `yaml:"expose"`
+	DependsOn  []string          `yaml:"depends-on"`
+	Config     ConfigEntries     `yaml:"config"`
+	ReadyCmd   []string          `yaml:"ready-cmd"`
+	Models     []ModelResource   `yaml:"models"`
+	Persistent []PersistentMount `yaml:"persistent"`
 }
 
-type Service struct {
-	Name      string
-	Image     string   `yaml:"image"`
-	Command   []string `yaml:"command"`
-	Args      []string `yaml:"args"`
-	Env       []string `yaml:"env"`
-	Expose    []Expose `yaml:"expose"`
-	DependsOn []string `yaml:"depends-on"`
-	Config    struct {
-		Name string `yaml:"name"`
-		Path string `yaml:"path"`
-	} `yaml:"config"`
-	ReadyCmd []string        `yaml:"ready-cmd"`
-	Models   []ModelResource `yaml:"models"`
+type PersistentMount struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"mount"`
 }
 
 type Expose struct {
@@ -202,6 +72,51 @@ type Compute struct {
 	} `yaml:"resources"`
 }
 
+// ResourceList returns the container resources of the compute profile, the
+// resources the profile leaves out are not part of the list.
+func (c Compute) ResourceList() (corev1.ResourceList, error) {
+	resources := make(corev1.ResourceList)
+	if units := strings.TrimSpace(c.Resources.Cpu.Units); units != "" {
+		quantity, err := resource.ParseQuantity(units)
+		if err != nil || quantity.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid cpu units %q", units)
+		}
+		resources[corev1.ResourceCPU] = quantity
+	}
+	if size := strings.TrimSpace(c.Resources.Memory.Size); size != "" {
+		quantity, err := parseSize(size)
+		if err != nil || quantity.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid memory size %q", size)
+		}
+		resources[corev1.ResourceMemory] = quantity
+	}
+	if size := strings.TrimSpace(c.Resources.Storage.Size); size != "" {
+		quantity, err := parseSize(size)
+		if err != nil || quantity.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid storage size %q", size)
+		}
+		resources[corev1.ResourceEphemeralStorage] = quantity
+	}
+	if units := strings.TrimSpace(c.Resources.Gpu.Units); units != "" {
+		count, err := strconv.ParseInt(units, 10, 64)
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("invalid gpu units %q", units)
+		}
+		if count > 0 {
+			resources[ResourceGpu] = *resource.NewQuantity(count, resource.DecimalSI)
+		}
+	}
+	return resources, nil
+}
+
+// parseSize parses a size like "512Mi" or "1Gi", the "B" of "GB" or "GiB" is allowed.
+func parseSize(size string) (resource.Quantity, error) {
+	if len(size) > 1 && (strings.HasSuffix(size, "B") || strings.HasSuffix(size, "b")) && unicode.IsLetter(rune(size[len(size)-2])) {
+		size = size[:len(size)-1]
+	}
+	return resource.ParseQuantity(size)
+}
+
 type Deployment struct {
 	Akash struct {
 		Profile string `yaml:"profile"`
@@ -213,9 +128,162 @@ type Deployment struct {
 	} `yaml:"lagrange"`
 }
 
+// Env holds environment variables as NAME=VALUE entries. deploy.yaml may write
+// them as a list of entries or as a mapping of names to values.
+type Env []string
+
+func (e *Env) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		*e = list
+		return nil
+	}
+
+	// the mapping keeps the order of the entries, so that $(VAR) references
+	// to earlier entries resolve, and the text of the values: 1.10 stays
+	// "1.10" and yes stays "yes"
+	var values map[string]string
+	if err := unmarshal(&values); err != nil {
+		return errors.New("env must be a list of NAME=VALUE or a mapping of names to scalars")
+	}
+	var names []string
+	var order yaml.MapSlice
+	if err := unmarshal(&order); err == nil {
+		for _, item := range order {
+			names = append(names, fmt.Sprint(item.Key))
+		}
+	} else {
+		// the validator decodes with yaml.v3, which has no MapSlice
+		var node yamlv3.Node
+		if err = unmarshal(&node); err != nil {
+			return errors.New("env must be a list of NAME=VALUE or a mapping of names to scalars")
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			names = append(names, node.Content[i].Value)
+		}
+	}
+
+	entries := make(Env, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, name+"="+values[name])
+	}
+	*e = entries
+	return nil
+}
+
+// ConfigEntry mounts a file or a directory next to the deploy.yaml into the
+// container. A file is mounted at target, the files of a directory below it.
+// Mode holds the octal permission bits of the mounted files.
+type ConfigEntry struct {
+	Source string `yaml:"source"`
+	Target string `yaml:"target"`
+	Mode   string `yaml:"mode"`
+}
+
+// ConfigEntries is the config of a service, a list of entries or the single
+// {name, path} mapping of older deploy.yaml files, which mounts the file name
+// into the directory path.
+type ConfigEntries []ConfigEntry
+
+type legacyConfig struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"path"`
+}
+
+func (c *ConfigEntries) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var entries []ConfigEntry
+	if err := unmarshal(&entries); err == nil {
+		*c = entries
+		return nil
+	}
+
+	var legacy legacyConfig
+	if err := unmarshal(&legacy); err != nil {
+		return errors.New("config must be a list of {source, target, mode} or a {name, path} mapping")
+	}
+	*c = nil
+	if legacy.Name != "" && legacy.Path != "" {
+		*c = ConfigEntries{{Source: legacy.Name, Target: path.Join(legacy.Path, path.Base(legacy.Name))}}
+	}
+	return nil
+}
+
+// configFiles converts the config entries of a service.
+func configFiles(entries ConfigEntries) ([]ConfigFile, error) {
+	var files []ConfigFile
+	for _, entry := range entries {
+		file := ConfigFile{Name: entry.Source, Path: entry.Target}
+		if entry.Mode != "" {
+			mode, err := strconv.ParseInt(entry.Mode, 8, 32)
+			if err != nil || mode < 0 || mode > 0777 {
+				return nil, fmt.Errorf("invalid config mode %q", entry.Mode)
+			}
+			fileMode := int32(mode)
+			file.Mode = &fileMode
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// parseEnv converts NAME=VALUE entries into env vars, the value may contain "=".
+func parseEnv(envs []string) ([]corev1.EnvVar, error) {
+	var envVars []corev1.EnvVar
+	for _, env := range envs {
+		name, value, ok := strings.Cut(strings.TrimSpace(env), "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid env %q, expected NAME=VALUE", env)
+		}
+		envVars = append(envVars, corev1.EnvVar{
+			Name:  name,
+			Value: value,
+		})
+	}
+	return envVars, nil
+}
+
+// exposePorts resolves the service port and the visibility of the exposed
+// ports. Services that do not use "to" at all keep the old behavior, their
+// first port is published through the ingress.
+func exposePorts(exposes []Expose) []ExposePort {
+	var legacy = true
+	for _, expose := range exposes {
+		if len(expose.To) > 0 {
+			legacy = false
+			break
+		}
+	}
+
+	var ports []ExposePort
+	for i, expose := range exposes {
+		port := ExposePort{
+			Port:     int32(expose.Port),
+			As:       int32(expose.As),
+			Protocol: strings.ToLower(expose.Protocol),
+		}
+		if port.As == 0 {
+			port.As = port.Port
+		}
+		for _, to := range expose.To {
+			port.Global = port.Global || to.Global
+		}
+		if port.Protocol == "" {
+			port.Protocol = ExposeHttp
+		}
+		if legacy && i == 0 {
+			port.Global = true
+			if port.Protocol != ExposeUdp {
+				port.Protocol = ExposeHttp
+			}
+		}
+		ports = append(ports, port)
+	}
+	return ports
+}
+
 func getProtocol(proto string) corev1.Protocol {
 	var result corev1.Protocol
-	switch proto {
+	switch strings.ToLower(proto) {
 	case "tcp":
 		result = corev1.ProtocolTCP
 	case "udp":
@@ -227,7 +295,8 @@ func getProtocol(proto string) corev1.Protocol {
 }
 
 type ModelResource struct {
-	Name string `yaml:"name"`
-	Url  string `yaml:"url"`
-	Dir  string `yaml:"dir"`
+	Name   string `yaml:"name"`
+	Url    string `yaml:"url"`
+	Dir    string `yaml:"dir"`
+	Sha256 string `yaml:"sha256"`
 }
@@ -0,0 +1,89 @@
+package yaml
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size string
+		want string
+	}{
+		{"512Mi", "512Mi"},
+		{"1Gi", "1Gi"},
+		{"1GiB", "1Gi"},
+		{"2GB", "2G"},
+		{"2gb", ""},
+		{"100", "100"},
+		{"B", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		quantity, err := parseSize(tt.size)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("parseSize(%q) = %s, want an error", tt.size, quantity.String())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSize(%q) failed: %v", tt.size, err)
+			continue
+		}
+		if want := resource.MustParse(tt.want); quantity.Cmp(want) != 0 {
+			t.Errorf("parseSize(%q) = %s, want %s", tt.size, quantity.String(), tt.want)
+		}
+	}
+}
+
+func TestComputeResourceList(t *testing.T) {
+	var profile Compute
+	profile.Resources.Cpu.Units = "0.5"
+	profile.Resources.Memory.Size = "512MiB"
+	profile.Resources.Storage.Size = "10Gi"
+	profile.Resources.Gpu.Units = "1"
+	resources, err := profile.ResourceList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := corev1.ResourceList{
+		corev1.ResourceCPU:              resource.MustParse("500m"),
+		corev1.ResourceMemory:           resource.MustParse("512Mi"),
+		corev1.ResourceEphemeralStorage: resource.MustParse("10Gi"),
+		ResourceGpu:                     resource.MustParse("1"),
+	}
+	if len(resources) != len(want) {
+		t.Fatalf("unexpected resources: %v", resources)
+	}
+	for name, quantity := range want {
+		if got := resources[name]; got.Cmp(quantity) != 0 {
+			t.Errorf("%s = %s, want %s", name, got.String(), quantity.String())
+		}
+	}
+
+	// the resources left out of the profile are not part of the list
+	var cpuOnly Compute
+	cpuOnly.Resources.Cpu.Units = "2"
+	cpuOnly.Resources.Gpu.Units = "0"
+	if resources, err = cpuOnly.ResourceList(); err != nil || len(resources) != 1 {
+		t.Fatalf("unexpected resources of a cpu profile: %v, error: %v", resources, err)
+	}
+
+	for _, invalid := range []func(*Compute){
+		func(c *Compute) { c.Resources.Cpu.Units = "0" },
+		func(c *Compute) { c.Resources.Cpu.Units = "two" },
+		func(c *Compute) { c.Resources.Memory.Size = "-1Gi" },
+		func(c *Compute) { c.Resources.Storage.Size = "10 GB" },
+		func(c *Compute) { c.Resources.Gpu.Units = "-1" },
+		func(c *Compute) { c.Resources.Gpu.Units = "0.5" },
+	} {
+		var profile Compute
+		invalid(&profile)
+		if resources, err := profile.ResourceList(); err == nil {
+			t.Errorf("expected an error for %+v, got %v", profile.Resources, resources)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package yaml
+
+import (
+	"fmt"
+
+	"gopkg.in/errgo.v2/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// legacyReadyCmdDelay is the delay and period of the readiness probe that v2
+// builds from the ready-cmd of a dependency.
+const legacyReadyCmdDelay = 5
+
+type DeployYamlV3 struct {
+	Version    string                `yaml:"version"`
+	Services   map[string]ServiceV3  `yaml:"services"`
+	Profiles   Profiles              `yaml:"profiles"`
+	Deployment map[string]Deployment `yaml:"deployment"`
+}
+
+// ServiceV3 extends the v2 service with probes, init containers, the process
+// settings and the termination settings of the container. A standalone
+// dependency runs in its own Deployment instead of the pod of the service
+// depending on it.
+type ServiceV3 struct {
+	Service     `yaml:",inline"`
+	WorkingDir  string          `yaml:"working-dir"`
+	User        *int64          `yaml:"user"`
+	Group       *int64          `yaml:"group"`
+	Probes      Probes          `yaml:"probes"`
+	Init        []InitContainer `yaml:"init"`
+	Termination Termination     `yaml:"termination"`
+	Standalone  bool            `yaml:"standalone"`
+}
+
+type Probes struct {
+	Liveness  *Probe `yaml:"liveness"`
+	Readiness *Probe `yaml:"readiness"`
+	Startup   *Probe `yaml:"startup"`
+}
+
+// Probe checks the container with exactly one of http, tcp or exec, the
+// timings are in seconds and fall back to the k8s defaults when unset.
+type Probe struct {
+	Http             *HttpProbe `yaml:"http"`
+	Tcp              *TcpProbe  `yaml:"tcp"`
+	Exec             []string   `yaml:"exec"`
+	InitialDelay     int32      `yaml:"initial-delay"`
+	Period           int32      `yaml:"period"`
+	Timeout          int32      `yaml:"timeout"`
+	FailureThreshold int32      `yaml:"failure-threshold"`
+	SuccessThreshold int32      `yaml:"success-threshold"`
+}
+
+type HttpProbe struct {
+	Path   string `yaml:"path"`
+	Port   int    `yaml:"port"`
+	Scheme string `yaml:"scheme"`
+}
+
+type TcpProbe struct {
+	Port int `yaml:"port"`
+}
+
+// InitContainer runs to completion before the containers of the service start.
+// With restart "always" it keeps running next to them as a sidecar, a sidecar
+// needs a compute profile since it is paid next to the services of the pod.
+type InitContainer struct {
+	Name       string   `yaml:"name"`
+	Image      string   `yaml:"image"`
+	Command    []string `yaml:"command"`
+	Args       []string `yaml:"args"`
+	Env        Env      `yaml:"env"`
+	WorkingDir string   `yaml:"working-dir"`
+	Restart    string   `yaml:"restart"`
+	Profile    string   `yaml:"profile"`
+}
+
+type Termination struct {
+	GracePeriod *int64   `yaml:"grace-period"`
+	PreStop     []string `yaml:"pre-stop"`
+}
+
+func (dy *DeployYamlV3) checkRequired() error {
+	if len(dy.Services) <= 0 {
+		return errors.New("at least one service must be defined")
+	}
+	return nil
+}
+
+func (dy *DeployYamlV3) ServiceToK8sResource() ([]ContainerResource, error) {
+	if err := dy.checkRequired(); err != nil {
+		return nil, err
+	}
+	var containers []ContainerResource
+	var waitDelete []string
+
+	for name, deployment := range dy.Deployment {
+		containerNew := new(ContainerResource)
+		if _, ok := dy.Services[name]; ok {
+			var err error
+			if containerNew, err = dy.serviceToContainer(name); err != nil {
+				return nil, err
+			}
+
+			var depends []ContainerResource
+			for _, depend := range dy.Services[name].DependsOn {
+				if _, ok := dy.Services[depend]; ok {
+					container, err := dy.serviceToContainer(depend)
+					if err != nil {
+						return nil, err
+					}
+					container.Models = nil
+					countFrom := deployment
+					if container.Standalone {
+						// a standalone dependency scales with its own deployment
+						countFrom = dy.Deployment[depend]
+						container.Count = 1
+					}
+					if countFrom.Akash.Count != 0 {
+						container.Count = countFrom.Akash.Count
+					}
+					if countFrom.Lagrange.Count != 0 {
+						container.Count = countFrom.Lagrange.Count
+					}
+
+					depends = append(depends, *container)
+					waitDelete = append(waitDelete, depend)
+				}
+			}
+			containerNew.Depends = depends
+		}
+
+		containerNew.ResourceLimit = make(corev1.ResourceList)
+		if deployment.Akash.Count != 0 {
+			containerNew.Count = deployment.Akash.Count
+		}
+		if deployment.Lagrange.Count != 0 {
+			containerNew.Count = deployment.Lagrange.Count
+		}
+		containers = append(containers, *containerNew)
+	}
+
+	var result []ContainerResource
+	for _, c := range containers {
+		var flag bool
+		for _, needToDel := range waitDelete {
+			if c.Name == needToDel {
+				flag = true
+				break
+			}
+		}
+		if !flag {
+			result = append(result, c)
+		}
+	}
+
+	return result, nil
+}
+
+func (dy *DeployYamlV3) serviceToContainer(name string) (*ContainerResource, error) {
+	service := dy.Services[name]
+	container := &ContainerResource{
+		Name:       name,
+		ImageName:  service.Image,
+		WorkingDir: service.WorkingDir,
+		RunAsUser:  service.User,
+		RunAsGroup: service.Group,
+		Persistent: service.Persistent,
+		Models:     service.Models,
+		ReadyCmd:   service.ReadyCmd,
+		PreStop:    service.Termination.PreStop,
+		Standalone: service.Standalone,
+	}
+	if len(service.Command) > 0 {
+		container.Command = service.Command
+	}
+	if len(service.Args) > 0 {
+		container.Args = service.Args
+	}
+
+	envVars, err := parseEnv(service.Env)
+	if err != nil {
+		return nil, fmt.Errorf("service %s, %w", name, err)
+	}
+	container.Env = envVars
+	secrets, err := parseEnv(service.Secrets)
+	if err != nil {
+		return nil, fmt.Errorf("service %s, secrets: %w", name, err)
+	}
+	container.Secrets = secrets
+
+	if len(service.Expose) > 0 {
+		var ports []corev1.ContainerPort
+		for _, expose := range service.Expose {
+			ports = append(ports, corev1.ContainerPort{
+				ContainerPort: int32(expose.Port),
+				Protocol:      getProtocol(expose.Protocol),
+			})
+		}
+		container.Ports = ports
+		container.Exposes = exposePorts(service.Expose)
+	}
+
+	if container.VolumeMounts, err = configFiles(service.Config); err != nil {
+		return nil, fmt.Errorf("service %s, %w", name, err)
+	}
+
+	if container.LivenessProbe, err = service.Probes.Liveness.toK8s(); err != nil {
+		return nil, fmt.Errorf("service %s, liveness probe: %w", name, err)
+	}
+	if container.ReadinessProbe, err = service.Probes.Readiness.toK8s(); err != nil {
+		return nil, fmt.Errorf("service %s, readiness probe: %w", name, err)
+	}
+	if container.StartupProbe, err = service.Probes.Startup.toK8s(); err != nil {
+		return nil, fmt.Errorf("service %s, startup probe: %w", name, err)
+	}
+
+	for _, init := range service.Init {
+		initEnv, err := parseEnv(init.Env)
+		if err != nil {
+			return nil, fmt.Errorf("service %s, init container %s, %w", name, init.Name, err)
+		}
+		initResource := InitResource{
+			Name:       init.Name,
+			ImageName:  init.Image,
+			Command:    init.Command,
+			Args:       init.Args,
+			Env:        initEnv,
+			WorkingDir: init.WorkingDir,
+			Sidecar:    init.Restart == "always",
+		}
+		if init.Profile != "" {
+			profile, ok := dy.Profiles.Compute[init.Profile]
+			if !ok {
+				return nil, fmt.Errorf("service %s, init container %s uses an undefined compute profile %s", name, init.Name, init.Profile)
+			}
+			initResource.Profile = &profile
+		}
+		container.InitContainers = append(container.InitContainers, initResource)
+	}
+
+	if grace := service.Termination.GracePeriod; grace != nil {
+		if *grace < 0 {
+			return nil, fmt.Errorf("service %s, termination grace-period must not be negative", name)
+		}
+		container.TerminationGracePeriod = grace
+	}
+
+	profile, err := dy.profileOf(name)
+	if err != nil {
+		return nil, err
+	}
+	container.Profile = profile
+	return container, nil
+}
+
+// profileOf returns the compute profile the deployment of the service uses,
+// it is nil if the deployment has no profile.
+func (dy *DeployYamlV3) profileOf(service string) (*Compute, error) {
+	deployment, ok := dy.Deployment[service]
+	if !ok {
+		return nil, nil
+	}
+	name := deployment.Lagrange.Profile
+	if name == "" {
+		name = deployment.Akash.Profile
+	}
+	if name == "" {
+		return nil, nil
+	}
+	profile, ok := dy.Profiles.Compute[name]
+	if !ok {
+		return nil, fmt.Errorf("service %s uses an undefined compute profile %s", service, name)
+	}
+	return &profile, nil
+}
+
+func (p *Probe) toK8s() (*corev1.Probe, error) {
+	if p == nil {
+		return nil, nil
+	}
+	probe := &corev1.Probe{
+		InitialDelaySeconds: p.InitialDelay,
+		PeriodSeconds:       p.Period,
+		TimeoutSeconds:      p.Timeout,
+		FailureThreshold:    p.FailureThreshold,
+		SuccessThreshold:    p.SuccessThreshold,
+	}
+	var handlers int
+	if p.Http != nil {
+		handlers++
+		scheme := corev1.URISchemeHTTP
+		if p.Http.Scheme == "https" {
+			scheme = corev1.URISchemeHTTPS
+		}
+		path := p.Http.Path
+		if path == "" {
+			path = "/"
+		}
+		probe.HTTPGet = &corev1.HTTPGetAction{
+			Path:   path,
+			Port:   intstr.FromInt(p.Http.Port),
+			Scheme: scheme,
+		}
+	}
+	if p.Tcp != nil {
+		handlers++
+		probe.TCPSocket = &corev1.TCPSocketAction{Port: intstr.FromInt(p.Tcp.Port)}
+	}
+	if len(p.Exec) > 0 {
+		handlers++
+		probe.Exec = &corev1.ExecAction{Command: p.Exec}
+	}
+	if handlers != 1 {
+		return nil, fmt.Errorf("exactly one of http, tcp or exec must be set")
+	}
+	return probe, nil
+}
+
+// ToV3 upgrades a v2 document. The ready-cmd of a dependency becomes the
+// readiness probe v2 always created for it, so the space deploys unchanged.
+func (dy DeployYamlV2) ToV3() DeployYamlV3 {
+	dependencies := make(map[string]bool)
+	for _, service := range dy.Services {
+		for _, depend := range service.DependsOn {
+			dependencies[depend] = true
+		}
+	}
+
+	v3 := DeployYamlV3{
+		Version:    "3.0",
+		Services:   make(map[string]ServiceV3, len(dy.Services)),
+		Profiles:   dy.Profiles,
+		Deployment: dy.Deployment,
+	}
+	for name, service := range dy.Services {
+		serviceV3 := ServiceV3{Service: service}
+		if len(service.ReadyCmd) > 0 && dependencies[name] {
+			serviceV3.Probes.Readiness = &Probe{
+				Exec:         service.ReadyCmd,
+				InitialDelay: legacyReadyCmdDelay,
+				Period:       legacyReadyCmdDelay,
+			}
+		}
+		serviceV3.ReadyCmd = nil
+		v3.Services[name] = serviceV3
+	}
+	return v3
+}
@@ -14,18 +14,68 @@ type ContainerResource struct {
 	Command       []string
 	Args          []string
 	Env           []corev1.EnvVar
+	Secrets       []corev1.EnvVar
 	Ports         []corev1.ContainerPort
+	Exposes       []ExposePort
 	ResourceLimit corev1.ResourceList
-	VolumeMounts  ConfigFile
+	VolumeMounts  []ConfigFile
 	Depends       []ContainerResource
 	ReadyCmd      []string
 	GpuModel      string
 	Models        []ModelResource
+	Persistent    []PersistentMount
+	Profile       *Compute
+
+	WorkingDir             string
+	RunAsUser              *int64
+	RunAsGroup             *int64
+	LivenessProbe          *corev1.Probe
+	ReadinessProbe         *corev1.Probe
+	StartupProbe           *corev1.Probe
+	InitContainers         []InitResource
+	PreStop                []string
+	TerminationGracePeriod *int64
+	Standalone             bool
+}
+
+// InitResource is an init container of a service, sidecars keep running
+// next to the containers of the pod.
+type InitResource struct {
+	Name       string
+	ImageName  string
+	Command    []string
+	Args       []string
+	Env        []corev1.EnvVar
+	WorkingDir string
+	Sidecar    bool
+	Profile    *Compute
+}
+
+const (
+	ExposeHttp = "http"
+	ExposeTcp  = "tcp"
+	ExposeUdp  = "udp"
+)
+
+// ExposePort is an exposed port of a service. Port is the container port and
+// As the port of the k8s Service. Global ports are published, http ones through
+// the ingress and tcp/udp ones through a NodePort.
+type ExposePort struct {
+	Port     int32
+	As       int32
+	Global   bool
+	Protocol string
 }
 
+// ResourceGpu is the resource name of the nvidia device plugin.
+const ResourceGpu corev1.ResourceName = "nvidia.com/gpu"
+
+// ConfigFile is a file or a directory of the space, Name is its path relative
+// to the deploy.yaml and Path where it is mounted in the container.
 type ConfigFile struct {
 	Name string
 	Path string
+	Mode *int32
 }
 
 type Parser interface {
@@ -50,6 +100,23 @@ func (p *ParserYamlV2) GetConfig() interface{} {
 	return p.config
 }
 
+type ParserYamlV3 struct {
+	config DeployYamlV3
+}
+
+func (p *ParserYamlV3) Parse(yamlFile []byte) error {
+	var deploy DeployYamlV3
+	if err := yaml.Unmarshal(yamlFile, &deploy); err != nil {
+		return err
+	}
+	p.config = deploy
+	return nil
+}
+
+func (p *ParserYamlV3) GetConfig() interface{} {
+	return p.config
+}
+
 type Version struct {
 	Version string `yaml:"version"`
 }
@@ -64,6 +131,10 @@ func getYAMLFileVersion(yamlFile []byte) (string, error) {
 }
 
 func HandlerYaml(yamlFilePath string) ([]ContainerResource, error) {
+	if IsComposeFile(yamlFilePath) {
+		return HandlerCompose(yamlFilePath)
+	}
+
 	yamlFile, err := os.ReadFile(yamlFilePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed unable to read file, %w", err)
@@ -77,12 +148,22 @@ func HandlerYaml(yamlFilePath string) ([]ContainerResource, error) {
 		if err = parser.Parse(yamlFile); err != nil {
 			return nil, fmt.Errorf("failed unable to parse YAML file, %w", err)
 		}
+		v3 := parser.config.ToV3()
+		containerResources, err = v3.ServiceToK8sResource()
+		if err != nil {
+			return nil, fmt.Errorf("failed unable to parse YAML file for k8s, %w", err)
+		}
+	case "3.0":
+		parser := &ParserYamlV3{}
+		if err = parser.Parse(yamlFile); err != nil {
+			return nil, fmt.Errorf("failed unable to parse YAML file, %w", err)
+		}
 		containerResources, err = parser.config.ServiceToK8sResource()
 		if err != nil {
 			return nil, fmt.Errorf("failed unable to parse YAML file for k8s, %w", err)
 		}
 	default:
-		return nil, fmt.Errorf("not support yaml version: %d", version)
+		return nil, fmt.Errorf("not support yaml version: %q", version)
 	}
 	return containerResources, err
 }
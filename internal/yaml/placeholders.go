@@ -0,0 +1,127 @@
+package yaml
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The placeholders a space can use in the env, command, args and config files
+// of its services, the cp expands them when it deploys the space. A
+// dependency is reached through ${<NAME>_HOST}, "my-db" is ${MY_DB_HOST}.
+// $${NAME} is kept as ${NAME}.
+const (
+	PlaceholderSpaceUrl      = "SPACE_URL"
+	PlaceholderSpaceUuid     = "SPACE_UUID"
+	PlaceholderWalletAddress = "WALLET_ADDRESS"
+	PlaceholderJobUuid       = "JOB_UUID"
+	PlaceholderResultHost    = "RESULT_HOST"
+	PlaceholderExpireAt      = "EXPIRE_AT"
+)
+
+var spacePlaceholders = []string{
+	PlaceholderSpaceUrl,
+	PlaceholderSpaceUuid,
+	PlaceholderWalletAddress,
+	PlaceholderJobUuid,
+	PlaceholderResultHost,
+	PlaceholderExpireAt,
+}
+
+var placeholderPattern = regexp.MustCompile(`\$?\$\{([^}]*)\}`)
+
+// EnvName turns a service name into an env prefix, "my-db" becomes "MY_DB".
+func EnvName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// DependencyHostPlaceholder is the placeholder of the host of a dependency.
+func DependencyHostPlaceholder(dependency string) string {
+	return EnvName(dependency) + "_HOST"
+}
+
+// knownPlaceholders returns the placeholders a service with the given
+// dependencies can use.
+func knownPlaceholders(dependencies []string) map[string]bool {
+	known := make(map[string]bool)
+	for _, name := range spacePlaceholders {
+		known[name] = true
+	}
+	for _, dependency := range dependencies {
+		known[DependencyHostPlaceholder(dependency)] = true
+	}
+	return known
+}
+
+// unknownPlaceholders returns the placeholders in s that are not known.
+func unknownPlaceholders(s string, known map[string]bool) []string {
+	var unknown []string
+	for _, match := range placeholderPattern.FindAllStringSubmatch(s, -1) {
+		if strings.HasPrefix(match[0], "$$") || known[match[1]] {
+			continue
+		}
+		unknown = append(unknown, match[0])
+	}
+	return unknown
+}
+
+// ExpandPlaceholders replaces the placeholders in s with their values,
+// placeholders without a value are kept.
+func ExpandPlaceholders(s string, values map[string]string) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+	return placeholderPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if strings.HasPrefix(match, "$$") {
+			return match[1:]
+		}
+		if value, ok := values[match[2:len(match)-1]]; ok {
+			return value
+		}
+		return match
+	})
+}
+
+// isComposePlaceholder tells whether a variable of a compose file is a
+// placeholder, so that the compose interpolation keeps it for the cp.
+func isComposePlaceholder(name string) bool {
+	return knownPlaceholders(nil)[name] || strings.HasSuffix(name, "_HOST")
+}
+
+// checkPlaceholders returns the unknown placeholders of the env, command, args
+// and init containers of a service.
+func checkPlaceholders(service ContainerResource, dependencies []string) []string {
+	known := knownPlaceholders(dependencies)
+	values := append(append([]string{}, service.Command...), service.Args...)
+	for _, env := range service.Env {
+		values = append(values, env.Value)
+	}
+	for _, init := range service.InitContainers {
+		values = append(values, init.Command...)
+		values = append(values, init.Args...)
+		for _, env := range init.Env {
+			values = append(values, env.Value)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var unknown []string
+	for _, value := range values {
+		for _, placeholder := range unknownPlaceholders(value, known) {
+			if !seen[placeholder] {
+				seen[placeholder] = true
+				unknown = append(unknown, placeholder)
+			}
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
@@ -0,0 +1,790 @@
+package yaml
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	yamlv3 "gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// supportedVersions maps the deploy.yaml versions to the type they are parsed into.
+var supportedVersions = map[string]reflect.Type{
+	"2.0": reflect.TypeOf(DeployYamlV2{}),
+	"3.0": reflect.TypeOf(DeployYamlV3{}),
+}
+
+var syntaxErrorLine = regexp.MustCompile(`line (\d+)`)
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// maxStandaloneNameLength keeps the names of the service and the containers
+// of a standalone dependency, which contain the space uuid, in 63 characters.
+const maxStandaloneNameLength = 20
+
+// ValidationError is a problem found in a deploy.yaml, Line and Column are 1-based.
+type ValidationError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// ValidationErrors holds every problem of a deploy.yaml, ordered by position.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	var msgs []string
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// ValidateFile validates the deploy.yaml or the compose file at the given
+// path, the config files next to a deploy.yaml are checked as well.
+func ValidateFile(yamlFilePath string) error {
+	if IsComposeFile(yamlFilePath) {
+		return validateCompose(yamlFilePath)
+	}
+	yamlFile, err := os.ReadFile(yamlFilePath)
+	if err != nil {
+		return fmt.Errorf("failed unable to read file, %w", err)
+	}
+	return validate(yamlFile, filepath.Dir(yamlFilePath))
+}
+
+// Validate checks a deploy.yaml without deploying it. It returns nil or the
+// ValidationErrors with every problem found.
+func Validate(yamlFile []byte) error {
+	return validate(yamlFile, "")
+}
+
+// validate checks a deploy.yaml, the config files are read from baseDir unless
+// it is empty.
+func validate(yamlFile []byte, baseDir string) error {
+	v := &validator{baseDir: baseDir}
+	var root yamlv3.Node
+	if err := yamlv3.Unmarshal(yamlFile, &root); err != nil {
+		syntaxErr := ValidationError{Message: err.Error()}
+		if match := syntaxErrorLine.FindStringSubmatch(err.Error()); match != nil {
+			syntaxErr.Line, _ = strconv.Atoi(match[1])
+			syntaxErr.Column = 1
+		}
+		return ValidationErrors{syntaxErr}
+	}
+	if len(root.Content) == 0 {
+		return ValidationErrors{{Message: "the file is empty"}}
+	}
+
+	doc := root.Content[0]
+	if doc.Kind != yamlv3.MappingNode {
+		v.addf(doc, "the file must be a mapping")
+		return v.result()
+	}
+
+	_, versionNode := mappingValue(doc, "version")
+	if versionNode == nil {
+		v.addf(doc, "missing key \"version\"")
+		return v.result()
+	}
+	schema, ok := supportedVersions[versionNode.Value]
+	if !ok {
+		v.addf(versionNode, "not support yaml version: %q", versionNode.Value)
+		return v.result()
+	}
+
+	v.checkSchema(doc, schema, "")
+	switch schema {
+	case reflect.TypeOf(DeployYamlV2{}):
+		v.checkDeployYamlV2(doc)
+	case reflect.TypeOf(DeployYamlV3{}):
+		v.checkDeployYamlV3(doc)
+	}
+	return v.result()
+}
+
+type validator struct {
+	errs    ValidationErrors
+	baseDir string
+}
+
+func (v *validator) addf(node *yamlv3.Node, format string, args ...interface{}) {
+	v.errs = append(v.errs, ValidationError{
+		Line:    node.Line,
+		Column:  node.Column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) result() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		if v.errs[i].Line != v.errs[j].Line {
+			return v.errs[i].Line < v.errs[j].Line
+		}
+		return v.errs[i].Column < v.errs[j].Column
+	})
+	return v.errs
+}
+
+// checkSchema reports the keys that are not known to the parser and the
+// values that do not have the type it expects.
+func (v *validator) checkSchema(node *yamlv3.Node, t reflect.Type, path string) {
+	if node.Kind == yamlv3.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yamlv3.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == reflect.TypeOf(Env(nil)) {
+		v.checkEnvSchema(node, path)
+		return
+	}
+	if t == reflect.TypeOf(ConfigEntries(nil)) && node.Kind == yamlv3.MappingNode {
+		t = reflect.TypeOf(legacyConfig{})
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yamlv3.MappingNode {
+			v.addf(node, "%s must be a mapping", displayPath(path))
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, ok := fields[key.Value]
+			if !ok {
+				v.addf(key, "unknown key %q in %s", key.Value, displayPath(path))
+				continue
+			}
+			v.checkSchema(value, field.Type, joinPath(path, key.Value))
+		}
+	case reflect.Map:
+		if node.Kind != yamlv3.MappingNode {
+			v.addf(node, "%s must be a mapping", displayPath(path))
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v.checkSchema(node.Content[i+1], t.Elem(), joinPath(path, node.Content[i].Value))
+		}
+	case reflect.Slice:
+		if node.Kind != yamlv3.SequenceNode {
+			v.addf(node, "%s must be a list", displayPath(path))
+			return
+		}
+		for i, item := range node.Content {
+			v.checkSchema(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	default:
+		if node.Kind != yamlv3.ScalarNode {
+			v.addf(node, "%s must be a %s", displayPath(path), t.Kind())
+			return
+		}
+		if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			v.addf(node, "%s must be a %s, got %q", displayPath(path), t.Kind(), node.Value)
+		}
+	}
+}
+
+// checkEnvSchema accepts a list of NAME=VALUE entries or a mapping of names to
+// scalar values.
+func (v *validator) checkEnvSchema(node *yamlv3.Node, path string) {
+	switch node.Kind {
+	case yamlv3.SequenceNode:
+		for _, item := range node.Content {
+			if item.Kind != yamlv3.ScalarNode {
+				v.addf(item, "entries of %s must be NAME=VALUE", displayPath(path))
+			}
+		}
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i+1].Kind != yamlv3.ScalarNode {
+				v.addf(node.Content[i+1], "value of %s must be a scalar", displayPath(joinPath(path, node.Content[i].Value)))
+			}
+		}
+	default:
+		v.addf(node, "%s must be a list of NAME=VALUE or a mapping", displayPath(path))
+	}
+}
+
+func (v *validator) checkDeployYamlV2(doc *yamlv3.Node) {
+	var deploy DeployYamlV2
+	// type errors are already reported by checkSchema
+	_ = doc.Decode(&deploy)
+
+	_, servicesNode := mappingValue(doc, "services")
+	if servicesNode == nil || len(deploy.Services) == 0 {
+		v.addf(doc, "at least one service must be defined")
+	}
+	if servicesNode != nil && servicesNode.Kind == yamlv3.MappingNode {
+		for i := 0; i+1 < len(servicesNode.Content); i += 2 {
+			nameNode, serviceNode := servicesNode.Content[i], servicesNode.Content[i+1]
+			v.checkServiceV2(nameNode, serviceNode, deploy)
+		}
+		v.checkDependencyCycles(servicesNode)
+	}
+
+	_, profilesNode := mappingValue(doc, "profiles")
+	if _, computeNode := mappingValue(profilesNode, "compute"); computeNode != nil && computeNode.Kind == yamlv3.MappingNode {
+		for i := 0; i+1 < len(computeNode.Content); i += 2 {
+			nameNode := computeNode.Content[i]
+			if _, err := deploy.Profiles.Compute[nameNode.Value].ResourceList(); err != nil {
+				v.addf(nameNode, "compute profile %q has %v", nameNode.Value, err)
+			}
+		}
+	}
+
+	_, deploymentNode := mappingValue(doc, "deployment")
+	if deploymentNode == nil || len(deploy.Deployment) == 0 {
+		v.addf(doc, "at least one deployment must be defined")
+		return
+	}
+	if deploymentNode.Kind != yamlv3.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(deploymentNode.Content); i += 2 {
+		nameNode, placementsNode := deploymentNode.Content[i], deploymentNode.Content[i+1]
+		if _, ok := deploy.Services[nameNode.Value]; !ok {
+			v.addf(nameNode, "deployment %q references an undefined service", nameNode.Value)
+		}
+		for _, placement := range []string{"akash", "lagrange"} {
+			_, placementNode := mappingValue(placementsNode, placement)
+			if placementNode == nil {
+				continue
+			}
+			if profileKey, profileNode := mappingValue(placementNode, "profile"); profileNode != nil && profileNode.Value != "" {
+				if _, ok := deploy.Profiles.Compute[profileNode.Value]; !ok {
+					v.addf(profileNode, "%s of %q is an undefined compute profile %q", profileKey.Value, nameNode.Value, profileNode.Value)
+				}
+			}
+			if _, countNode := mappingValue(placementNode, "count"); countNode != nil {
+				if count, err := strconv.Atoi(countNode.Value); err == nil && count < 0 {
+					v.addf(countNode, "count of %q must not be negative", nameNode.Value)
+				}
+			}
+		}
+	}
+}
+
+func (v *validator) checkServiceV2(nameNode, serviceNode *yamlv3.Node, deploy DeployYamlV2) {
+	name := nameNode.Value
+	service := deploy.Services[name]
+	if strings.TrimSpace(service.Image) == "" {
+		v.addf(nameNode, "service %q has no image", name)
+	}
+
+	for _, key := range []string{"env", "secrets"} {
+		if _, envNode := mappingValue(serviceNode, key); envNode != nil {
+			v.checkEnv(key, envNode, name)
+		}
+	}
+
+	if _, exposeNode := mappingValue(serviceNode, "expose"); exposeNode != nil && exposeNode.Kind == yamlv3.SequenceNode {
+		servicePorts := make(map[string]bool)
+		for _, item := range exposeNode.Content {
+			portKey, portNode := mappingValue(item, "port")
+			if portNode == nil {
+				v.addf(item, "expose of service %q has no port", name)
+			} else {
+				v.checkPort(portKey, portNode, name)
+			}
+			asKey, asNode := mappingValue(item, "as")
+			if asNode != nil && asNode.Value != "0" {
+				v.checkPort(asKey, asNode, name)
+			}
+			portProtocol := corev1.ProtocolTCP
+			if _, protocolNode := mappingValue(item, "protocol"); protocolNode != nil {
+				portProtocol = getProtocol(protocolNode.Value)
+				switch strings.ToLower(protocolNode.Value) {
+				case "", ExposeHttp, ExposeTcp, ExposeUdp:
+				default:
+					v.addf(protocolNode, "protocol %q of service %q must be http, tcp or udp", protocolNode.Value, name)
+				}
+			}
+
+			servicePortNode := asNode
+			if servicePortNode == nil || servicePortNode.Value == "0" {
+				servicePortNode = portNode
+			}
+			if servicePortNode != nil {
+				key := string(portProtocol) + "/" + servicePortNode.Value
+				if servicePorts[key] {
+					v.addf(servicePortNode, "port %s of service %q is exposed more than once", servicePortNode.Value, name)
+				}
+				servicePorts[key] = true
+			}
+		}
+	}
+
+	if _, dependsNode := mappingValue(serviceNode, "depends-on"); dependsNode != nil && dependsNode.Kind == yamlv3.SequenceNode {
+		for _, item := range dependsNode.Content {
+			if _, ok := deploy.Services[item.Value]; !ok {
+				v.addf(item, "service %q depends on an undefined service %q", name, item.Value)
+			}
+		}
+	}
+
+	if _, modelsNode := mappingValue(serviceNode, "models"); modelsNode != nil && modelsNode.Kind == yamlv3.SequenceNode {
+		for _, item := range modelsNode.Content {
+			v.checkModel(item, name)
+		}
+	}
+
+	known := knownPlaceholders(service.DependsOn)
+	for _, key := range []string{"env", "command", "args"} {
+		if _, valueNode := mappingValue(serviceNode, key); valueNode != nil {
+			v.checkPlaceholders(valueNode, known, name)
+		}
+	}
+
+	if _, configNode := mappingValue(serviceNode, "config"); configNode != nil {
+		switch configNode.Kind {
+		case yamlv3.SequenceNode:
+			for _, item := range configNode.Content {
+				v.checkConfig(item, "source", "target", known, name)
+			}
+		case yamlv3.MappingNode:
+			v.checkConfig(configNode, "name", "path", known, name)
+		}
+	}
+
+	if _, persistentNode := mappingValue(serviceNode, "persistent"); persistentNode != nil && persistentNode.Kind == yamlv3.SequenceNode {
+		for _, item := range persistentNode.Content {
+			_, nameValue := mappingValue(item, "name")
+			_, mountValue := mappingValue(item, "mount")
+			if nameValue == nil || nameValue.Value == "" || mountValue == nil || mountValue.Value == "" {
+				v.addf(item, "persistent storage of service %q needs a name and a mount", name)
+			}
+		}
+	}
+}
+
+// checkDeployYamlV3 checks the settings v3 adds to the services, the rest of
+// the document is checked like a v2 one.
+func (v *validator) checkDeployYamlV3(doc *yamlv3.Node) {
+	v.checkDeployYamlV2(doc)
+
+	_, servicesNode := mappingValue(doc, "services")
+	if servicesNode == nil || servicesNode.Kind != yamlv3.MappingNode {
+		return
+	}
+	containerNames := make(map[string]bool)
+	for i := 0; i+1 < len(servicesNode.Content); i += 2 {
+		containerNames[servicesNode.Content[i].Value] = true
+	}
+	var deploy DeployYamlV3
+	// type errors are already reported by checkSchema
+	_ = doc.Decode(&deploy)
+	for i := 0; i+1 < len(servicesNode.Content); i += 2 {
+		name, serviceNode := servicesNode.Content[i].Value, servicesNode.Content[i+1]
+		known := knownPlaceholders(deploy.Services[name].DependsOn)
+
+		if _, workingDirNode := mappingValue(serviceNode, "working-dir"); workingDirNode != nil && workingDirNode.Value != "" && !strings.HasPrefix(workingDirNode.Value, "/") {
+			v.addf(workingDirNode, "working-dir of service %q must be an absolute path", name)
+		}
+		for _, key := range []string{"user", "group"} {
+			if _, idNode := mappingValue(serviceNode, key); idNode != nil {
+				if id, err := strconv.ParseInt(idNode.Value, 10, 64); err == nil && id < 0 {
+					v.addf(idNode, "%s of service %q must not be negative", key, name)
+				}
+			}
+		}
+
+		_, probesNode := mappingValue(serviceNode, "probes")
+		for _, kind := range []string{"liveness", "readiness", "startup"} {
+			if _, probeNode := mappingValue(probesNode, kind); probeNode != nil {
+				v.checkProbe(probeNode, kind, name)
+			}
+		}
+
+		if _, initNode := mappingValue(serviceNode, "init"); initNode != nil && initNode.Kind == yamlv3.SequenceNode {
+			for _, item := range initNode.Content {
+				nameKey, initNameNode := mappingValue(item, "name")
+				switch {
+				case initNameNode == nil || initNameNode.Value == "":
+					v.addf(item, "init container of service %q has no name", name)
+				case len(validation.IsDNS1123Label(initNameNode.Value)) > 0:
+					v.addf(initNameNode, "%s %q of an init container of service %q must be a lowercase RFC 1123 label", nameKey.Value, initNameNode.Value, name)
+				case containerNames[initNameNode.Value]:
+					v.addf(initNameNode, "init container name %q of service %q is already used", initNameNode.Value, name)
+				default:
+					containerNames[initNameNode.Value] = true
+				}
+				if _, imageNode := mappingValue(item, "image"); imageNode == nil || strings.TrimSpace(imageNode.Value) == "" {
+					v.addf(item, "init container of service %q has no image", name)
+				}
+				if _, envNode := mappingValue(item, "env"); envNode != nil {
+					v.checkEnv("env", envNode, name)
+				}
+				for _, key := range []string{"env", "command", "args"} {
+					if _, valueNode := mappingValue(item, key); valueNode != nil {
+						v.checkPlaceholders(valueNode, known, name)
+					}
+				}
+				_, restartNode := mappingValue(item, "restart")
+				if restartNode != nil && restartNode.Value != "" && restartNode.Value != "always" {
+					v.addf(restartNode, "restart of an init container of service %q must be always or unset", name)
+				}
+				profileKey, profileNode := mappingValue(item, "profile")
+				switch {
+				case profileNode != nil && profileNode.Value != "":
+					if _, ok := deploy.Profiles.Compute[profileNode.Value]; !ok {
+						v.addf(profileNode, "%s of an init container of service %q is an undefined compute profile %q", profileKey.Value, name, profileNode.Value)
+					}
+				case restartNode != nil && restartNode.Value == "always":
+					// a sidecar runs as long as the pod, it is paid like the services
+					v.addf(restartNode, "sidecar of service %q needs a compute profile", name)
+				}
+			}
+		}
+
+		if _, standaloneNode := mappingValue(serviceNode, "standalone"); standaloneNode != nil && standaloneNode.Value == "true" {
+			v.checkStandalone(standaloneNode, serviceNode, name, doc)
+		}
+
+		_, terminationNode := mappingValue(serviceNode, "termination")
+		if _, graceNode := mappingValue(terminationNode, "grace-period"); graceNode != nil {
+			if grace, err := strconv.ParseInt(graceNode.Value, 10, 64); err == nil && grace < 0 {
+				v.addf(graceNode, "grace-period of service %q must not be negative", name)
+			}
+		}
+	}
+}
+
+// checkStandalone checks a service that runs in its own Deployment: its name
+// is part of k8s names built from the space uuid, it is waited for on a tcp
+// port and it is paid through its own compute profile.
+func (v *validator) checkStandalone(standaloneNode, serviceNode *yamlv3.Node, name string, doc *yamlv3.Node) {
+	if len(name) > maxStandaloneNameLength {
+		v.addf(standaloneNode, "name of the standalone service %q must be at most %d characters", name, maxStandaloneNameLength)
+	}
+
+	var hasTcpPort bool
+	if _, exposeNode := mappingValue(serviceNode, "expose"); exposeNode != nil {
+		for _, item := range exposeNode.Content {
+			if _, protocolNode := mappingValue(item, "protocol"); protocolNode == nil || strings.ToLower(protocolNode.Value) != ExposeUdp {
+				hasTcpPort = true
+			}
+		}
+	}
+	if !hasTcpPort {
+		v.addf(standaloneNode, "standalone service %q must expose a tcp port", name)
+	}
+
+	_, deploymentNode := mappingValue(doc, "deployment")
+	_, placementsNode := mappingValue(deploymentNode, name)
+	var hasProfile bool
+	for _, placement := range []string{"akash", "lagrange"} {
+		_, placementNode := mappingValue(placementsNode, placement)
+		if _, profileNode := mappingValue(placementNode, "profile"); profileNode != nil && profileNode.Value != "" {
+			hasProfile = true
+		}
+	}
+	if !hasProfile {
+		v.addf(standaloneNode, "standalone service %q needs a deployment with a compute profile", name)
+	}
+}
+
+// checkProbe checks that a probe uses exactly one check and valid timings.
+func (v *validator) checkProbe(probeNode *yamlv3.Node, kind, service string) {
+	var handlers int
+	httpKey, httpNode := mappingValue(probeNode, "http")
+	if httpNode != nil {
+		handlers++
+		if portKey, portNode := mappingValue(httpNode, "port"); portNode != nil {
+			v.checkPort(portKey, portNode, service)
+		} else {
+			v.addf(httpKey, "http %s probe of service %q has no port", kind, service)
+		}
+		if _, pathNode := mappingValue(httpNode, "path"); pathNode != nil && pathNode.Value != "" && !strings.HasPrefix(pathNode.Value, "/") {
+			v.addf(pathNode, "path of the %s probe of service %q must start with /", kind, service)
+		}
+		if _, schemeNode := mappingValue(httpNode, "scheme"); schemeNode != nil && schemeNode.Value != "" && schemeNode.Value != "http" && schemeNode.Value != "https" {
+			v.addf(schemeNode, "scheme of the %s probe of service %q must be http or https", kind, service)
+		}
+	}
+	tcpKey, tcpNode := mappingValue(probeNode, "tcp")
+	if tcpNode != nil {
+		handlers++
+		if portKey, portNode := mappingValue(tcpNode, "port"); portNode != nil {
+			v.checkPort(portKey, portNode, service)
+		} else {
+			v.addf(tcpKey, "tcp %s probe of service %q has no port", kind, service)
+		}
+	}
+	if _, execNode := mappingValue(probeNode, "exec"); execNode != nil && len(execNode.Content) > 0 {
+		handlers++
+	}
+	if handlers != 1 {
+		v.addf(probeNode, "%s probe of service %q must use exactly one of http, tcp or exec", kind, service)
+	}
+
+	for _, key := range []string{"initial-delay", "period", "timeout", "failure-threshold", "success-threshold"} {
+		if _, valueNode := mappingValue(probeNode, key); valueNode != nil {
+			if value, err := strconv.Atoi(valueNode.Value); err == nil && value < 0 {
+				v.addf(valueNode, "%s of the %s probe of service %q must not be negative", key, kind, service)
+			}
+		}
+	}
+	if kind != "readiness" {
+		if _, successNode := mappingValue(probeNode, "success-threshold"); successNode != nil && successNode.Value != "0" && successNode.Value != "1" {
+			v.addf(successNode, "success-threshold of the %s probe of service %q must be 1", kind, service)
+		}
+	}
+}
+
+// checkModel checks a model of a service, it is downloaded to dir/name and
+// compared with its sha256 if one is given.
+func (v *validator) checkModel(modelNode *yamlv3.Node, service string) {
+	_, nameNode := mappingValue(modelNode, "name")
+	if nameNode == nil || nameNode.Value == "" {
+		v.addf(modelNode, "model of service %q has no name", service)
+	} else if strings.Contains(nameNode.Value, "/") {
+		v.addf(nameNode, "model name %q of service %q must be a file name", nameNode.Value, service)
+	}
+	_, urlNode := mappingValue(modelNode, "url")
+	if urlNode == nil || urlNode.Value == "" {
+		v.addf(modelNode, "model of service %q has no url", service)
+	} else if !strings.HasPrefix(urlNode.Value, "http://") && !strings.HasPrefix(urlNode.Value, "https://") {
+		v.addf(urlNode, "model url %q of service %q must be http or https", urlNode.Value, service)
+	}
+	_, dirNode := mappingValue(modelNode, "dir")
+	if dirNode == nil || !strings.HasPrefix(dirNode.Value, "/") {
+		v.addf(modelNode, "model of service %q needs an absolute dir", service)
+	}
+	if _, sumNode := mappingValue(modelNode, "sha256"); sumNode != nil && sumNode.Value != "" && !sha256Pattern.MatchString(sumNode.Value) {
+		v.addf(sumNode, "sha256 %q of a model of service %q must be 64 hex characters", sumNode.Value, service)
+	}
+}
+
+// checkConfig checks a config entry of a service, the source is a path next to
+// the deploy.yaml and the target an absolute path in the container.
+func (v *validator) checkConfig(configNode *yamlv3.Node, sourceKey, targetKey string, known map[string]bool, service string) {
+	_, sourceNode := mappingValue(configNode, sourceKey)
+	if sourceNode == nil || sourceNode.Value == "" {
+		v.addf(configNode, "config of service %q has no %s", service, sourceKey)
+	} else if source := path.Clean(sourceNode.Value); path.IsAbs(source) || source == ".." || strings.HasPrefix(source, "../") {
+		v.addf(sourceNode, "config %s %q of service %q must be a path inside the space", sourceKey, sourceNode.Value, service)
+	} else if v.baseDir != "" {
+		v.checkConfigPlaceholders(sourceNode, source, known, service)
+	}
+	_, targetNode := mappingValue(configNode, targetKey)
+	if targetNode == nil || !path.IsAbs(targetNode.Value) {
+		v.addf(configNode, "config of service %q needs an absolute %s", service, targetKey)
+	}
+	if _, modeNode := mappingValue(configNode, "mode"); modeNode != nil && modeNode.Value != "" {
+		if mode, err := strconv.ParseInt(modeNode.Value, 8, 32); err != nil || mode > 0777 {
+			v.addf(modeNode, "config mode %q of service %q must be octal permission bits like 0644", modeNode.Value, service)
+		}
+	}
+}
+
+// checkConfigPlaceholders reports the unknown placeholders in the text files
+// of a config source, they are expanded like the env when the space is deployed.
+func (v *validator) checkConfigPlaceholders(sourceNode *yamlv3.Node, source string, known map[string]bool, service string) {
+	err := filepath.WalkDir(filepath.Join(v.baseDir, filepath.FromSlash(source)), func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		fileBytes, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if !utf8.Valid(fileBytes) {
+			return nil
+		}
+		name, _ := filepath.Rel(v.baseDir, file)
+		seen := make(map[string]bool)
+		for _, placeholder := range unknownPlaceholders(string(fileBytes), known) {
+			if !seen[placeholder] {
+				seen[placeholder] = true
+				v.addf(sourceNode, "config file %s of service %q uses an unknown placeholder %s, use $%s for a literal one", filepath.ToSlash(name), service, placeholder, placeholder)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			err = pathErr.Err
+		}
+		v.addf(sourceNode, "config %q of service %q can not be read: %v", sourceNode.Value, service, err)
+	}
+}
+
+// checkPlaceholders reports the unknown placeholders in the values of node.
+func (v *validator) checkPlaceholders(node *yamlv3.Node, known map[string]bool, service string) {
+	if node.Kind == yamlv3.ScalarNode {
+		for _, placeholder := range unknownPlaceholders(node.Value, known) {
+			v.addf(node, "service %q uses an unknown placeholder %s, use $%s for a literal one", service, placeholder, placeholder)
+		}
+		return
+	}
+	for _, child := range node.Content {
+		v.checkPlaceholders(child, known, service)
+	}
+}
+
+// checkEnv checks the names of the env or secrets of a service, in the list
+// and in the mapping form.
+func (v *validator) checkEnv(key string, envNode *yamlv3.Node, service string) {
+	switch envNode.Kind {
+	case yamlv3.SequenceNode:
+		for _, item := range envNode.Content {
+			envName, _, ok := strings.Cut(strings.TrimSpace(item.Value), "=")
+			if !ok || envName == "" {
+				v.addf(item, "%s %q of service %q must be in the form NAME=VALUE", key, item.Value, service)
+				continue
+			}
+			if errs := validation.IsEnvVarName(envName); len(errs) > 0 {
+				v.addf(item, "%s name %q of service %q is not valid: %s", key, envName, service, strings.Join(errs, ", "))
+			}
+		}
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(envNode.Content); i += 2 {
+			nameNode := envNode.Content[i]
+			if errs := validation.IsEnvVarName(nameNode.Value); len(errs) > 0 {
+				v.addf(nameNode, "%s name %q of service %q is not valid: %s", key, nameNode.Value, service, strings.Join(errs, ", "))
+			}
+		}
+	}
+}
+
+func (v *validator) checkPort(keyNode, portNode *yamlv3.Node, service string) {
+	port, err := strconv.Atoi(portNode.Value)
+	if err != nil {
+		// reported by checkSchema
+		return
+	}
+	if port < 1 || port > 65535 {
+		v.addf(portNode, "%s %d of service %q must be between 1 and 65535", keyNode.Value, port, service)
+	}
+}
+
+// checkDependencyCycles reports every depends-on entry that closes a cycle.
+func (v *validator) checkDependencyCycles(servicesNode *yamlv3.Node) {
+	depends := make(map[string][]*yamlv3.Node)
+	var names []string
+	for i := 0; i+1 < len(servicesNode.Content); i += 2 {
+		name := servicesNode.Content[i].Value
+		names = append(names, name)
+		if _, dependsNode := mappingValue(servicesNode.Content[i+1], "depends-on"); dependsNode != nil && dependsNode.Kind == yamlv3.SequenceNode {
+			depends[name] = dependsNode.Content
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var stack []string
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, item := range depends[name] {
+			switch state[item.Value] {
+			case visiting:
+				var cycle []string
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i] == item.Value {
+						cycle = append(stack[i:len(stack):len(stack)], item.Value)
+						break
+					}
+				}
+				v.addf(item, "dependency cycle: %s", strings.Join(cycle, " -> "))
+			case unvisited:
+				if _, ok := depends[item.Value]; ok {
+					visit(item.Value)
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+	}
+	for _, name := range names {
+		if state[name] == unvisited {
+			visit(name)
+		}
+	}
+}
+
+// mappingValue returns the key and value nodes of the key in a mapping node.
+func mappingValue(node *yamlv3.Node, key string) (*yamlv3.Node, *yamlv3.Node) {
+	if node == nil || node.Kind != yamlv3.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("yaml")
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && strings.Contains(tag, ",inline") {
+			for inlineName, inlineField := range yamlFields(field.Type) {
+				fields[inlineName] = inlineField
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "the file"
+	}
+	return fmt.Sprintf("%q", path)
+}
@@ -0,0 +1,199 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/swanchain/go-computing-provider/internal/yaml"
+)
+
+func TestHandlerCompose(t *testing.T) {
+	composeFile := `services:
+  web:
+    image: nginx:1.25
+    command: ["nginx", "-g", "daemon off;"]
+    environment:
+      DB_URL: postgres://${DB_HOST}:5432
+    ports:
+      - "8080:80"
+      - "9000:9000/udp"
+    depends_on:
+      db:
+        condition: service_healthy
+    deploy:
+      replicas: 2
+      resources:
+        limits:
+          cpus: "1.5"
+          memory: 512M
+  db:
+    image: postgres:16
+    environment:
+      - POSTGRES_PASSWORD=secret
+    expose:
+      - "5432"
+    healthcheck:
+      test: ["CMD-SHELL", "pg_isready"]
+    volumes:
+      - data:/var/lib/postgresql/data
+volumes:
+  data:
+`
+	composePath := filepath.Join(t.TempDir(), "docker-compose.yml")
+	if err := os.WriteFile(composePath, []byte(composeFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.ValidateFile(composePath); err != nil {
+		t.Fatalf("expected a valid compose file, got: %v", err)
+	}
+
+	containers, err := yaml.HandlerYaml(composePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 1 || containers[0].Name != "web" {
+		t.Fatalf("expected web as the only main container, got: %+v", containers)
+	}
+	web := containers[0]
+	if web.Count != 2 || web.Profile == nil || web.Profile.Resources.Cpu.Units != "1.5" {
+		t.Errorf("unexpected deploy of web: count %d, profile %+v", web.Count, web.Profile)
+	}
+	expected := []yaml.ExposePort{
+		{Port: 80, As: 80, Global: true, Protocol: yaml.ExposeHttp},
+		{Port: 9000, As: 9000, Global: true, Protocol: yaml.ExposeUdp},
+	}
+	if len(web.Exposes) != len(expected) {
+		t.Fatalf("expected exposes %+v, got: %+v", expected, web.Exposes)
+	}
+	for i := range expected {
+		if web.Exposes[i] != expected[i] {
+			t.Errorf("expose %d: expected %+v, got: %+v", i, expected[i], web.Exposes[i])
+		}
+	}
+
+	if len(web.Env) != 1 || web.Env[0].Value != "postgres://${DB_HOST}:5432" {
+		t.Errorf("expected the placeholder in the env of web, got: %+v", web.Env)
+	}
+
+	if len(web.Depends) != 1 || web.Depends[0].Name != "db" {
+		t.Fatalf("expected db as dependency, got: %+v", web.Depends)
+	}
+	db := web.Depends[0]
+	if len(db.Env) != 1 || db.Env[0].Name != "POSTGRES_PASSWORD" || db.Env[0].Value != "secret" {
+		t.Errorf("unexpected env of db: %+v", db.Env)
+	}
+	if strings.Join(db.ReadyCmd, " ") != "/bin/sh -c pg_isready" {
+		t.Errorf("unexpected ready cmd of db: %v", db.ReadyCmd)
+	}
+	if len(db.Persistent) != 1 || db.Persistent[0].Name != "data" || db.Persistent[0].Path != "/var/lib/postgresql/data" {
+		t.Errorf("unexpected persistent of db: %+v", db.Persistent)
+	}
+	if len(db.Exposes) != 1 || db.Exposes[0].Global || db.Exposes[0].Port != 5432 {
+		t.Errorf("unexpected exposes of db: %+v", db.Exposes)
+	}
+}
+
+func TestHandlerComposeUnsupported(t *testing.T) {
+	composeFile := `services:
+  web:
+    build: .
+    privileged: true
+    volumes:
+      - ./html:/usr/share/nginx/html
+  worker:
+    image: busybox
+    network_mode: host
+`
+	dir := t.TempDir()
+	composePath := filepath.Join(dir, "compose.yaml")
+	if err := os.WriteFile(composePath, []byte(composeFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := yaml.HandlerYaml(composePath)
+	var unsupportedErr *yaml.UnsupportedComposeError
+	if !errors.As(err, &unsupportedErr) {
+		t.Fatalf("expected unsupported compose error, got: %v", err)
+	}
+	for _, feature := range []string{"services.web.build", "services.web.privileged", "services.web.volumes", "services.worker.network_mode", "exactly one service"} {
+		if !strings.Contains(unsupportedErr.Error(), feature) {
+			t.Errorf("expected %q to be reported, got:\n%v", feature, unsupportedErr)
+		}
+	}
+}
+
+func TestHandlerComposeFileReferences(t *testing.T) {
+	dir := t.TempDir()
+	secretDir := t.TempDir()
+	secretPath := filepath.Join(secretDir, "secret.env")
+	if err := os.WriteFile(secretPath, []byte("PRIVATE_KEY=deadbeef\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secretPath, filepath.Join(dir, "link.env")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app.env"), []byte("MODE=prod\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	composeFile := `services:
+  web:
+    image: nginx:1.25
+    env_file:
+      - app.env
+      - ` + secretPath + `
+      - path: ../secret.env
+      - link.env
+  worker:
+    image: busybox
+    extends:
+      file: /etc/compose.yaml
+      service: base
+    depends_on:
+      - web
+`
+	composePath := filepath.Join(dir, "compose.yaml")
+	if err := os.WriteFile(composePath, []byte(composeFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	containers, err := yaml.HandlerYaml(composePath)
+	var unsupportedErr *yaml.UnsupportedComposeError
+	if !errors.As(err, &unsupportedErr) {
+		t.Fatalf("expected unsupported compose error, got: %v, containers: %+v", err, containers)
+	}
+	if len(unsupportedErr.Features) != 4 {
+		t.Errorf("expected 4 rejected paths, got:\n%v", unsupportedErr)
+	}
+	for _, feature := range []string{secretPath, "../secret.env", "link.env", "services.worker.extends.file"} {
+		if !strings.Contains(unsupportedErr.Error(), feature) {
+			t.Errorf("expected %q to be reported, got:\n%v", feature, unsupportedErr)
+		}
+	}
+	if strings.Contains(unsupportedErr.Error(), "app.env") {
+		t.Errorf("expected app.env inside the space to be accepted, got:\n%v", unsupportedErr)
+	}
+
+	composeFile = `services:
+  web:
+    image: nginx:1.25
+    env_file: app.env
+`
+	if err = os.WriteFile(composePath, []byte(composeFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	containers, err = yaml.HandlerYaml(composePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, env := range containers[0].Env {
+		found = found || env.Name == "MODE" && env.Value == "prod"
+	}
+	if !found {
+		t.Errorf("expected the env of app.env, got: %+v", containers[0].Env)
+	}
+}
@@ -0,0 +1,67 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	computing2 "github.com/swanchain/go-computing-provider/internal/computing"
+)
+
+type fakeResolver struct {
+	cnames map[string]string
+	txts   map[string][]string
+}
+
+func (r fakeResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	if cname, ok := r.cnames[host]; ok {
+		return cname, nil
+	}
+	return host + ".", nil
+}
+
+func (r fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if records, ok := r.txts[name]; ok {
+		return records, nil
+	}
+	return nil, fmt.Errorf("no such host: %s", name)
+}
+
+func TestVerifyCustomDomain(t *testing.T) {
+	spaceUuid := "3f2b1c9e-0a5d-4e6f-8a7b-1c2d3e4f5a6b"
+	spaceHost := "abcdefghij.cp.example.com"
+	resolver := fakeResolver{
+		cnames: map[string]string{
+			"www.tenant.io":   spaceHost + ".",
+			"other.tenant.io": "zyxwvutsrq.cp.example.com.",
+		},
+		txts: map[string][]string{
+			"_lad-verify.apex.tenant.io":  {"v=spf1 -all", computing2.CustomDomainToken(spaceUuid)},
+			"_lad-verify.stolen.io":       {computing2.CustomDomainToken("another-space")},
+			"_lad-verify.www.tenant.io":   {"unrelated"},
+			"_lad-verify.shared-ip.io":    {},
+			"_lad-verify.other.tenant.io": {"lad-verify=0000"},
+		},
+	}
+
+	cases := []struct {
+		domain string
+		valid  bool
+	}{
+		{"www.tenant.io", true},
+		{"apex.tenant.io", true},
+		{"other.tenant.io", false},
+		{"stolen.io", false},
+		{"shared-ip.io", false},
+		{"missing.io", false},
+	}
+	for _, c := range cases {
+		err := computing2.VerifyCustomDomain(context.TODO(), resolver, c.domain, spaceUuid, spaceHost)
+		if (err == nil) != c.valid {
+			t.Errorf("domain: %s, expected valid: %v, error: %v", c.domain, c.valid, err)
+		}
+	}
+	if computing2.CustomDomainToken(spaceUuid) == computing2.CustomDomainToken("another-space") {
+		t.Errorf("expected a token per space")
+	}
+}
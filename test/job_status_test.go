@@ -0,0 +1,75 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/swanchain/go-computing-provider/internal/models"
+)
+
+func TestJobStatusTransitions(t *testing.T) {
+	statuses := []models.JobStatus{
+		"",
+		models.JobReceived,
+		models.JobDownloadSource,
+		models.JobBuildImage,
+		models.JobPushImage,
+		models.JobPullImage,
+		models.JobDeployToK8s,
+		models.JobRunning,
+		models.JobPaused,
+		models.JobExpired,
+		models.JobFailed,
+		models.JobCancelled,
+	}
+	allowed := map[models.JobStatus][]models.JobStatus{
+		"":                       {models.JobReceived},
+		models.JobReceived:       {models.JobDownloadSource, models.JobFailed, models.JobCancelled},
+		models.JobDownloadSource: {models.JobBuildImage, models.JobPullImage, models.JobFailed, models.JobCancelled},
+		models.JobBuildImage:     {models.JobPushImage, models.JobPullImage, models.JobFailed, models.JobCancelled},
+		models.JobPushImage:      {models.JobPullImage, models.JobFailed, models.JobCancelled},
+		models.JobPullImage:      {models.JobDeployToK8s, models.JobFailed, models.JobCancelled},
+		models.JobDeployToK8s:    {models.JobRunning, models.JobExpired, models.JobFailed, models.JobCancelled},
+		models.JobRunning:        {models.JobReceived, models.JobPaused, models.JobExpired, models.JobFailed, models.JobCancelled},
+		models.JobPaused:         {models.JobReceived, models.JobDeployToK8s, models.JobExpired, models.JobCancelled},
+		models.JobFailed:         {models.JobReceived},
+	}
+
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := false
+			for _, status := range allowed[from] {
+				if status == to {
+					want = true
+				}
+			}
+			if got := from.CanTransitionTo(to); got != want {
+				t.Errorf("%q.CanTransitionTo(%q) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+}
+
+func TestJobStatusTerminal(t *testing.T) {
+	terminal := map[models.JobStatus]bool{
+		models.JobExpired:   true,
+		models.JobFailed:    true,
+		models.JobCancelled: true,
+	}
+	for _, status := range []models.JobStatus{
+		models.JobReceived, models.JobDownloadSource, models.JobBuildImage, models.JobPushImage,
+		models.JobPullImage, models.JobDeployToK8s, models.JobRunning, models.JobPaused,
+		models.JobExpired, models.JobFailed, models.JobCancelled,
+	} {
+		if got := status.IsTerminal(); got != terminal[status] {
+			t.Errorf("%q.IsTerminal() = %v, want %v", status, got, terminal[status])
+		}
+		// a job that is done is only deployed again through received
+		if status.IsTerminal() {
+			for _, next := range []models.JobStatus{models.JobRunning, models.JobDeployToK8s, models.JobPaused} {
+				if status.CanTransitionTo(next) {
+					t.Errorf("terminal %q may move to %q", status, next)
+				}
+			}
+		}
+	}
+}
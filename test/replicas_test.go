@@ -0,0 +1,49 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/swanchain/go-computing-provider/internal/models"
+)
+
+func TestOrderReplicas(t *testing.T) {
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"data":{"space":{"activeOrder":{"config":{"description":"CPU only"}}}}}`, 1},
+		{`{"data":{"space":{"activeOrder":{"replicas":0}}}}`, 1},
+		{`{"data":{"space":{"activeOrder":{"replicas":3}}}}`, 3},
+	}
+	for _, tt := range tests {
+		var space models.SpaceJSON
+		if err := json.Unmarshal([]byte(tt.body), &space); err != nil {
+			t.Fatalf("unmarshal %s: %v", tt.body, err)
+		}
+		if got := space.OrderReplicas(); got != tt.want {
+			t.Errorf("OrderReplicas of %s = %d, want %d", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestCheckReplicas(t *testing.T) {
+	tests := []struct {
+		replicas, maxReplicas int
+		valid                 bool
+	}{
+		{1, 1, true},
+		{3, 3, true},
+		{2, 3, true},
+		{0, 3, false},
+		{-1, 3, false},
+		{4, 3, false},
+		{2, 1, false},
+	}
+	for _, tt := range tests {
+		err := models.CheckReplicas(tt.replicas, tt.maxReplicas)
+		if (err == nil) != tt.valid {
+			t.Errorf("CheckReplicas(%d, %d) = %v, want valid %v", tt.replicas, tt.maxReplicas, err, tt.valid)
+		}
+	}
+}
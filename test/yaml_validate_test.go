@@ -0,0 +1,627 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/swanchain/go-computing-provider/internal/yaml"
+)
+
+func TestValidateDeployYaml(t *testing.T) {
+	deployYaml := `version: "2.0"
+services:
+  web:
+    image: nginx
+    env:
+      - TOKEN
+    expose:
+      - port: 80
+        as: 70000
+    depends-on:
+      - db
+  db:
+    image: postgres
+    depends-on:
+      - web
+deployment:
+  web:
+    lagrange:
+      profile: small
+`
+	err := yaml.Validate([]byte(deployYaml))
+	var validationErrs yaml.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		t.Fatalf("expected validation errors, got: %v", err)
+	}
+
+	expected := []yaml.ValidationError{
+		{Line: 6, Column: 9},
+		{Line: 9, Column: 13},
+		{Line: 15, Column: 9},
+		{Line: 19, Column: 16},
+	}
+	if len(validationErrs) != len(expected) {
+		t.Fatalf("expected %d errors, got:\n%v", len(expected), err)
+	}
+	for i, e := range expected {
+		if validationErrs[i].Line != e.Line || validationErrs[i].Column != e.Column {
+			t.Errorf("expected error at %d:%d, got: %v", e.Line, e.Column, validationErrs[i])
+		}
+	}
+}
+
+func TestDeployYamlEnvForms(t *testing.T) {
+	deployYaml := `version: "2.0"
+services:
+  web:
+    image: nginx
+    env:
+      TOKEN: a2V5PXZhbHVl==
+      URL: https://example.com/?a=1&b=2
+    secrets:
+      - PASSWORD=p=ss
+    expose:
+      - port: 80
+deployment:
+  web:
+    lagrange:
+      count: 1
+`
+	if err := yaml.Validate([]byte(deployYaml)); err != nil {
+		t.Fatalf("expected valid deploy.yaml, got: %v", err)
+	}
+
+	yamlPath := filepath.Join(t.TempDir(), "deploy.yaml")
+	if err := os.WriteFile(yamlPath, []byte(deployYaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	containers, err := yaml.HandlerYaml(yamlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := make(map[string]string)
+	for _, e := range containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	if env["TOKEN"] != "a2V5PXZhbHVl==" || env["URL"] != "https://example.com/?a=1&b=2" {
+		t.Errorf("unexpected env: %v", containers[0].Env)
+	}
+	if len(containers[0].Secrets) != 1 || containers[0].Secrets[0].Value != "p=ss" {
+		t.Errorf("unexpected secrets: %v", containers[0].Secrets)
+	}
+}
+
+func TestDeployYamlEnvMapping(t *testing.T) {
+	deployYaml := `version: "2.0"
+services:
+  web:
+    image: nginx
+    env:
+      VERSION: 1.10
+      FLAG: yes
+      OCTAL: 0755
+      EMPTY:
+      BASE: /srv
+      DATA_DIR: $(BASE)/data
+    expose:
+      - port: 80
+deployment:
+  web:
+    lagrange:
+      count: 1
+`
+	if err := yaml.Validate([]byte(deployYaml)); err != nil {
+		t.Fatalf("expected valid deploy.yaml, got: %v", err)
+	}
+
+	yamlPath := filepath.Join(t.TempDir(), "deploy.yaml")
+	if err := os.WriteFile(yamlPath, []byte(deployYaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	containers, err := yaml.HandlerYaml(yamlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range containers[0].Env {
+		got = append(got, e.Name+"="+e.Value)
+	}
+	// the $(BASE) reference needs BASE before DATA_DIR
+	want := []string{"VERSION=1.10", "FLAG=yes", "OCTAL=0755", "EMPTY=", "BASE=/srv", "DATA_DIR=$(BASE)/data"}
+	if len(got) < len(want) {
+		t.Fatalf("expected env %v, got: %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected env %v, got: %v", want, got)
+			break
+		}
+	}
+}
+
+func TestDeployYamlExposePorts(t *testing.T) {
+	deployYaml := `version: "2.0"
+services:
+  web:
+    image: nginx
+    expose:
+      - port: 3000
+        as: 80
+        to:
+          - global: true
+      - port: 9000
+        protocol: tcp
+        to:
+          - global: true
+      - port: 6379
+deployment:
+  web:
+    lagrange:
+      count: 1
+`
+	yamlPath := filepath.Join(t.TempDir(), "deploy.yaml")
+	if err := os.WriteFile(yamlPath, []byte(deployYaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	containers, err := yaml.HandlerYaml(yamlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []yaml.ExposePort{
+		{Port: 3000, As: 80, Global: true, Protocol: yaml.ExposeHttp},
+		{Port: 9000, As: 9000, Global: true, Protocol: yaml.ExposeTcp},
+		{Port: 6379, As: 6379, Global: false, Protocol: yaml.ExposeHttp},
+	}
+	if len(containers[0].Exposes) != len(expected) {
+		t.Fatalf("unexpected exposes: %+v", containers[0].Exposes)
+	}
+	for i, e := range expected {
+		if containers[0].Exposes[i] != e {
+			t.Errorf("expected %+v, got %+v", e, containers[0].Exposes[i])
+		}
+	}
+}
+
+func TestDeployYamlV3(t *testing.T) {
+	deployYaml := `version: "3.0"
+services:
+  web:
+    image: nginx
+    working-dir: /app
+    user: 1000
+    group: 1000
+    expose:
+      - port: 80
+    probes:
+      liveness:
+        http:
+          path: /healthz
+          port: 80
+        period: 10
+      startup:
+        tcp:
+          port: 80
+        failure-threshold: 30
+    init:
+      - name: migrate
+        image: busybox
+        command: ["sh", "-c", "echo migrate"]
+      - name: proxy
+        image: envoyproxy/envoy
+        restart: always
+        profile: proxy
+    termination:
+      grace-period: 20
+      pre-stop: ["nginx", "-s", "quit"]
+    depends-on:
+      - db
+  db:
+    image: redis
+    probes:
+      readiness:
+        exec: ["redis-cli", "ping"]
+profiles:
+  compute:
+    proxy:
+      resources:
+        cpu:
+          units: 0.5
+        memory:
+          size: 256Mi
+deployment:
+  web:
+    lagrange:
+      count: 1
+`
+	if err := yaml.Validate([]byte(deployYaml)); err != nil {
+		t.Fatalf("expected valid deploy.yaml, got: %v", err)
+	}
+	yamlPath := filepath.Join(t.TempDir(), "deploy.yaml")
+	if err := os.WriteFile(yamlPath, []byte(deployYaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	containers, err := yaml.HandlerYaml(yamlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := containers[0]
+	if web.WorkingDir != "/app" || web.RunAsUser == nil || *web.RunAsUser != 1000 {
+		t.Errorf("unexpected process settings: %q, %v", web.WorkingDir, web.RunAsUser)
+	}
+	if web.LivenessProbe == nil || web.LivenessProbe.HTTPGet == nil || web.LivenessProbe.HTTPGet.Path != "/healthz" || web.LivenessProbe.PeriodSeconds != 10 {
+		t.Errorf("unexpected liveness probe: %+v", web.LivenessProbe)
+	}
+	if web.StartupProbe == nil || web.StartupProbe.TCPSocket == nil || web.StartupProbe.FailureThreshold != 30 {
+		t.Errorf("unexpected startup probe: %+v", web.StartupProbe)
+	}
+	if len(web.InitContainers) != 2 || web.InitContainers[0].Sidecar || !web.InitContainers[1].Sidecar {
+		t.Errorf("unexpected init containers: %+v", web.InitContainers)
+	}
+	if len(web.InitContainers) == 2 && (web.InitContainers[0].Profile != nil || web.InitContainers[1].Profile == nil || web.InitContainers[1].Profile.Resources.Memory.Size != "256Mi") {
+		t.Errorf("unexpected init container profiles: %+v", web.InitContainers)
+	}
+	if web.TerminationGracePeriod == nil || *web.TerminationGracePeriod != 20 || len(web.PreStop) != 3 {
+		t.Errorf("unexpected termination: %v, %v", web.TerminationGracePeriod, web.PreStop)
+	}
+	if len(web.Depends) != 1 || web.Depends[0].ReadinessProbe == nil || web.Depends[0].ReadinessProbe.Exec == nil {
+		t.Errorf("unexpected dependency: %+v", web.Depends)
+	}
+
+	invalidYaml := `version: "3.0"
+services:
+  web:
+    image: nginx
+    probes:
+      liveness:
+        http:
+          port: 80
+        exec: ["true"]
+    init:
+      - name: web
+        image: busybox
+        restart: on-failure
+      - name: proxy
+        image: envoyproxy/envoy
+        restart: always
+      - name: setup
+        image: busybox
+        profile: missing
+deployment:
+  web:
+    lagrange:
+      count: 1
+`
+	err = yaml.Validate([]byte(invalidYaml))
+	var validationErrs yaml.ValidationErrors
+	if !errors.As(err, &validationErrs) || len(validationErrs) != 5 {
+		t.Fatalf("expected 5 validation errors, got: %v", err)
+	}
+}
+
+func TestDeployYamlV2ToV3(t *testing.T) {
+	deployYaml := `version: "2.0"
+services:
+  web:
+    image: nginx
+    expose:
+      - port: 80
+    depends-on:
+      - db
+  db:
+    image: redis
+    ready-cmd: ["redis-cli", "ping"]
+deployment:
+  web:
+    lagrange:
+      count: 1
+`
+	yamlPath := filepath.Join(t.TempDir(), "deploy.yaml")
+	if err := os.WriteFile(yamlPath, []byte(deployYaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	containers, err := yaml.HandlerYaml(yamlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 1 || len(containers[0].Depends) != 1 {
+		t.Fatalf("unexpected containers: %+v", containers)
+	}
+	probe := containers[0].Depends[0].ReadinessProbe
+	if probe == nil || probe.Exec == nil || len(probe.Exec.Command) != 2 || probe.InitialDelaySeconds != 5 || probe.PeriodSeconds != 5 {
+		t.Errorf("expected the ready-cmd as readiness probe, got: %+v", probe)
+	}
+	if containers[0].ReadinessProbe != nil {
+		t.Errorf("expected no probe on the main container, got: %+v", containers[0].ReadinessProbe)
+	}
+}
+
+func TestDeployYamlStandaloneDependency(t *testing.T) {
+	deployYaml := `version: "3.0"
+services:
+  web:
+    image: nginx
+    expose:
+      - port: 80
+    depends-on:
+      - db
+  db:
+    image: postgres
+    standalone: true
+    expose:
+      - port: 5432
+        protocol: tcp
+profiles:
+  compute:
+    db:
+      resources:
+        cpu:
+          units: 1
+        memory:
+          size: 1Gi
+deployment:
+  web:
+    lagrange:
+      count: 1
+  db:
+    lagrange:
+      profile: db
+      count: 2
+`
+	if err := yaml.Validate([]byte(deployYaml)); err != nil {
+		t.Fatalf("expected valid deploy.yaml, got: %v", err)
+	}
+	yamlPath := filepath.Join(t.TempDir(), "deploy.yaml")
+	if err := os.WriteFile(yamlPath, []byte(deployYaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	containers, err := yaml.HandlerYaml(yamlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 1 || len(containers[0].Depends) != 1 {
+		t.Fatalf("unexpected containers: %+v", containers)
+	}
+	db := containers[0].Depends[0]
+	if !db.Standalone || db.Count != 2 || db.Profile == nil {
+		t.Errorf("unexpected standalone dependency: %+v", db)
+	}
+
+	invalidYaml := `version: "3.0"
+services:
+  web:
+    image: nginx
+    expose:
+      - port: 80
+    depends-on:
+      - db
+  db:
+    image: postgres
+    standalone: true
+deployment:
+  web:
+    lagrange:
+      count: 1
+`
+	err = yaml.Validate([]byte(invalidYaml))
+	var validationErrs yaml.ValidationErrors
+	if !errors.As(err, &validationErrs) || len(validationErrs) != 2 {
+		t.Fatalf("expected 2 validation errors, got: %v", err)
+	}
+}
+
+func TestDeployYamlModels(t *testing.T) {
+	deployYaml := `version: "2.0"
+services:
+  web:
+    image: nginx
+    expose:
+      - port: 80
+    models:
+      - name: model.bin
+        url: https://example.com/model.bin
+        dir: /models
+        sha256: 17b901ac85fd0374d0fd009ce5a88d9b51bfbd3cf67ece4eaf03cde7eed5f4f6
+      - name: sub/model.bin
+        url: ftp://example.com/model.bin
+        dir: models
+        sha256: abc
+deployment:
+  web:
+    lagrange:
+      count: 1
+`
+	err := yaml.Validate([]byte(deployYaml))
+	var validationErrs yaml.ValidationErrors
+	if !errors.As(err, &validationErrs) || len(validationErrs) != 4 {
+		t.Fatalf("expected 4 validation errors, got: %v", err)
+	}
+}
+
+func TestDeployYamlConfig(t *testing.T) {
+	deployYaml := `version: "2.0"
+services:
+  web:
+    image: nginx
+    expose:
+      - port: 80
+    config:
+      - source: conf
+        target: /etc/app
+        mode: "0640"
+      - source: ../secret
+        target: etc
+        mode: 0999
+  db:
+    image: postgres
+    expose:
+      - port: 5432
+    config:
+      name: app.ini
+      path: /etc/db
+deployment:
+  web:
+    lagrange:
+      count: 1
+`
+	err := yaml.Validate([]byte(deployYaml))
+	var validationErrs yaml.ValidationErrors
+	if !errors.As(err, &validationErrs) || len(validationErrs) != 3 {
+		t.Fatalf("expected 3 validation errors, got: %v", err)
+	}
+
+	dir := t.TempDir()
+	deployPath := filepath.Join(dir, "deploy.yaml")
+	deployYaml = `version: "2.0"
+services:
+  web:
+    image: nginx
+    expose:
+      - port: 80
+    depends-on:
+      - db
+    config:
+      - source: conf
+        target: /etc/app
+        mode: "0640"
+  db:
+    image: postgres
+    config:
+      name: app.ini
+      path: /etc/db
+deployment:
+  web:
+    lagrange:
+      count: 1
+`
+	if err = os.WriteFile(deployPath, []byte(deployYaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	containers, err := yaml.HandlerYaml(deployPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs := containers[0].VolumeMounts
+	if len(configs) != 1 || configs[0].Name != "conf" || configs[0].Path != "/etc/app" || configs[0].Mode == nil || *configs[0].Mode != 0640 {
+		t.Fatalf("unexpected configs of web: %+v", configs)
+	}
+	configs = containers[0].Depends[0].VolumeMounts
+	if len(configs) != 1 || configs[0].Name != "app.ini" || configs[0].Path != "/etc/db/app.ini" {
+		t.Fatalf("unexpected configs of db: %+v", configs)
+	}
+}
+
+func TestDeployYamlPlaceholders(t *testing.T) {
+	deployYaml := `version: "2.0"
+services:
+  web:
+    image: nginx
+    env:
+      - NEXTAUTH_URL=${SPACE_URL}
+      - DB_URL=postgres://${DB_HOST}:5432
+      - HOME_DIR=$${HOME}
+      - CACHE_URL=redis://${CACHE_HOST}
+    args:
+      - --expire=${EXPIRE_AT}
+      - --token=${TOKEN}
+    expose:
+      - port: 80
+    depends-on:
+      - db
+  db:
+    image: postgres
+    expose:
+      - port: 5432
+deployment:
+  web:
+    lagrange:
+      count: 1
+`
+	err := yaml.Validate([]byte(deployYaml))
+	var validationErrs yaml.ValidationErrors
+	if !errors.As(err, &validationErrs) || len(validationErrs) != 2 {
+		t.Fatalf("expected 2 validation errors, got: %v", err)
+	}
+
+	values := map[string]string{yaml.PlaceholderSpaceUrl: "https://space.example.com", yaml.DependencyHostPlaceholder("db"): "localhost"}
+	expanded := yaml.ExpandPlaceholders("url=${SPACE_URL} db=${DB_HOST} home=$${HOME} token=${TOKEN}", values)
+	if expanded != "url=https://space.example.com db=localhost home=${HOME} token=${TOKEN}" {
+		t.Fatalf("unexpected expansion: %s", expanded)
+	}
+}
+
+func TestValidateConfigPlaceholders(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"conf/app.conf":   []byte("url=${SPACE_URL}\ndb=${DB_HOST}\nhome=$${HOME}\n"),
+		"conf/nginx.conf": []byte("server_name ${SERVER_NAME};\nroot ${SERVER_NAME};\n"),
+		"conf/logo.bin":   {0xff, 0xfe, '$', '{', 'X', '}'},
+		"app.ini":         []byte("token=${TOKEN}\n"),
+	}
+	for name, data := range files {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deployYaml := `version: "2.0"
+services:
+  web:
+    image: nginx
+    expose:
+      - port: 80
+    depends-on:
+      - db
+    config:
+      - source: conf
+        target: /etc/app
+      - source: missing.conf
+        target: /etc/missing
+  db:
+    image: postgres
+    expose:
+      - port: 5432
+    config:
+      name: app.ini
+      path: /etc/db
+deployment:
+  web:
+    lagrange:
+      count: 1
+`
+	deployPath := filepath.Join(dir, "deploy.yaml")
+	if err := os.WriteFile(deployPath, []byte(deployYaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// without the directory of the space the config files are not read
+	if err := yaml.Validate([]byte(deployYaml)); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	err := yaml.ValidateFile(deployPath)
+	var validationErrs yaml.ValidationErrors
+	if !errors.As(err, &validationErrs) || len(validationErrs) != 3 {
+		t.Fatalf("expected 3 validation errors, got: %v", err)
+	}
+	for _, want := range []string{
+		`config file conf/nginx.conf of service "web" uses an unknown placeholder ${SERVER_NAME}`,
+		`config "missing.conf" of service "web" can not be read`,
+		`config file app.ini of service "db" uses an unknown placeholder ${TOKEN}`,
+	} {
+		found := false
+		for _, validationErr := range validationErrs {
+			if strings.Contains(validationErr.Message, want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("missing validation error %q in: %v", want, err)
+		}
+	}
+}
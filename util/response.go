@@ -64,6 +64,10 @@ const (
 	CheckResourcesError     = 9001
 	CheckAvailableResources = 9002
 	CheckWhiteListError     = 9003
+	JobStatusError          = 9004
+	NotFoundJobError        = 9005
+	ScaleJobError           = 9006
+	CustomDomainError       = 9007
 )
 
 var codeMsg = map[int]string{
@@ -78,4 +82,8 @@ var codeMsg = map[int]string{
 	CheckResourcesError:     "An error occurred while check resources available",
 	CheckAvailableResources: "No resources available",
 	CheckWhiteListError:     "This cp does not accept tasks from wallet addresses outside the whitelist",
+	JobStatusError:          "An error occurred while update the job status",
+	NotFoundJobError:        "The job was not found",
+	ScaleJobError:           "An error occurred while scale the job",
+	CustomDomainError:       "The custom domain is not valid",
 }